@@ -0,0 +1,323 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dirtbags/moth/v4/pkg/transpile"
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v2"
+)
+
+// ServerConfig holds everything needed to start a mothd instance.
+//
+// It can be loaded from a YAML file with LoadServerConfig,
+// and any command-line flags given will override what's in the file.
+type ServerConfig struct {
+	// Theme is the path to theme files
+	Theme string
+
+	// State is the path to state files
+	State string
+
+	// Puzzles lists paths to puzzle source trees.
+	// Providing any puzzle trees enables development mode.
+	Puzzles []string
+
+	// Generated lists paths to puzzle source trees
+	// whose generated puzzles get a separate variant for each team.
+	// Unlike Puzzles, these do not enable development mode.
+	Generated []string
+
+	// Mothballs lists paths to directories of mothball files
+	Mothballs []string
+
+	// SwapPolicy says what to do when a replacement mothball
+	// removes, or changes the answers to, a puzzle some team has solved:
+	// SwapRefuse or SwapWarn
+	SwapPolicy string
+
+	// Commands lists external programs which provide puzzles
+	Commands []ProviderCommand
+
+	// Remotes lists remote HTTP services which provide puzzles
+	Remotes []RemoteConfig
+
+	// Hills lists things teams compete to hold, earning points over and over
+	Hills []HillConfig
+
+	// FileTimeout is how long puzzle generators get to produce a file
+	FileTimeout time.Duration
+
+	// MaxFileSize is the largest file puzzle generators may produce, in bytes.
+	// -1 means no limit.
+	MaxFileSize int64
+
+	// Sandbox controls how puzzle generators are isolated.
+	// If it isolates generators, the state directory is always hidden.
+	Sandbox transpile.SandboxConfig
+
+	// Refresh is the duration between maintenance tasks
+	Refresh time.Duration
+
+	// Bind is the [host]:port for HTTP service
+	Bind string
+
+	// Base is the base URL of this instance
+	Base string
+
+	// Seed is the random seed to use, overriding $SEED
+	Seed string
+}
+
+// DefaultServerConfig returns the configuration used when nothing else is specified.
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Theme:       "theme",
+		State:       "state",
+		SwapPolicy:  SwapRefuse,
+		FileTimeout: transpile.DefaultCommandConfig.FileTimeout,
+		MaxFileSize: transpile.DefaultCommandConfig.MaxFileSize,
+		Refresh:     2 * time.Second,
+		Bind:        ":8080",
+		Base:        "/",
+	}
+}
+
+// commandConfig returns the defaults for running puzzle generators and provider commands.
+//
+// If generators are isolated, the state directory is hidden from them.
+// Provider commands are run by the server's operator,
+// so they should be run without the sandbox.
+func (c ServerConfig) commandConfig() (transpile.CommandConfig, error) {
+	cc := transpile.CommandConfig{
+		FileTimeout: c.FileTimeout,
+		MaxFileSize: c.MaxFileSize,
+		Sandbox:     c.Sandbox,
+	}
+	if cc.Sandbox.Isolate {
+		p, err := filepath.Abs(c.State)
+		if err != nil {
+			return cc, err
+		}
+		cc.Sandbox.Hide = append(append([]string{}, c.Sandbox.Hide...), p)
+	}
+	return cc.Merge(transpile.DefaultCommandConfig), nil
+}
+
+// stringsFlag is a flag.Value which may be specified more than once.
+type stringsFlag []string
+
+func (sf *stringsFlag) String() string {
+	return strings.Join(*sf, ",")
+}
+
+func (sf *stringsFlag) Set(value string) error {
+	*sf = append(*sf, value)
+	return nil
+}
+
+// Devel returns true if this configuration calls for a development server.
+func (c ServerConfig) Devel() bool {
+	return len(c.Puzzles) > 0
+}
+
+// ReadServerConfig reads YAML from r on top of c.
+//
+// Fields not mentioned in the YAML are left alone.
+func (c *ServerConfig) ReadServerConfig(r io.Reader) error {
+	decoder := yaml.NewDecoder(r)
+	decoder.SetStrict(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// LoadServerConfig reads a YAML configuration file from fs on top of c.
+func (c *ServerConfig) LoadServerConfig(fs afero.Fs, filename string) error {
+	f, err := fs.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := c.ReadServerConfig(f); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return nil
+}
+
+// ParseServerConfig parses command-line arguments into a ServerConfig.
+//
+// If -config is given, that file is loaded first,
+// and then any other flags provided override values from the file.
+func ParseServerConfig(fs afero.Fs, name string, args []string) (ServerConfig, error) {
+	config := DefaultServerConfig()
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := flags.String(
+		"config",
+		"",
+		"Path to YAML configuration file",
+	)
+	themePath := flags.String(
+		"theme",
+		config.Theme,
+		"Path to theme files",
+	)
+	statePath := flags.String(
+		"state",
+		config.State,
+		"Path to state files",
+	)
+	var puzzlePaths, generatedPaths, mothballPaths, commandPaths, remoteURLs stringsFlag
+	flags.Var(
+		&puzzlePaths,
+		"puzzles",
+		"Path to puzzles tree (enables development mode; may be repeated)",
+	)
+	flags.Var(
+		&generatedPaths,
+		"generated",
+		"Path to puzzles tree to generate per-team puzzles from (may be repeated)",
+	)
+	flags.Var(
+		&mothballPaths,
+		"mothballs",
+		"Path to mothball files (default \"mothballs\"; may be repeated)",
+	)
+	flags.Var(
+		&commandPaths,
+		"command",
+		"Path to a puzzle provider command (may be repeated)",
+	)
+	flags.Var(
+		&remoteURLs,
+		"remote",
+		"URL of a remote puzzle provider (may be repeated)",
+	)
+	swapPolicy := flags.String(
+		"swap-policy",
+		config.SwapPolicy,
+		"What to do when a new mothball changes solved puzzles: refuse or warn",
+	)
+	fileTimeout := flags.Duration(
+		"file-timeout",
+		config.FileTimeout,
+		"How long puzzle generators get to produce a file",
+	)
+	maxFileSize := flags.Int64(
+		"max-file-size",
+		config.MaxFileSize,
+		"Largest file puzzle generators may produce, in bytes (-1 for no limit)",
+	)
+	sandbox := flags.Bool(
+		"sandbox",
+		false,
+		"Isolate puzzle generators from the network and state directory (Linux only)",
+	)
+	refreshInterval := flags.Duration(
+		"refresh",
+		config.Refresh,
+		"Duration between maintenance tasks",
+	)
+	bindStr := flags.String(
+		"bind",
+		config.Bind,
+		"Bind [host]:port for HTTP service",
+	)
+	base := flags.String(
+		"base",
+		config.Base,
+		"Base URL of this instance",
+	)
+	seed := flags.String(
+		"seed",
+		"",
+		"Random seed to use, overrides $SEED",
+	)
+	if err := flags.Parse(args); err != nil {
+		return config, err
+	}
+
+	if *configPath != "" {
+		if err := config.LoadServerConfig(fs, *configPath); err != nil {
+			return config, err
+		}
+	}
+
+	// Flags provided on the command line win over the configuration file
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "theme":
+			config.Theme = *themePath
+		case "state":
+			config.State = *statePath
+		case "puzzles":
+			config.Puzzles = nil
+			for _, p := range puzzlePaths {
+				if p != "" {
+					config.Puzzles = append(config.Puzzles, p)
+				}
+			}
+		case "generated":
+			config.Generated = generatedPaths
+		case "mothballs":
+			config.Mothballs = mothballPaths
+		case "swap-policy":
+			config.SwapPolicy = *swapPolicy
+		case "command":
+			config.Commands = make([]ProviderCommand, len(commandPaths))
+			for i, p := range commandPaths {
+				config.Commands[i] = ProviderCommand{Path: p}
+			}
+		case "remote":
+			config.Remotes = make([]RemoteConfig, len(remoteURLs))
+			for i, u := range remoteURLs {
+				config.Remotes[i] = RemoteConfig{URL: u}
+			}
+		case "file-timeout":
+			config.FileTimeout = *fileTimeout
+		case "max-file-size":
+			config.MaxFileSize = *maxFileSize
+		case "sandbox":
+			config.Sandbox.Isolate = *sandbox
+		case "refresh":
+			config.Refresh = *refreshInterval
+		case "bind":
+			config.Bind = *bindStr
+		case "base":
+			config.Base = *base
+		case "seed":
+			config.Seed = *seed
+		}
+	})
+	if len(config.Puzzles)+len(config.Generated)+len(config.Mothballs)+len(config.Commands)+len(config.Remotes) == 0 {
+		config.Mothballs = []string{"mothballs"}
+	}
+	if (config.SwapPolicy != SwapRefuse) && (config.SwapPolicy != SwapWarn) {
+		return config, fmt.Errorf("swap policy must be %s or %s: %q", SwapRefuse, SwapWarn, config.SwapPolicy)
+	}
+	if config.Refresh <= 0 {
+		return config, fmt.Errorf("refresh interval must be positive: %v", config.Refresh)
+	}
+	if config.MaxFileSize == 0 {
+		return config, fmt.Errorf("max file size can't be 0: use -1 for no limit")
+	}
+	if (len(config.Generated) > 0) && (config.Seed == "") && (os.Getenv("SEED") == "") {
+		// A random seed would give every team new puzzles if the server restarted
+		return config, fmt.Errorf("-generated needs a seed that won't change during the event: use -seed or $SEED")
+	}
+	for _, hill := range config.Hills {
+		if err := hill.check(); err != nil {
+			return config, err
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestServerConfig(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "moth.yaml", []byte(`---
+theme: /srv/moth/theme
+mothballs:
+  - /srv/moth/mothballs
+  - /srv/moth/more-mothballs
+refresh: 10s
+bind: 127.0.0.1:8080
+sandbox:
+  wrapper: [bwrap, --unshare-net, --]
+`), 0644)
+	afero.WriteFile(fs, "bad.yaml", []byte("colour: mauve\n"), 0644)
+	afero.WriteFile(fs, "hills.yaml", []byte(`---
+hills:
+  - name: web
+    category: hills
+    points: 5
+    interval: 30s
+    url: http://checker.example.com/web
+  - name: ssh
+    category: hills
+    points: 1
+    command: /srv/moth/bin/check-ssh
+    args: [10.0.0.22]
+`), 0644)
+	afero.WriteFile(fs, "badhill.yaml", []byte(`---
+hills:
+  - name: web
+    category: hills
+    points: 5
+`), 0644)
+
+	if config, err := ParseServerConfig(fs, "mothd", []string{}); err != nil {
+		t.Error(err)
+	} else if config.Theme != "theme" {
+		t.Error("Wrong default theme:", config.Theme)
+	} else if config.Devel() {
+		t.Error("Default configuration is a development server")
+	}
+
+	config, err := ParseServerConfig(fs, "mothd", []string{"-config", "moth.yaml", "-bind", ":80"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Theme != "/srv/moth/theme" {
+		t.Error("Theme not loaded from file:", config.Theme)
+	}
+	if config.State != "state" {
+		t.Error("State should have been left at its default:", config.State)
+	}
+	if len(config.Mothballs) != 2 {
+		t.Error("Wrong mothballs list:", config.Mothballs)
+	}
+	if config.Refresh != 10*time.Second {
+		t.Error("Wrong refresh interval:", config.Refresh)
+	}
+	if config.Bind != ":80" {
+		t.Error("Flag didn't override configuration file:", config.Bind)
+	}
+	if (len(config.Sandbox.Wrapper) != 3) || config.Sandbox.Isolate {
+		t.Error("Wrong sandbox configuration:", config.Sandbox)
+	}
+
+	if config, err := ParseServerConfig(fs, "mothd", []string{"-config", "moth.yaml", "-sandbox"}); err != nil {
+		t.Error(err)
+	} else if !config.Sandbox.Isolate || (len(config.Sandbox.Wrapper) != 3) {
+		t.Error("-sandbox didn't enable isolation:", config.Sandbox)
+	} else if cc, err := config.commandConfig(); err != nil {
+		t.Error(err)
+	} else if !cc.Sandbox.Isolate || (len(cc.Sandbox.Hide) != len(config.Sandbox.Hide)+1) {
+		t.Error("Generators can see the state directory:", cc.Sandbox)
+	} else if !strings.HasSuffix(cc.Sandbox.Hide[len(cc.Sandbox.Hide)-1], "/state") {
+		t.Error("Wrong directory hidden:", cc.Sandbox.Hide)
+	}
+
+	if config, err := ParseServerConfig(fs, "mothd", []string{"-max-file-size", "-1"}); err != nil {
+		t.Error(err)
+	} else if cc, _ := config.commandConfig(); cc.MaxFileSize != -1 {
+		t.Error("No file size limit replaced:", cc.MaxFileSize)
+	}
+	if _, err := ParseServerConfig(fs, "mothd", []string{"-max-file-size", "0"}); err == nil {
+		t.Error("Ambiguous file size limit accepted")
+	}
+
+	if config, err := ParseServerConfig(fs, "mothd", []string{"-config", "moth.yaml", "-puzzles", "puzzles"}); err != nil {
+		t.Error(err)
+	} else if !config.Devel() {
+		t.Error("-puzzles should enable development mode")
+	}
+
+	if config, err := ParseServerConfig(fs, "mothd", []string{"-puzzles", "a", "-puzzles", "b", "-command", "c"}); err != nil {
+		t.Error(err)
+	} else if len(config.Puzzles) != 2 {
+		t.Error("Repeated -puzzles flag didn't stack:", config.Puzzles)
+	} else if len(config.Mothballs) != 0 {
+		t.Error("Default mothballs directory used when other providers were given:", config.Mothballs)
+	} else if (len(config.Commands) != 1) || (config.Commands[0].Path != "c") {
+		t.Error("Wrong commands:", config.Commands)
+	}
+
+	if config, err := ParseServerConfig(fs, "mothd", []string{"-config", "moth.yaml", "-mothballs", "mb"}); err != nil {
+		t.Error(err)
+	} else if (len(config.Mothballs) != 1) || (config.Mothballs[0] != "mb") {
+		t.Error("-mothballs didn't replace mothballs list:", config.Mothballs)
+	}
+
+	if _, err := ParseServerConfig(fs, "mothd", []string{"-config", "bad.yaml"}); err == nil {
+		t.Error("Unknown configuration field should have raised an error")
+	}
+	if config, err := ParseServerConfig(fs, "mothd", []string{"-config", "hills.yaml"}); err != nil {
+		t.Error(err)
+	} else if len(config.Hills) != 2 {
+		t.Error("Wrong hills:", config.Hills)
+	} else if (config.Hills[0].Interval != 30*time.Second) || (config.Hills[1].Args[0] != "10.0.0.22") {
+		t.Error("Hills loaded wrong:", config.Hills)
+	}
+	if _, err := ParseServerConfig(fs, "mothd", []string{"-config", "badhill.yaml"}); err == nil {
+		t.Error("Hill without a checker should have raised an error")
+	}
+	if _, err := ParseServerConfig(fs, "mothd", []string{"-config", "nonexistent.yaml"}); err == nil {
+		t.Error("Missing configuration file should have raised an error")
+	}
+	if _, err := ParseServerConfig(fs, "mothd", []string{"-refresh", "0s"}); err == nil {
+		t.Error("Zero refresh interval should have raised an error")
+	}
+	if config, err := ParseServerConfig(fs, "mothd", []string{"-swap-policy", "warn"}); err != nil {
+		t.Error(err)
+	} else if config.SwapPolicy != SwapWarn {
+		t.Error("Wrong swap policy:", config.SwapPolicy)
+	}
+	if _, err := ParseServerConfig(fs, "mothd", []string{"-swap-policy", "yolo"}); err == nil {
+		t.Error("Unknown swap policy should have raised an error")
+	}
+
+	t.Setenv("SEED", "")
+	if _, err := ParseServerConfig(fs, "mothd", []string{"-generated", "gen"}); err == nil {
+		t.Error("Generated puzzles without a seed should have raised an error")
+	}
+	if _, err := ParseServerConfig(fs, "mothd", []string{"-generated", "gen", "-seed", "moo"}); err != nil {
+		t.Error(err)
+	}
+	t.Setenv("SEED", "moo")
+	if _, err := ParseServerConfig(fs, "mothd", []string{"-generated", "gen"}); err != nil {
+		t.Error("$SEED should be enough of a seed:", err)
+	}
+}
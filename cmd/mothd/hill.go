@@ -0,0 +1,197 @@
+// Provides recurring awards for holding a hill
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dirtbags/moth/v4/pkg/award"
+	"github.com/dirtbags/moth/v4/pkg/transpile"
+)
+
+// HillConfig describes a hill: something teams compete to hold,
+// like a server they have to keep their flag on.
+//
+// Every Interval, a checker is asked which teams hold the hill,
+// and each of them is awarded Points in Category.
+// The checker is either a command, or a URL.
+// Either way, it gives the IDs of the teams holding the hill,
+// separated by whitespace.
+type HillConfig struct {
+	// Name identifies the hill in the points log.
+	// It must be a valid slug.
+	Name string
+
+	// Category is the category points are awarded in
+	Category string
+
+	// Points is how many points the holders get every tick
+	Points int
+
+	// Interval is how long each tick lasts.
+	// The default is one minute.
+	Interval time.Duration
+
+	// Command is a program which prints the IDs of the teams holding the hill
+	Command string
+	Args    []string
+
+	// URL is fetched with GET, and returns the IDs of the teams holding the hill
+	URL string
+
+	// Timeout is how long the checker gets.
+	// The default is ten seconds.
+	Timeout time.Duration
+}
+
+// check returns an error if hc can't be used.
+func (hc HillConfig) check() error {
+	switch {
+	case !transpile.ValidSlug(hc.Name):
+		return fmt.Errorf("hill name must be a slug: %q", hc.Name)
+	case hc.Category == "":
+		return fmt.Errorf("hill %s: no category", hc.Name)
+	case hc.Points <= 0:
+		return fmt.Errorf("hill %s: points must be positive", hc.Name)
+	case (hc.Command == "") == (hc.URL == ""):
+		return fmt.Errorf("hill %s: needs a command or a URL, but not both", hc.Name)
+	case (hc.Interval != 0) && (hc.Interval < time.Second):
+		return fmt.Errorf("hill %s: interval must be at least a second", hc.Name)
+	case hc.Interval%time.Second != 0:
+		// Ticks are counted in whole seconds from the epoch
+		return fmt.Errorf("hill %s: interval must be a whole number of seconds", hc.Name)
+	}
+	return nil
+}
+
+// Hill awards points to whichever teams hold it, every tick.
+//
+// Ticks are counted from the Unix epoch,
+// so a restarted server picks up where it left off,
+// and never awards the same tick twice.
+type Hill struct {
+	HillConfig
+	State StateProvider
+
+	client *http.Client
+}
+
+// NewHill returns a Hill described by config, awarding points in state.
+func NewHill(config HillConfig, state StateProvider) *Hill {
+	if config.Interval == 0 {
+		config.Interval = time.Minute
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	return &Hill{
+		HillConfig: config,
+		State:      state,
+		client:     &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// tick returns the number of the tick that when falls in.
+func (h *Hill) tick(when time.Time) int64 {
+	return when.Unix() / int64(h.Interval/time.Second)
+}
+
+// tickStart returns when tick starts.
+func (h *Hill) tickStart(tick int64) time.Time {
+	return time.Unix(tick*int64(h.Interval/time.Second), 0)
+}
+
+// Holders asks the checker which teams hold the hill during tick.
+//
+// Commands are run with CAT, HILL, and TICK in their environment,
+// and URLs are given cat, hill, and tick as query parameters.
+func (h *Hill) Holders(tick int64) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+	defer cancel()
+
+	var out []byte
+	if h.Command != "" {
+		cmd := exec.CommandContext(ctx, h.Command, h.Args...)
+		cmd.Env = append(
+			cmd.Environ(),
+			"CAT="+h.Category,
+			"HILL="+h.Name,
+			"TICK="+strconv.FormatInt(tick, 10),
+		)
+		var err error
+		if out, err = cmd.Output(); err != nil {
+			return nil, err
+		}
+	} else {
+		params := url.Values{}
+		params.Set("cat", h.Category)
+		params.Set("hill", h.Name)
+		params.Set("tick", strconv.FormatInt(tick, 10))
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL+"?"+params.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := h.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", h.URL, resp.Status)
+		}
+		if out, err = io.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// award checks who holds the hill at when, and gives them their points.
+//
+// Nothing is awarded while the event is disabled.
+func (h *Hill) award(when time.Time) {
+	if !h.State.Enabled() {
+		return
+	}
+	tick := h.tick(when)
+	holders, err := h.Holders(tick)
+	if err != nil {
+		log.Printf("Hill %s: %v", h.Name, err)
+		return
+	}
+
+	slug := award.RecurringSlug(h.Name, tick)
+	for _, teamID := range holders {
+		if _, err := h.State.TeamName(teamID); err != nil {
+			log.Printf("Hill %s: holder isn't a registered team: %s", h.Name, teamID)
+			continue
+		}
+		if err := h.State.AwardSlug(teamID, h.Category, slug, h.Points); err != nil {
+			// Usually this tick was already awarded, before a restart
+			continue
+		}
+		h.State.LogEvent("hill", teamID, h.Category, h.Points, h.Name, strconv.FormatInt(tick, 10))
+	}
+}
+
+// Maintain checks the hill at the start of every tick.
+// The update interval is ignored: hills have their own.
+func (h *Hill) Maintain(updateInterval time.Duration) {
+	for {
+		next := h.tickStart(h.tick(time.Now()) + 1)
+		time.Sleep(time.Until(next))
+		h.award(next)
+	}
+}
+
+func (h *Hill) refresh() {
+	h.award(time.Now())
+}
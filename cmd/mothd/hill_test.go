@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dirtbags/moth/v4/pkg/award"
+)
+
+func TestHillConfig(t *testing.T) {
+	good := HillConfig{Name: "web", Category: "hills", Points: 1, URL: "http://localhost/"}
+	if err := good.check(); err != nil {
+		t.Error(err)
+	}
+
+	for _, bad := range []HillConfig{
+		{Name: "Web Server", Category: "hills", Points: 1, URL: "http://localhost/"},
+		{Name: "web", Points: 1, URL: "http://localhost/"},
+		{Name: "web", Category: "hills", URL: "http://localhost/"},
+		{Name: "web", Category: "hills", Points: 1},
+		{Name: "web", Category: "hills", Points: 1, URL: "http://localhost/", Command: "true"},
+		{Name: "web", Category: "hills", Points: 1, URL: "http://localhost/", Interval: time.Millisecond},
+		{Name: "web", Category: "hills", Points: 1, URL: "http://localhost/", Interval: 1500 * time.Millisecond},
+	} {
+		if err := bad.check(); err == nil {
+			t.Error("Bad hill passed check:", bad)
+		}
+	}
+}
+
+func TestHill(t *testing.T) {
+	server := NewTestServer()
+	state := server.State.(*State)
+	go slurp(state.refreshNow)
+	defer close(state.refreshNow)
+
+	handler := server.NewHandler(TestTeamID)
+	if err := handler.Register("team"); err != nil {
+		t.Fatal(err)
+	}
+	state.refresh()
+
+	var query map[string]string
+	checker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query = map[string]string{
+			"cat":  req.FormValue("cat"),
+			"hill": req.FormValue("hill"),
+			"tick": req.FormValue("tick"),
+		}
+		fmt.Fprintln(w, TestTeamID, "intruder")
+	}))
+	defer checker.Close()
+
+	hill := NewHill(
+		HillConfig{Name: "web", Category: "pategory", Points: 10, URL: checker.URL},
+		state,
+	)
+	if hill.Interval != time.Minute {
+		t.Error("Wrong default interval:", hill.Interval)
+	}
+
+	when := time.Unix(600, 0)
+	hill.award(when)
+	state.refresh()
+	if (query["cat"] != "pategory") || (query["hill"] != "web") || (query["tick"] != "10") {
+		t.Error("Wrong query:", query)
+	}
+
+	pl := state.PointsLog()
+	if len(pl) != 1 {
+		t.Fatal("Wrong points log:", pl)
+	}
+	if name, tick, ok := pl[0].Recurring(); !ok || (name != "web") || (tick != 10) {
+		t.Error("Wrong recurring award:", pl[0])
+	}
+	if pl[0].TeamID != TestTeamID {
+		t.Error("Points awarded to the wrong team:", pl[0])
+	}
+
+	// The same tick again is worth nothing
+	hill.award(when.Add(59 * time.Second))
+	state.refresh()
+	if pl := state.PointsLog(); len(pl) != 1 {
+		t.Error("Tick awarded twice:", pl)
+	}
+
+	hill.award(when.Add(time.Minute))
+	state.refresh()
+	if pl := state.PointsLog(); (len(pl) != 2) || (pl[1].Slug != award.RecurringSlug("web", 11)) {
+		t.Error("Next tick not awarded:", pl)
+	}
+
+	// Holding a hill doesn't unlock puzzles
+	if es := handler.ExportState(); len(es.Puzzles["pategory"]) != 1 {
+		t.Error("Hill points unlocked puzzles:", es.Puzzles)
+	}
+}
+
+func TestHillCommand(t *testing.T) {
+	hill := NewHill(
+		HillConfig{
+			Name:     "ssh",
+			Category: "hills",
+			Points:   1,
+			Command:  "sh",
+			Args:     []string{"-c", `echo "$CAT $HILL $TICK"`},
+		},
+		NewTestState(),
+	)
+	holders, err := hill.Holders(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(holders) != 3) || (holders[0] != "hills") || (holders[1] != "ssh") || (holders[2] != "42") {
+		t.Error("Wrong environment:", holders)
+	}
+
+	hill.Args = []string{"-c", "exit 1"}
+	if _, err := hill.Holders(42); err == nil {
+		t.Error("Failing checker didn't return an error")
+	}
+}
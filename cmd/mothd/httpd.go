@@ -2,9 +2,9 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
@@ -30,10 +30,13 @@ func NewHTTPServer(base string, server *MothServer) *HTTPServer {
 	h.HandleMothFunc("/state", h.StateHandler)
 	h.HandleMothFunc("/register", h.RegisterHandler)
 	h.HandleMothFunc("/answer", h.AnswerHandler)
+	h.HandleMothFunc("/token", h.TokenHandler)
 	h.HandleMothFunc("/content/", h.ContentHandler)
+	h.HandleMothFunc("/download", h.DownloadHandler)
 
 	if server.Config.Devel {
 		h.HandleMothFunc("/mothballer/", h.MothballerHandler)
+		h.HandleMothFunc("/changes", h.ChangesHandler)
 	}
 	return h
 }
@@ -79,6 +82,12 @@ func (w StatusResponseWriter) WriteHeader(statusCode int) {
 	w.ResponseWriter.WriteHeader(statusCode)
 }
 
+// Unwrap returns the underlying ResponseWriter,
+// so http.ResponseController can find its Flush method.
+func (w StatusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // Run binds to the provided bindStr, and serves incoming requests until failure
 func (h *HTTPServer) Run(bindStr string) {
 	log.Printf("Listening on %s", bindStr)
@@ -127,18 +136,32 @@ func (h *HTTPServer) RegisterHandler(mh MothRequestHandler, w http.ResponseWrite
 // AnswerHandler checks answer correctness and awards points
 func (h *HTTPServer) AnswerHandler(mh MothRequestHandler, w http.ResponseWriter, req *http.Request) {
 	cat := req.FormValue("cat")
-	pointstr := req.FormValue("points")
 	answer := req.FormValue("answer")
 
-	points, _ := strconv.Atoi(pointstr)
+	// Slug puzzles are given by slug, and everything else by points
+	id := req.FormValue("slug")
+	if id == "" {
+		id = req.FormValue("points")
+	}
 
-	if err := mh.CheckAnswer(cat, points, answer); err != nil {
+	if points, err := mh.CheckAnswer(cat, id, answer); err != nil {
 		jsend.Sendf(w, jsend.Fail, "not accepted", err.Error())
 	} else {
 		jsend.Sendf(w, jsend.Success, "accepted", "%d points awarded in %s", points, cat)
 	}
 }
 
+// TokenHandler redeems a token for points
+func (h *HTTPServer) TokenHandler(mh MothRequestHandler, w http.ResponseWriter, req *http.Request) {
+	token := req.FormValue("token")
+
+	if t, err := mh.RedeemToken(token); err != nil {
+		jsend.Sendf(w, jsend.Fail, "not accepted", err.Error())
+	} else {
+		jsend.Sendf(w, jsend.Success, "accepted", "%d points awarded in %s", t.Points, t.Category)
+	}
+}
+
 // ContentHandler returns static content from a given puzzle
 func (h *HTTPServer) ContentHandler(mh MothRequestHandler, w http.ResponseWriter, req *http.Request) {
 	parts := strings.SplitN(req.URL.Path[len(h.base)+1:], "/", 4)
@@ -149,16 +172,14 @@ func (h *HTTPServer) ContentHandler(mh MothRequestHandler, w http.ResponseWriter
 
 	// parts[0] == "content"
 	cat := parts[1]
-	pointsStr := parts[2]
+	id := parts[2]
 	filename := parts[3]
 
 	if filename == "" {
 		filename = "puzzle.json"
 	}
 
-	points, _ := strconv.Atoi(pointsStr)
-
-	mf, mtime, err := mh.PuzzlesOpen(cat, points, filename)
+	mf, mtime, err := mh.PuzzlesOpen(cat, id, filename)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -168,6 +189,20 @@ func (h *HTTPServer) ContentHandler(mh MothRequestHandler, w http.ResponseWriter
 	http.ServeContent(w, req, filename, mtime, mf)
 }
 
+// DownloadHandler sends a zip file of every puzzle the team has unlocked,
+// so teams can keep working through a network outage.
+func (h *HTTPServer) DownloadHandler(mh MothRequestHandler, w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="moth-puzzles.zip"`)
+	if err := mh.Download(w); err == ErrNotRegistered {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, err.Error(), http.StatusForbidden)
+	} else if err != nil {
+		// Too late to tell the client: the zip has already started
+		log.Printf("%s: %v", req.URL, err)
+	}
+}
+
 // MothballerHandler returns a mothball
 func (h *HTTPServer) MothballerHandler(mh MothRequestHandler, w http.ResponseWriter, req *http.Request) {
 	parts := strings.SplitN(req.URL.Path[len(h.base)+1:], "/", 2)
@@ -188,3 +223,37 @@ func (h *HTTPServer) MothballerHandler(mh MothRequestHandler, w http.ResponseWri
 	mbReader := bytes.NewReader(mb.Bytes())
 	http.ServeContent(w, req, filename, time.Now(), mbReader)
 }
+
+// ChangesHandler sends a server-sent event with a category name
+// whenever that category's puzzles change.
+//
+// Puzzle pages in development mode listen to this, to reload when an author saves a file.
+func (h *HTTPServer) ChangesHandler(mh MothRequestHandler, w http.ResponseWriter, req *http.Request) {
+	changes, cancel := mh.Subscribe()
+	defer cancel()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.Printf("%s: %v", req.URL, err)
+		return
+	}
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case cat := <-changes:
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", cat)
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-req.Context().Done():
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/dirtbags/moth/v4/pkg/transpile"
 	"github.com/spf13/afero"
 )
 
@@ -168,3 +175,195 @@ func TestDevelFsHttps(t *testing.T) {
 		t.Error("Didn't get a Mothball")
 	}
 }
+
+func TestDevelChanges(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "cat/1/puzzle.md", []byte("---\nanswers: [a]\n---\nBody\n"), 0644)
+	transpilerProvider := NewTranspilerProvider(fs)
+	transpilerProvider.refresh()
+	srv := NewMothServer(Configuration{Devel: true}, NewTestTheme(), NewTestState(), transpilerProvider)
+	ts := httptest.NewServer(NewHTTPServer("/", srv))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Error("Wrong content type:", ct)
+	}
+
+	afero.WriteFile(fs, "cat/1/puzzle.md", []byte("---\nanswers: [b]\n---\nNew body\n"), 0644)
+	transpilerProvider.refresh()
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("Event stream closed")
+			}
+			if line == "data: cat" {
+				return
+			}
+		case <-timeout:
+			t.Fatal("No change event received")
+		}
+	}
+}
+
+func TestProductionChanges(t *testing.T) {
+	srv := NewTestServer()
+	hs := NewHTTPServer("/", srv.MothServer)
+	if r := hs.TestRequest("/changes", nil); r.Result().StatusCode != 404 {
+		t.Error("Change events served in production mode")
+	}
+}
+
+func TestDownload(t *testing.T) {
+	server := NewTestServer()
+	hs := NewHTTPServer("/", server.MothServer)
+
+	m := server.PuzzleProviders[0].(*Mothballs)
+	f, _ := m.Create("attagory.mb")
+	zw := zip.NewWriter(f)
+	for _, file := range []testFileContents{
+		{"puzzles.txt", "1\n"},
+		{"answers.txt", "1 a\n"},
+		{"1/puzzle.json", `{"Attachments": ["moo.txt", "../escape.txt"]}`},
+		{"1/moo.txt", "moo"},
+	} {
+		of, _ := zw.Create(file.Name)
+		of.Write([]byte(file.Body))
+	}
+	zw.Close()
+	f.Close()
+	server.refresh()
+
+	if r := hs.TestRequest("/download", nil); r.Result().StatusCode != http.StatusForbidden {
+		t.Error("Unregistered team allowed to download:", r.Result())
+	}
+
+	hs.TestRequest("/register", map[string]string{"name": "GoTeam"})
+	server.refresh()
+
+	download := func() map[string]string {
+		r := hs.TestRequest("/download", nil)
+		if r.Result().StatusCode != 200 {
+			t.Fatal(r.Result())
+		}
+		if ct := r.Result().Header.Get("Content-Type"); ct != "application/zip" {
+			t.Error("Wrong content type:", ct)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(r.Body.Bytes()), int64(r.Body.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := make(map[string]string)
+		for _, zf := range zr.File {
+			rc, _ := zf.Open()
+			buf, _ := io.ReadAll(rc)
+			rc.Close()
+			files[zf.Name] = string(buf)
+		}
+		return files
+	}
+
+	files := download()
+	if len(files) != 3 {
+		t.Error("Wrong files:", files)
+	}
+	if files["attagory/1/moo.txt"] != "moo" {
+		t.Error("Missing attachment:", files)
+	}
+	if _, ok := files["pategory/1/puzzle.json"]; !ok {
+		t.Error("Missing unlocked puzzle:", files)
+	}
+	if _, ok := files["pategory/2/puzzle.json"]; ok {
+		t.Error("Locked puzzle downloaded")
+	}
+
+	hs.TestRequest("/answer", map[string]string{"cat": "pategory", "points": "1", "answer": "answer123"})
+	server.refresh()
+	if files := download(); files["pategory/2/puzzle.json"] != "{}" {
+		t.Error("Newly unlocked puzzle not downloaded:", files)
+	}
+}
+
+func TestTokenHttpd(t *testing.T) {
+	server := NewTestServer()
+	mothballs := server.PuzzleProviders[0].(*Mothballs)
+	mb := new(bytes.Buffer)
+	tokens := []transpile.Token{
+		{Category: "scavenger", Points: 5, Nonce: "xylep-radar-nanox"},
+		{Category: "scavenger", Points: 5, Nonce: "xoter-darox"},
+	}
+	if err := transpile.TokenMothball(tokens, mb, transpile.MothballOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	afero.WriteFile(mothballs.Fs, "scavenger.mb", mb.Bytes(), 0644)
+	server.refresh()
+	hs := NewHTTPServer("/", server.MothServer)
+
+	if r := hs.TestRequest("/register", map[string]string{"name": "GoTeam"}); r.Result().StatusCode != 200 {
+		t.Error(r.Result())
+	}
+	server.refresh()
+
+	for _, tc := range []struct {
+		token, body string
+	}{
+		{"scavenger:5:xylep-radar-nanox", `{"status":"success","data":{"short":"accepted","description":"5 points awarded in scavenger"}}`},
+		{"scavenger:5:xoter-darox", `{"status":"success","data":{"short":"accepted","description":"5 points awarded in scavenger"}}`},
+		{"scavenger:5:xylep-radar-nanox", `{"status":"fail","data":{"short":"not accepted","description":"points already awarded to this team in this category"}}`},
+		{"scavenger:1:xylep-radar-nanox", `{"status":"fail","data":{"short":"not accepted","description":"unknown token"}}`},
+		{"pategory:1:xylep-radar-nanox", `{"status":"fail","data":{"short":"not accepted","description":"incorrect answer"}}`},
+		{"pategory:1:answer123", `{"status":"success","data":{"short":"accepted","description":"1 points awarded in pategory"}}`},
+		{"scavenger:xylep", `{"status":"fail","data":{"short":"not accepted","description":"malformed token: \"scavenger:xylep\""}}`},
+	} {
+		if r := hs.TestRequest("/token", map[string]string{"token": tc.token}); r.Result().StatusCode != 200 {
+			t.Error(r.Result())
+		} else if r.Body.String() != tc.body {
+			t.Errorf("Redeeming %s: %s", tc.token, r.Body.String())
+		}
+		server.refresh()
+	}
+
+	// Redeemed tokens are logged by nonce, so each team can only redeem them once
+	nonces := make(map[string]bool)
+	for _, awd := range server.State.PointsLog() {
+		if awd.Category == "scavenger" {
+			nonces[awd.Slug] = true
+		}
+	}
+	if !nonces["xylep-radar-nanox"] || !nonces["xoter-darox"] {
+		t.Error("Wrong points log:", server.State.PointsLog())
+	}
+
+	// But other teams can't see them
+	handler := server.NewHandler(TestTeamID)
+	es := handler.ExportState()
+	if len(es.PointsLog) != 3 {
+		t.Error("Wrong exported points log:", es.PointsLog)
+	}
+	for _, awd := range es.PointsLog {
+		if (awd.Category == "scavenger") && (awd.Slug != "") {
+			t.Error("Token nonce exported:", awd)
+		}
+	}
+	if r := hs.TestRequest("/state", nil); strings.Contains(r.Body.String(), "xylep-radar-nanox") || strings.Contains(r.Body.String(), "xoter-darox") {
+		t.Error("Token nonce in /state:", r.Body.String())
+	}
+	if _, ok := es.Puzzles["scavenger"]; ok {
+		t.Error("Token category has puzzles:", es.Puzzles)
+	}
+}
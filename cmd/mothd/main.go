@@ -3,61 +3,30 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"mime"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 
+	"github.com/dirtbags/moth/v4/pkg/transpile"
 	"github.com/spf13/afero"
 )
 
 func main() {
-	themePath := flag.String(
-		"theme",
-		"theme",
-		"Path to theme files",
-	)
-	statePath := flag.String(
-		"state",
-		"state",
-		"Path to state files",
-	)
-	mothballPath := flag.String(
-		"mothballs",
-		"mothballs",
-		"Path to mothball files",
-	)
-	puzzlePath := flag.String(
-		"puzzles",
-		"",
-		"Path to puzzles tree (enables development mode)",
-	)
-	refreshInterval := flag.Duration(
-		"refresh",
-		2*time.Second,
-		"Duration between maintenance tasks",
-	)
-	bindStr := flag.String(
-		"bind",
-		":8080",
-		"Bind [host]:port for HTTP service",
-	)
-	base := flag.String(
-		"base",
-		"/",
-		"Base URL of this instance",
-	)
-	seed := flag.String(
-		"seed",
-		"",
-		"Random seed to use, overrides $SEED",
-	)
-	flag.Parse()
+	osfs := afero.NewOsFs()
+	serverConfig, err := ParseServerConfig(osfs, os.Args[0], os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		log.Fatal(err)
+	}
 
 	var theme *Theme
-	osfs := afero.NewOsFs()
-	if p, err := filepath.Abs(*themePath); err != nil {
+	if p, err := filepath.Abs(serverConfig.Theme); err != nil {
 		log.Fatal(err)
 	} else {
 		theme = NewTheme(afero.NewBasePathFs(osfs, p))
@@ -65,24 +34,33 @@ func main() {
 
 	config := Configuration{}
 
-	var provider PuzzleProvider
-	if p, err := filepath.Abs(*mothballPath); err != nil {
+	generatorConfig, err := serverConfig.commandConfig()
+	if err != nil {
 		log.Fatal(err)
-	} else {
-		provider = NewMothballs(afero.NewBasePathFs(osfs, p))
 	}
-	if *puzzlePath != "" {
-		if p, err := filepath.Abs(*puzzlePath); err != nil {
-			log.Fatal(err)
-		} else {
-			provider = NewTranspilerProvider(afero.NewBasePathFs(osfs, p))
-		}
+	// Provider commands aren't sandboxed, and get their own default timeout
+	providerConfig := generatorConfig
+	providerConfig.Timeout = 0
+	providerConfig.Sandbox = transpile.SandboxConfig{}
+
+	// Set random seed
+	seed := serverConfig.Seed
+	if seed == "" {
+		seed = os.Getenv("SEED")
+	}
+	if seed == "" {
+		seed = fmt.Sprintf("%d%d", os.Getpid(), time.Now().Unix())
+	}
+	os.Setenv("SEED", seed)
+	log.Print("SEED=", seed)
+
+	if serverConfig.Devel() {
 		config.Devel = true
 		log.Println("-=- You are in development mode, champ! -=-")
 	}
 
 	var state StateProvider
-	if p, err := filepath.Abs(*statePath); err != nil {
+	if p, err := filepath.Abs(serverConfig.State); err != nil {
 		log.Fatal(err)
 	} else {
 		state = NewState(afero.NewBasePathFs(osfs, p))
@@ -91,27 +69,81 @@ func main() {
 		state = NewDevelState(state)
 	}
 
-	// Set random seed
-	if *seed == "" {
-		*seed = os.Getenv("SEED")
+	// Providers are consulted in the order they're listed here:
+	// puzzle trees, generated puzzle trees, mothballs, commands, and remote services.
+	// If two providers offer the same category, the first one wins.
+	var providers []PuzzleProvider
+	for _, puzzlePath := range serverConfig.Puzzles {
+		if p, err := filepath.Abs(puzzlePath); err != nil {
+			log.Fatal(err)
+		} else {
+			provider := NewTranspilerProvider(afero.NewBasePathFs(osfs, p))
+			provider.CommandConfig = generatorConfig
+			providers = append(providers, provider)
+		}
+	}
+	for _, generatedPath := range serverConfig.Generated {
+		if p, err := filepath.Abs(generatedPath); err != nil {
+			log.Fatal(err)
+		} else {
+			provider := NewTeamTranspilerProvider(afero.NewBasePathFs(osfs, p), seed)
+			provider.CommandConfig = generatorConfig
+			providers = append(providers, provider)
+		}
 	}
-	if *seed == "" {
-		*seed = fmt.Sprintf("%d%d", os.Getpid(), time.Now().Unix())
+	for _, mothballPath := range serverConfig.Mothballs {
+		if p, err := filepath.Abs(mothballPath); err != nil {
+			log.Fatal(err)
+		} else {
+			mothballs := NewMothballs(afero.NewBasePathFs(osfs, p))
+			mothballs.State = state
+			mothballs.SwapPolicy = serverConfig.SwapPolicy
+			providers = append(providers, mothballs)
+		}
+	}
+	for _, command := range serverConfig.Commands {
+		command.CommandConfig = command.CommandConfig.Merge(providerConfig)
+		providers = append(providers, command)
+	}
+	for _, remote := range serverConfig.Remotes {
+		if remote.MaxFileSize == 0 {
+			remote.MaxFileSize = serverConfig.MaxFileSize
+		}
+		providers = append(providers, NewProviderHTTP(remote))
 	}
-	os.Setenv("SEED", *seed)
-	log.Print("SEED=", *seed)
 
 	// Add some MIME extensions
 	// Doing this avoids decompressing a mothball entry twice per request
 	mime.AddExtensionType(".json", "application/json")
 	mime.AddExtensionType(".zip", "application/zip")
 
-	go theme.Maintain(*refreshInterval)
-	go state.Maintain(*refreshInterval)
-	go provider.Maintain(*refreshInterval)
+	go theme.Maintain(serverConfig.Refresh)
+	go state.Maintain(serverConfig.Refresh)
+	for _, provider := range providers {
+		go provider.Maintain(serverConfig.Refresh)
+	}
+	for _, hillConfig := range serverConfig.Hills {
+		go NewHill(hillConfig, state).Maintain(serverConfig.Refresh)
+	}
+
+	// Clean up after providers (like cached attachments) on the way out
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		sig := <-sigs
+		log.Printf("Caught %v, shutting down", sig)
+		for _, provider := range providers {
+			if c, ok := provider.(io.Closer); ok {
+				if err := c.Close(); err != nil {
+					log.Print(err)
+				}
+			}
+		}
+		os.Exit(0)
+	}()
 
-	server := NewMothServer(config, theme, state, provider)
-	httpd := NewHTTPServer(*base, server)
+	server := NewMothServer(config, theme, state, providers...)
+	httpd := NewHTTPServer(serverConfig.Base, server)
 
-	httpd.Run(*bindStr)
+	httpd.Run(serverConfig.Bind)
 }
@@ -1,32 +1,65 @@
 package main
 
 import (
-	"archive/zip"
-	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
-	"sort"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/dirtbags/moth/v4/pkg/transpile"
 	"github.com/spf13/afero"
-	"github.com/spf13/afero/zipfs"
+)
+
+// Swap policies, for replacement mothballs which change puzzles teams have already solved
+const (
+	// SwapRefuse keeps serving the old mothball until the replacement is confirmed
+	SwapRefuse = "refuse"
+
+	// SwapWarn logs a warning, and replaces the mothball anyway
+	SwapWarn = "warn"
 )
 
 type zipCategory struct {
-	afero.Fs
+	*transpile.MothballReader
 	io.Closer
 	mtime time.Time
+
+	// version is the content version from the manifest,
+	// or a hash of the whole file for mothballs without one
+	version string
+
+	// category is read once, when the mothball is opened
+	category Category
 }
 
 // Mothballs provides a collection of active mothball files (puzzle categories)
 type Mothballs struct {
 	afero.Fs
+
+	// State, if set, is used to check replacement mothballs against the points log,
+	// and to log replacements.
+	State StateProvider
+
+	// SwapPolicy says what to do when a replacement mothball
+	// removes, or changes the answers to, a puzzle some team has solved.
+	// The zero value is SwapRefuse.
+	SwapPolicy string
+
 	categories   map[string]zipCategory
 	categoryLock *sync.RWMutex
+
+	// refreshLock keeps refreshes from running at the same time.
+	// Only refresh uses pending.
+	refreshLock *sync.Mutex
+
+	// pending holds replacement mothballs waiting to be confirmed
+	pending map[string]zipCategory
 }
 
 // NewMothballs returns a new Mothballs structure backed by the provided directory
@@ -35,6 +68,8 @@ func NewMothballs(fs afero.Fs) *Mothballs {
 		Fs:           fs,
 		categories:   make(map[string]zipCategory),
 		categoryLock: new(sync.RWMutex),
+		refreshLock:  new(sync.Mutex),
+		pending:      make(map[string]zipCategory),
 	}
 }
 
@@ -46,19 +81,60 @@ func (m *Mothballs) getCat(cat string) (zipCategory, bool) {
 }
 
 // Open returns a ReadSeekCloser corresponding to the filename in a puzzle's category and points
-func (m *Mothballs) Open(cat string, points int, filename string) (ReadSeekCloser, time.Time, error) {
+func (m *Mothballs) Open(teamID string, cat string, points int, filename string) (ReadSeekCloser, time.Time, error) {
+	return m.open(teamID, cat, strconv.Itoa(points), filename)
+}
+
+// OpenSlug returns a ReadSeekCloser corresponding to the filename in the puzzle named slug.
+func (m *Mothballs) OpenSlug(teamID string, cat string, slug string, filename string) (ReadSeekCloser, time.Time, error) {
+	return m.open(teamID, cat, slug, filename)
+}
+
+func (m *Mothballs) open(teamID string, cat string, id string, filename string) (ReadSeekCloser, time.Time, error) {
 	zc, ok := m.getCat(cat)
 	if !ok {
 		return nil, time.Time{}, fmt.Errorf("no such category: %s", cat)
 	}
 
-	f, err := zc.Open(fmt.Sprintf("%d/%s", points, filename))
+	f, err := zc.OpenPuzzleFile(teamID, id, filename)
 	if err != nil {
 		return nil, time.Time{}, err
 	}
 
-	fInfo, err := f.Stat()
-	return f, fInfo.ModTime(), err
+	// Files in a mothball all have the same fixed timestamp,
+	// so use the mothball's, which changes when it's replaced.
+	return f, zc.mtime, nil
+}
+
+// Describe returns the description of cat stored in its mothball.
+// Mothballs built before descriptions were stored have none.
+func (m *Mothballs) Describe(cat string) (transpile.CategoryInfo, bool) {
+	zc, ok := m.getCat(cat)
+	if !ok {
+		return transpile.CategoryInfo{}, false
+	}
+	info := zc.Metadata().Category
+	return info, !info.IsZero()
+}
+
+// Titles returns the titles of puzzles in cat, from its mothball's puzzle list.
+func (m *Mothballs) Titles(cat string) map[string]string {
+	zc, ok := m.getCat(cat)
+	if !ok {
+		return nil
+	}
+	return zc.MothballReader.Titles()
+}
+
+// Version returns the content version of the mothball for cat,
+// from its manifest.
+// Mothballs built without a manifest are versioned by a hash of the whole file.
+func (m *Mothballs) Version(cat string) (string, bool) {
+	zc, ok := m.getCat(cat)
+	if !ok {
+		return "", false
+	}
+	return zc.version, true
 }
 
 // Inventory returns the list of current categories
@@ -66,57 +142,67 @@ func (m *Mothballs) Inventory() []Category {
 	m.categoryLock.RLock()
 	defer m.categoryLock.RUnlock()
 	categories := make([]Category, 0, 20)
-	for cat, zfs := range m.categories {
-		pointsList := make([]int, 0, 20)
-		pf, err := zfs.Open("puzzles.txt")
-		if err != nil {
+	for _, zc := range m.categories {
+		if zc.category.Puzzles == nil {
 			// No puzzles = no category
 			continue
 		}
-		scanner := bufio.NewScanner(pf)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if pointval, err := strconv.Atoi(line); err != nil {
-				log.Printf("Reading points for %s: %s", cat, err.Error())
-			} else {
-				pointsList = append(pointsList, pointval)
-			}
-		}
-		sort.Ints(pointsList)
-		categories = append(categories, Category{cat, pointsList})
+		categories = append(categories, zc.category)
 	}
 	return categories
 }
 
 // CheckAnswer returns an error if the provided answer is in any way incorrect for the given category and points
-func (m *Mothballs) CheckAnswer(cat string, points int, answer string) (bool, error) {
-	zfs, ok := m.getCat(cat)
+//
+// If teamID has its own variant of this puzzle,
+// only answers for that variant are accepted.
+func (m *Mothballs) CheckAnswer(teamID string, cat string, points int, answer string) (bool, error) {
+	return m.checkAnswer(teamID, cat, strconv.Itoa(points), answer)
+}
+
+// CheckSlugAnswer returns whether answer is correct for the puzzle named slug.
+func (m *Mothballs) CheckSlugAnswer(teamID string, cat string, slug string, answer string) (bool, error) {
+	return m.checkAnswer(teamID, cat, slug, answer)
+}
+
+func (m *Mothballs) checkAnswer(teamID string, cat string, id string, answer string) (bool, error) {
+	zc, ok := m.getCat(cat)
 	if !ok {
 		return false, fmt.Errorf("no such category: %s", cat)
 	}
+	return zc.MothballReader.CheckAnswer(teamID, id, answer)
+}
 
-	af, err := zfs.Open("answers.txt")
-	if err != nil {
-		return false, fmt.Errorf("no answers.txt file")
+// CheckToken returns whether the token mothball for cat has a token worth points with the given nonce.
+func (m *Mothballs) CheckToken(cat string, points int, nonce string) (bool, error) {
+	zc, ok := m.getCat(cat)
+	if !ok {
+		return false, fmt.Errorf("no such category: %s", cat)
 	}
-	defer af.Close()
-
-	needle := fmt.Sprintf("%d %s", points, answer)
-	scanner := bufio.NewScanner(af)
-	for scanner.Scan() {
-		if scanner.Text() == needle {
-			return true, nil
-		}
+	if !zc.Metadata().Tokens {
+		return false, fmt.Errorf("not a token category: %s", cat)
 	}
-
-	return false, nil
+	return zc.CheckToken(points, nonce), nil
 }
 
 // refresh refreshes internal state.
 // It looks for changes to the directory listing, and caches any new mothballs.
+//
+// A replacement mothball is only swapped in once it has been read successfully,
+// and checked against the points log.
+// Opening and checking mothballs can take a while,
+// so the category lock is only held to change the category list.
 func (m *Mothballs) refresh() {
-	m.categoryLock.Lock()
-	defer m.categoryLock.Unlock()
+	m.refreshLock.Lock()
+	defer m.refreshLock.Unlock()
+
+	// Only refresh changes the category list, so this stays current until we change it
+	m.categoryLock.RLock()
+	current := make(map[string]zipCategory, len(m.categories))
+	for cat, zc := range m.categories {
+		current[cat] = zc
+	}
+	m.categoryLock.RUnlock()
 
 	// Any new categories?
 	files, err := afero.ReadDir(m.Fs, "/")
@@ -133,56 +219,189 @@ func (m *Mothballs) refresh() {
 		categoryName := strings.TrimSuffix(filename, ".mb")
 		found[categoryName] = true
 
-		reopen := false
-		if existingMothball, ok := m.categories[categoryName]; !ok {
-			reopen = true
-		} else if si, err := m.Fs.Stat(filename); err != nil {
-			log.Println(err)
-		} else if si.ModTime().After(existingMothball.mtime) {
-			existingMothball.Close()
-			delete(m.categories, categoryName)
-			reopen = true
-		}
-
-		if reopen {
-			f, err := m.Fs.Open(filename)
+		existingMothball, ok := current[categoryName]
+		if !ok {
+			zc, err := m.openMothball(filename)
 			if err != nil {
-				log.Println(err)
+				log.Println(categoryName, err)
 				continue
 			}
+			m.categoryLock.Lock()
+			m.categories[categoryName] = zc
+			m.categoryLock.Unlock()
+			log.Printf("Adding category: %s (version %.12s)", categoryName, zc.version)
+			continue
+		}
 
-			fi, err := f.Stat()
-			if err != nil {
-				f.Close()
-				log.Println(err)
-				continue
-			}
+		si, err := m.Fs.Stat(filename)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if !si.ModTime().After(existingMothball.mtime) {
+			continue
+		}
 
-			zrc, err := zip.NewReader(f, fi.Size())
-			if err != nil {
-				f.Close()
-				log.Println(err)
+		if pending, ok := m.pending[categoryName]; ok {
+			if pending.mtime.Equal(si.ModTime()) {
+				if m.confirmed(filename, pending.version) {
+					m.swap(categoryName, pending)
+				}
 				continue
 			}
+			// Replaced again before anybody confirmed it
+			pending.Close()
+			delete(m.pending, categoryName)
+		}
 
-			m.categories[categoryName] = zipCategory{
-				Fs:     zipfs.New(zrc),
-				Closer: f,
-				mtime:  fi.ModTime(),
+		zc, err := m.openMothball(filename)
+		if err != nil {
+			log.Printf("%s: keeping the old mothball: %v", categoryName, err)
+			continue
+		}
+		if problems := m.swapProblems(categoryName, existingMothball, zc); len(problems) > 0 {
+			for _, problem := range problems {
+				log.Printf("WARNING: replacing %s: %s", categoryName, problem)
+			}
+			if (m.SwapPolicy != SwapWarn) && !m.confirmed(filename, zc.version) {
+				log.Printf(
+					"Still serving the old %s. To replace it anyway, write %s to %s.confirm",
+					categoryName, zc.version, filename,
+				)
+				m.pending[categoryName] = zc
+				m.logEvent("mothball-refused", categoryName, existingMothball.version, zc.version)
+				continue
 			}
-
-			log.Println("Adding category:", categoryName)
 		}
+		m.swap(categoryName, zc)
 	}
 
 	// Delete anything in the list that wasn't found
-	for categoryName, zc := range m.categories {
+	for categoryName, zc := range current {
 		if !found[categoryName] {
-			zc.Close()
+			m.categoryLock.Lock()
 			delete(m.categories, categoryName)
+			m.categoryLock.Unlock()
+			zc.Close()
 			log.Println("Removing category:", categoryName)
 		}
 	}
+	for categoryName, zc := range m.pending {
+		if !found[categoryName] {
+			zc.Close()
+			delete(m.pending, categoryName)
+		}
+	}
+}
+
+// openMothball opens the mothball file filename.
+func (m *Mothballs) openMothball(filename string) (zipCategory, error) {
+	f, err := m.Fs.Open(filename)
+	if err != nil {
+		return zipCategory{}, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return zipCategory{}, err
+	}
+
+	mr, err := transpile.NewMothballReader(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return zipCategory{}, err
+	}
+
+	version := mr.Version()
+	if version == "" {
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(f, 0, fi.Size())); err != nil {
+			f.Close()
+			return zipCategory{}, err
+		}
+		version = hex.EncodeToString(h.Sum(nil))
+	}
+
+	pointsList, err := mr.Inventory()
+	if err != nil {
+		log.Printf("Reading points for %s: %s", filename, err.Error())
+	}
+
+	return zipCategory{
+		MothballReader: mr,
+		Closer:         f,
+		mtime:          fi.ModTime(),
+		version:        version,
+		category: Category{
+			Name:    strings.TrimSuffix(filename, ".mb"),
+			Puzzles: pointsList,
+			Slugs:   mr.Slugs(),
+			Tokens:  mr.Metadata().Tokens,
+		},
+	}, nil
+}
+
+// swapProblems checks a replacement for the mothball of cat against the points log.
+// It returns a description of every solved puzzle which would be removed,
+// or would have its answers changed.
+func (m *Mothballs) swapProblems(cat string, old zipCategory, replacement zipCategory) []string {
+	if m.State == nil {
+		return nil
+	}
+	solved := make(map[string]bool)
+	for _, awd := range m.State.PointsLog() {
+		if awd.Category == cat {
+			solved[transpile.PuzzleID(awd.Points, awd.Slug)] = true
+		}
+	}
+	if len(solved) == 0 {
+		return nil
+	}
+
+	changes, err := transpile.DiffMothballs(old.MothballReader, replacement.MothballReader)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var problems []string
+	for _, change := range changes {
+		if (change.What != transpile.PuzzleRemoved) && (change.What != transpile.AnswersChanged) {
+			continue
+		}
+		if solved[path.Base(change.Puzzle)] {
+			problems = append(problems, fmt.Sprintf("solved puzzle %s", change))
+		}
+	}
+	return problems
+}
+
+// confirmed returns true if somebody has confirmed replacing the mothball filename with version,
+// by writing version to filename.confirm.
+func (m *Mothballs) confirmed(filename string, version string) bool {
+	buf, err := afero.ReadFile(m.Fs, filename+".confirm")
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(buf)) == version
+}
+
+// swap replaces the mothball for cat.
+func (m *Mothballs) swap(cat string, zc zipCategory) {
+	m.categoryLock.Lock()
+	old := m.categories[cat]
+	m.categories[cat] = zc
+	m.categoryLock.Unlock()
+	old.Close()
+	delete(m.pending, cat)
+	log.Printf("Replacing category: %s (version %.12s, was %.12s)", cat, zc.version, old.version)
+	m.logEvent("mothball-replaced", cat, old.version, zc.version)
+}
+
+// logEvent logs an event about cat, if there's a State to log it to.
+func (m *Mothballs) logEvent(event string, cat string, extra ...string) {
+	if m.State != nil {
+		m.State.LogEvent(event, "", cat, 0, extra...)
+	}
 }
 
 // Mothball just returns an error
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"testing"
+	"time"
 
+	"github.com/dirtbags/moth/v4/pkg/award"
+	"github.com/dirtbags/moth/v4/pkg/transpile"
 	"github.com/spf13/afero"
 )
 
@@ -77,7 +80,7 @@ func TestMothballs(t *testing.T) {
 			}
 		}
 		for _, points := range cat.Puzzles {
-			f, _, err := m.Open(cat.Name, points, "puzzle.json")
+			f, _, err := m.Open("", cat.Name, points, "puzzle.json")
 			if err != nil {
 				t.Error(cat.Name, err)
 				continue
@@ -86,26 +89,26 @@ func TestMothballs(t *testing.T) {
 		}
 	}
 
-	if f, _, err := m.Open("nealegory", 1, "puzzle.json"); err == nil {
+	if f, _, err := m.Open("", "nealegory", 1, "puzzle.json"); err == nil {
 		f.Close()
 		t.Error("You can't open a puzzle in a nealegory, that doesn't even rhyme!")
 	}
 
-	if f, _, err := m.Open("pategory", 1, "bozo"); err == nil {
+	if f, _, err := m.Open("", "pategory", 1, "bozo"); err == nil {
 		f.Close()
 		t.Error("This file shouldn't exist")
 	}
 
-	if ok, _ := m.CheckAnswer("pategory", 1, "answer"); ok {
+	if ok, _ := m.CheckAnswer("", "pategory", 1, "answer"); ok {
 		t.Error("Wrong answer marked right")
 	}
-	if _, err := m.CheckAnswer("pategory", 1, "answer123"); err != nil {
+	if _, err := m.CheckAnswer("", "pategory", 1, "answer123"); err != nil {
 		t.Error("Right answer marked wrong", err)
 	}
-	if _, err := m.CheckAnswer("pategory", 1, "answer456"); err != nil {
+	if _, err := m.CheckAnswer("", "pategory", 1, "answer456"); err != nil {
 		t.Error("Right answer marked wrong", err)
 	}
-	if ok, err := m.CheckAnswer("nealegory", 1, "moo"); ok {
+	if ok, err := m.CheckAnswer("", "nealegory", 1, "moo"); ok {
 		t.Error("Checking answer in non-existent category should fail")
 	} else if err.Error() != "no such category: nealegory" {
 		t.Error("Wrong error message")
@@ -120,7 +123,7 @@ func TestMothballs(t *testing.T) {
 		},
 	)
 	m.refresh()
-	if f, _, err := m.Open("pategory", 1, "moo.txt"); err != nil {
+	if f, _, err := m.Open("", "pategory", 1, "moo.txt"); err != nil {
 		t.Error("pategory/1/moo.txt", err)
 	} else if contents, err := ioutil.ReadAll(f); err != nil {
 		t.Error("read all pategory/1/moo.txt", err)
@@ -137,3 +140,164 @@ func TestMothballs(t *testing.T) {
 	}
 
 }
+
+func TestMothballVariants(t *testing.T) {
+	m := NewMothballs(new(afero.MemMapFs))
+	m.createMothballWithFiles(
+		"varigory",
+		[]testFileContents{
+			{"variants.txt", "2\nalpha 0\nbeta 1\n"},
+			{"variants/0/1/puzzle.json", `{"Body": "alpha"}`},
+			{"variants/0/answers.txt", "1 alpha-answer\n"},
+			{"variants/1/1/puzzle.json", `{"Body": "beta"}`},
+			{"variants/1/answers.txt", "1 beta-answer\n"},
+		},
+	)
+	m.refresh()
+
+	if f, _, err := m.Open("alpha", "varigory", 1, "puzzle.json"); err != nil {
+		t.Error(err)
+	} else if buf, err := ioutil.ReadAll(f); err != nil {
+		t.Error(err)
+	} else if string(buf) != `{"Body": "alpha"}` {
+		t.Error("Wrong variant for alpha:", string(buf))
+	}
+
+	// Puzzles without variants come from the base category
+	if f, _, err := m.Open("beta", "varigory", 2, "moo.txt"); err != nil {
+		t.Error(err)
+	} else {
+		f.Close()
+	}
+
+	if ok, _ := m.CheckAnswer("alpha", "varigory", 1, "alpha-answer"); !ok {
+		t.Error("Team's own answer rejected")
+	}
+	if ok, _ := m.CheckAnswer("alpha", "varigory", 1, "beta-answer"); ok {
+		t.Error("Another team's answer accepted")
+	}
+	if ok, _ := m.CheckAnswer("alpha", "varigory", 1, "answer123"); ok {
+		t.Error("Base answer accepted for a puzzle with variants")
+	}
+	if ok, _ := m.CheckAnswer("beta", "varigory", 2, "wat"); !ok {
+		t.Error("Base answer rejected for a puzzle without variants")
+	}
+
+	// Unlisted teams still get one of the variants
+	if ok, _ := m.CheckAnswer("gamma", "varigory", 1, "answer123"); ok {
+		t.Error("Unlisted team got the base answer")
+	}
+}
+
+func TestMothballsVersion(t *testing.T) {
+	m := NewTestMothballs()
+	if version, ok := m.Version("pategory"); !ok {
+		t.Error("Category not found")
+	} else if len(version) != 64 {
+		t.Error("Mothball without a manifest isn't versioned by its hash:", version)
+	}
+	if _, ok := m.Version("nonexistent"); ok {
+		t.Error("Nonexistent category has a version")
+	}
+
+	src := afero.NewMemMapFs()
+	afero.WriteFile(src, "cat/1/puzzle.md", []byte("---\nanswers: [a]\n---\nBody\n"), 0644)
+	f, _ := m.Create("built.mb")
+	if err := transpile.Mothball(transpile.NewFsCategory(src, "cat"), f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	m.refresh()
+	if version, _ := m.Version("built"); version != m.categories["built"].Version() {
+		t.Error("Built mothball not versioned by its manifest:", version)
+	}
+}
+
+// swapTestState is just enough of a StateProvider to check mothball swaps.
+type swapTestState struct {
+	StateProvider
+	points award.List
+	events []string
+}
+
+func (s *swapTestState) PointsLog() award.List {
+	return s.points
+}
+
+func (s *swapTestState) LogEvent(event, teamID, cat string, points int, extra ...string) {
+	s.events = append(s.events, event)
+}
+
+func TestMothballSwap(t *testing.T) {
+	src := afero.NewMemMapFs()
+	m := NewMothballs(new(afero.MemMapFs))
+	state := new(swapTestState)
+	m.State = state
+
+	mtime := time.Now()
+	build := func(answer1, answer2 string) {
+		afero.WriteFile(src, "cat/1/puzzle.md", []byte("---\nanswers: ["+answer1+"]\n---\nOne\n"), 0644)
+		afero.WriteFile(src, "cat/2/puzzle.md", []byte("---\nanswers: ["+answer2+"]\n---\nTwo\n"), 0644)
+		f, _ := m.Create("cat.mb")
+		if err := transpile.Mothball(transpile.NewFsCategory(src, "cat"), f); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		mtime = mtime.Add(time.Second)
+		m.Chtimes("cat.mb", mtime, mtime)
+		m.refresh()
+	}
+	lastEvent := func() string {
+		if len(state.events) == 0 {
+			return ""
+		}
+		return state.events[len(state.events)-1]
+	}
+
+	build("a", "b")
+	state.points = award.List{{Category: "cat", Points: 1, TeamID: "team"}}
+
+	// Changing an unsolved puzzle is fine
+	build("a", "c")
+	if lastEvent() != "mothball-replaced" {
+		t.Error("Unsolved puzzle change not swapped in:", state.events)
+	}
+	if ok, _ := m.CheckAnswer("", "cat", 2, "c"); !ok {
+		t.Error("New answer not accepted")
+	}
+
+	// Changing a solved puzzle waits for confirmation
+	build("z", "c")
+	if lastEvent() != "mothball-refused" {
+		t.Error("Solved puzzle change wasn't refused:", state.events)
+	}
+	if ok, _ := m.CheckAnswer("", "cat", 1, "a"); !ok {
+		t.Error("Old mothball not kept after refusing the new one")
+	}
+	pending, ok := m.pending["cat"]
+	if !ok {
+		t.Fatal("Refused mothball not kept for confirmation")
+	}
+
+	// Not asked again until something changes
+	m.refresh()
+	if len(state.events) != 2 {
+		t.Error("Refused mothball checked again:", state.events)
+	}
+
+	afero.WriteFile(m.Fs, "cat.mb.confirm", []byte(pending.version+"\n"), 0644)
+	m.refresh()
+	if lastEvent() != "mothball-replaced" {
+		t.Error("Confirmed mothball not swapped in:", state.events)
+	}
+	if ok, _ := m.CheckAnswer("", "cat", 1, "z"); !ok {
+		t.Error("Confirmed mothball's answer not accepted")
+	}
+
+	// Warnings don't stop anything
+	m.SwapPolicy = SwapWarn
+	build("y", "c")
+	if lastEvent() != "mothball-replaced" {
+		t.Error("Swap refused with warn policy:", state.events)
+	}
+}
@@ -2,17 +2,15 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
-	"os"
 	"os/exec"
+	"path/filepath"
 	"sort"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/dirtbags/moth/v4/pkg/transpile"
@@ -22,16 +20,42 @@ import (
 type ProviderCommand struct {
 	Path string
 	Args []string
+
+	// CommandConfig controls how the command is run.
+	// mothd fills in anything not set from its own settings.
+	// Anything still not set comes from transpile.DefaultCommandConfig,
+	// except Timeout, which defaults to 100ms.
+	transpile.CommandConfig `yaml:",inline"`
+}
+
+// config returns the CommandConfig to run with, with defaults filled in.
+func (pc ProviderCommand) config() transpile.CommandConfig {
+	defaults := transpile.DefaultCommandConfig
+	defaults.Timeout = 100 * time.Millisecond
+	return pc.CommandConfig.Merge(defaults)
+}
+
+// cmd returns an *exec.Cmd to run the command, with env added to its environment.
+func (pc ProviderCommand) cmd(ctx context.Context, config transpile.CommandConfig, env ...string) *exec.Cmd {
+	program := pc.Path
+	if p, err := exec.LookPath(pc.Path); err == nil {
+		program = p
+	}
+	if p, err := filepath.Abs(program); err == nil {
+		program = p
+	}
+	return config.Command(ctx, program, env, pc.Args...)
 }
 
 // Inventory runs with "action=inventory", and parses the output into a category list.
+//
+// The command must print a JSON object mapping category names to lists of point values.
 func (pc ProviderCommand) Inventory() (inv []Category) {
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	config := pc.config()
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, pc.Path, pc.Args...)
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, "ACTION=inventory")
+	cmd := pc.cmd(ctx, config, "ACTION=inventory")
 
 	stdout, err := cmd.Output()
 	if err != nil {
@@ -39,29 +63,16 @@ func (pc ProviderCommand) Inventory() (inv []Category) {
 		return
 	}
 
-	for _, line := range strings.Split(string(stdout), "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		parts := strings.Split(line, " ")
-		if len(parts) < 2 {
-			log.Println("Skipping misformatted line:", line)
-			continue
-		}
-		name := parts[0]
-		puzzles := make([]int, 0, 10)
-		for _, pointsString := range parts[1:] {
-			points, err := strconv.Atoi(pointsString)
-			if err != nil {
-				log.Println(err)
-				continue
-			}
-			puzzles = append(puzzles, points)
-		}
+	categories := make(map[string][]int)
+	if err := json.Unmarshal(stdout, &categories); err != nil {
+		log.Printf("%s: parsing inventory: %v", pc.Path, err)
+		return
+	}
+	for name, puzzles := range categories {
 		sort.Ints(puzzles)
-		inv = append(inv, Category{name, puzzles})
+		inv = append(inv, Category{Name: name, Puzzles: puzzles})
 	}
+	sort.Slice(inv, func(i, j int) bool { return inv[i].Name < inv[j].Name })
 	return
 }
 
@@ -76,36 +87,47 @@ func (f NullReadSeekCloser) Close() error {
 }
 
 // Open passes its arguments to the command with "action=open".
-func (pc ProviderCommand) Open(cat string, points int, path string) (ReadSeekCloser, time.Time, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+//
+// Output is spooled to a temporary file, so large attachments don't fill up memory.
+func (pc ProviderCommand) Open(teamID string, cat string, points int, path string) (ReadSeekCloser, time.Time, error) {
+	config := pc.config()
+	ctx, cancel := context.WithTimeout(context.Background(), config.FileTimeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, pc.Path, pc.Args...)
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, "ACTION=open")
-	cmd.Env = append(cmd.Env, "CAT="+cat)
-	cmd.Env = append(cmd.Env, "POINTS="+strconv.Itoa(points))
-	cmd.Env = append(cmd.Env, "FILENAME="+path)
-
-	stdoutBytes, err := cmd.Output()
-	stdout := NullReadSeekCloser{bytes.NewReader(stdoutBytes)}
-	now := time.Now()
-	return stdout, now, err
+	cmd := pc.cmd(
+		ctx,
+		config,
+		"ACTION=open",
+		"TEAMID="+teamID,
+		"CAT="+cat,
+		"POINTS="+strconv.Itoa(points),
+		"FILENAME="+path,
+	)
+
+	f, err := transpile.SpoolCommand(cmd, config.MaxFileSize)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return f, time.Now(), nil
 }
 
 // CheckAnswer passes its arguments to the command with "action=answer".
 // If the command exits successfully and sends "correct" to stdout,
 // nil is returned.
-func (pc ProviderCommand) CheckAnswer(cat string, points int, answer string) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+func (pc ProviderCommand) CheckAnswer(teamID string, cat string, points int, answer string) (bool, error) {
+	config := pc.config()
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, pc.Path, pc.Args...)
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, "ACTION=answer")
-	cmd.Env = append(cmd.Env, "CAT="+cat)
-	cmd.Env = append(cmd.Env, "POINTS="+strconv.Itoa(points))
-	cmd.Env = append(cmd.Env, "ANSWER="+answer)
+	cmd := pc.cmd(
+		ctx,
+		config,
+		"ACTION=answer",
+		"TEAMID="+teamID,
+		"CAT="+cat,
+		"POINTS="+strconv.Itoa(points),
+		"ANSWER="+answer,
+	)
 
 	stdout, err := cmd.Output()
 	if ee, ok := err.(*exec.ExitError); ok {
@@ -124,10 +146,14 @@ func (pc ProviderCommand) CheckAnswer(cat string, points int, answer string) (bo
 }
 
 // Mothball just returns an error
-func (pc ProviderCommand) Mothball(cat string) (*bytes.Reader, error) {
-	return nil, fmt.Errorf("can't package a command-generated category")
+func (pc ProviderCommand) Mothball(cat string, w io.Writer) error {
+	return fmt.Errorf("can't package a command-generated category")
 }
 
 // Maintain does nothing: a command puzzle ProviderCommand has no housekeeping
 func (pc ProviderCommand) Maintain(updateInterval time.Duration) {
 }
+
+func (pc ProviderCommand) refresh() {
+	// Nothing to do for a command
+}
@@ -34,14 +34,14 @@ func TestProviderCommand(t *testing.T) {
 		}
 	}
 
-	if ok, err := pc.CheckAnswer("pategory", 1, "answer"); !ok {
+	if ok, err := pc.CheckAnswer("", "pategory", 1, "answer"); !ok {
 		t.Errorf("Correct answer for pategory: %v", err)
 	}
-	if ok, _ := pc.CheckAnswer("pategory", 1, "wrong"); ok {
+	if ok, _ := pc.CheckAnswer("", "pategory", 1, "wrong"); ok {
 		t.Errorf("Wrong answer for pategory judged correct")
 	}
 
-	if _, err := pc.CheckAnswer("pategory", 2, "answer"); err == nil {
+	if _, err := pc.CheckAnswer("", "pategory", 2, "answer"); err == nil {
 		t.Errorf("Internal error not returned")
 	} else if ee, ok := err.(*exec.ExitError); ok {
 		if string(ee.Stderr) != "Internal error\n" {
@@ -51,7 +51,7 @@ func TestProviderCommand(t *testing.T) {
 		t.Error(err)
 	}
 
-	if f, _, err := pc.Open("pategory", 1, "moo.txt"); err != nil {
+	if f, _, err := pc.Open("", "pategory", 1, "moo.txt"); err != nil {
 		t.Error(err)
 	} else if buf, err := ioutil.ReadAll(f); err != nil {
 		f.Close()
@@ -63,7 +63,7 @@ func TestProviderCommand(t *testing.T) {
 		f.Close()
 	}
 
-	if f, _, err := pc.Open("pategory", 1, "not.there"); err == nil {
+	if f, _, err := pc.Open("", "pategory", 1, "not.there"); err == nil {
 		f.Close()
 		t.Errorf("Non-existent file didn't return error: %#v", f)
 	}
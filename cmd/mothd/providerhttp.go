@@ -0,0 +1,249 @@
+// Provides a Puzzle interface that talks to a remote HTTP service
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dirtbags/moth/v4/pkg/transpile"
+)
+
+// RemoteConfig describes a remote HTTP puzzle provider.
+type RemoteConfig struct {
+	// URL is the base URL of the remote service
+	URL string
+
+	// Timeout is how long to wait for each request
+	Timeout time.Duration
+
+	// Retries is how many times to retry a failed request
+	Retries int
+
+	// MaxFileSize is the largest response accepted, in bytes.
+	// -1 means no limit.
+	// mothd uses its -max-file-size if this isn't set,
+	// and otherwise the default is transpile.DefaultCommandConfig.MaxFileSize.
+	MaxFileSize int64
+}
+
+// ProviderHTTP provides puzzles from a remote HTTP service.
+//
+// The inventory is cached, and updated by the maintenance loop.
+type ProviderHTTP struct {
+	RemoteConfig
+	client *http.Client
+
+	inventory     []Category
+	inventoryLock *sync.RWMutex
+}
+
+// NewProviderHTTP returns a new ProviderHTTP talking to the service described by config.
+func NewProviderHTTP(config RemoteConfig) *ProviderHTTP {
+	if config.Timeout == 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.MaxFileSize == 0 {
+		config.MaxFileSize = transpile.DefaultCommandConfig.MaxFileSize
+	}
+	config.URL = strings.TrimRight(config.URL, "/")
+	return &ProviderHTTP{
+		RemoteConfig: config,
+		client: &http.Client{
+			Timeout: config.Timeout,
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConns:        100,
+				MaxIdleConnsPerHost: 20,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+		inventoryLock: new(sync.RWMutex),
+	}
+}
+
+// do sends a request, retrying on network errors and server errors.
+//
+// newRequest is called for every attempt, so request bodies can be re-read.
+func (p *ProviderHTTP) do(newRequest func() (*http.Request, error)) (*http.Response, error) {
+	var lastErr error
+	for attempt := 0; attempt <= p.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := p.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			resp.Body.Close()
+			lastErr = fmt.Errorf("%s: %s: %s", req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+func (p *ProviderHTTP) get(endpoint string, params url.Values) (*http.Response, error) {
+	return p.do(func() (*http.Request, error) {
+		u := p.URL + endpoint
+		if len(params) > 0 {
+			u += "?" + params.Encode()
+		}
+		return http.NewRequest(http.MethodGet, u, nil)
+	})
+}
+
+// limit returns r, cut off after MaxFileSize bytes if there's a limit.
+func (p *ProviderHTTP) limit(r io.Reader) io.Reader {
+	if p.MaxFileSize < 0 {
+		return r
+	}
+	return io.LimitReader(r, p.MaxFileSize)
+}
+
+// fetchInventory asks the remote service for its inventory.
+func (p *ProviderHTTP) fetchInventory() ([]Category, error) {
+	resp, err := p.get("/inventory", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("inventory: %s", resp.Status)
+	}
+
+	categories := make(map[string][]int)
+	if err := json.NewDecoder(p.limit(resp.Body)).Decode(&categories); err != nil {
+		return nil, fmt.Errorf("inventory: %v", err)
+	}
+
+	inv := make([]Category, 0, len(categories))
+	for name, puzzles := range categories {
+		sort.Ints(puzzles)
+		inv = append(inv, Category{Name: name, Puzzles: puzzles})
+	}
+	sort.Slice(inv, func(i, j int) bool { return inv[i].Name < inv[j].Name })
+	return inv, nil
+}
+
+// Inventory returns the most recently fetched inventory.
+func (p *ProviderHTTP) Inventory() []Category {
+	p.inventoryLock.RLock()
+	inv := p.inventory
+	p.inventoryLock.RUnlock()
+	if inv == nil {
+		p.refresh()
+		p.inventoryLock.RLock()
+		inv = p.inventory
+		p.inventoryLock.RUnlock()
+	}
+	return inv
+}
+
+// Open fetches a file from the remote service.
+func (p *ProviderHTTP) Open(teamID string, cat string, points int, path string) (ReadSeekCloser, time.Time, error) {
+	params := url.Values{}
+	params.Set("team", teamID)
+	params.Set("cat", cat)
+	params.Set("points", strconv.Itoa(points))
+	params.Set("filename", path)
+
+	resp, err := p.get("/open", params)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("%s/%d/%s: %s", cat, points, path, resp.Status)
+	}
+
+	// Spool it, so large files don't fill up memory
+	f, err := transpile.Spool(resp.Body, p.MaxFileSize)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%s/%d/%s: %v", cat, points, path, err)
+	}
+
+	mtime := time.Now()
+	if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		mtime = lm
+	}
+	return f, mtime, nil
+}
+
+// CheckAnswer asks the remote service whether answer is correct.
+func (p *ProviderHTTP) CheckAnswer(teamID string, cat string, points int, answer string) (bool, error) {
+	form := url.Values{}
+	form.Set("team", teamID)
+	form.Set("cat", cat)
+	form.Set("points", strconv.Itoa(points))
+	form.Set("answer", answer)
+	body := form.Encode()
+
+	resp, err := p.do(func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, p.URL+"/answer", strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("answer: %s", resp.Status)
+	}
+
+	ans := transpile.AnswerResponse{}
+	if err := json.NewDecoder(p.limit(resp.Body)).Decode(&ans); err != nil {
+		return false, err
+	}
+	return ans.Correct, nil
+}
+
+// Mothball just returns an error
+func (p *ProviderHTTP) Mothball(cat string, w io.Writer) error {
+	return fmt.Errorf("can't package a remotely-provided category")
+}
+
+// refresh updates the cached inventory.
+// If the remote service can't be reached, the previous inventory is kept.
+func (p *ProviderHTTP) refresh() {
+	inv, err := p.fetchInventory()
+	p.inventoryLock.Lock()
+	defer p.inventoryLock.Unlock()
+	if err != nil {
+		log.Printf("%s: %v", p.URL, err)
+		if p.inventory == nil {
+			// Don't make every request wait on a dead service
+			p.inventory = []Category{}
+		}
+		return
+	}
+	p.inventory = inv
+}
+
+// Maintain keeps the cached inventory up to date.
+func (p *ProviderHTTP) Maintain(updateInterval time.Duration) {
+	p.refresh()
+	for range time.NewTicker(updateInterval).C {
+		p.refresh()
+	}
+}
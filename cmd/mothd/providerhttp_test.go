@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testRemote is a stand-in for a remote puzzle provider.
+//
+// It fails the first failures requests it gets with a 503,
+// to exercise retries.
+type testRemote struct {
+	failures int32
+	requests int32
+}
+
+func (tr *testRemote) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt32(&tr.requests, 1)
+	if atomic.AddInt32(&tr.failures, -1) >= 0 {
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch req.URL.Path {
+	case "/inventory":
+		json.NewEncoder(w).Encode(map[string][]int{
+			"remotegory": {3, 1, 2},
+		})
+	case "/open":
+		switch fmt.Sprintf("%s/%s/%s", req.FormValue("cat"), req.FormValue("points"), req.FormValue("filename")) {
+		case "remotegory/1/moo.txt":
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+			fmt.Fprint(w, "Moo.")
+		default:
+			http.NotFound(w, req)
+		}
+	case "/answer":
+		if req.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		correct := (req.FormValue("cat") == "remotegory") &&
+			(req.FormValue("points") == "1") &&
+			(req.FormValue("answer") == "moo")
+		json.NewEncoder(w).Encode(map[string]bool{"Correct": correct})
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func TestProviderHTTP(t *testing.T) {
+	remote := &testRemote{failures: 1}
+	srv := httptest.NewServer(remote)
+	defer srv.Close()
+
+	p := NewProviderHTTP(RemoteConfig{URL: srv.URL + "/", Retries: 2})
+
+	inv := p.Inventory()
+	if len(inv) != 1 {
+		t.Fatal("Wrong inventory:", inv)
+	} else if inv[0].Name != "remotegory" {
+		t.Error("Wrong category name:", inv[0].Name)
+	} else if inv[0].Puzzles[2] != 3 {
+		t.Error("Puzzles not sorted:", inv[0].Puzzles)
+	}
+
+	before := atomic.LoadInt32(&remote.requests)
+	p.Inventory()
+	if atomic.LoadInt32(&remote.requests) != before {
+		t.Error("Inventory wasn't cached")
+	}
+
+	if f, mtime, err := p.Open("", "remotegory", 1, "moo.txt"); err != nil {
+		t.Error(err)
+	} else if buf, err := ioutil.ReadAll(f); err != nil {
+		t.Error(err)
+	} else if string(buf) != "Moo." {
+		t.Error("Wrong contents:", string(buf))
+	} else if mtime.Year() != 2006 {
+		t.Error("Last-Modified not used:", mtime)
+	} else {
+		f.Close()
+	}
+
+	small := NewProviderHTTP(RemoteConfig{URL: srv.URL, MaxFileSize: 3})
+	if _, _, err := small.Open("", "remotegory", 1, "moo.txt"); err == nil {
+		t.Error("Oversized file didn't return an error")
+	}
+
+	if _, _, err := p.Open("", "remotegory", 1, "not.there"); err == nil {
+		t.Error("Non-existent file didn't return error")
+	}
+
+	if ok, err := p.CheckAnswer("", "remotegory", 1, "moo"); err != nil {
+		t.Error(err)
+	} else if !ok {
+		t.Error("Right answer marked wrong")
+	}
+	if ok, err := p.CheckAnswer("", "remotegory", 1, "oink"); err != nil {
+		t.Error(err)
+	} else if ok {
+		t.Error("Wrong answer marked right")
+	}
+
+	atomic.StoreInt32(&remote.failures, 5)
+	if _, err := p.CheckAnswer("", "remotegory", 1, "moo"); err == nil {
+		t.Error("Persistent failure should have returned an error")
+	}
+	p.refresh()
+	if len(p.Inventory()) != 1 {
+		t.Error("Inventory should survive a failed refresh")
+	}
+}
+
+func TestProviderHTTPDown(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	p := NewProviderHTTP(RemoteConfig{URL: srv.URL, Timeout: 100 * time.Millisecond})
+	if inv := p.Inventory(); len(inv) != 0 {
+		t.Error("Unreachable remote has an inventory:", inv)
+	}
+	if _, err := p.CheckAnswer("", "remotegory", 1, "moo"); err == nil {
+		t.Error("Unreachable remote didn't return an error")
+	}
+}
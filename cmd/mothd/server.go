@@ -1,18 +1,36 @@
 package main
 
 import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"path"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dirtbags/moth/v4/pkg/award"
+	"github.com/dirtbags/moth/v4/pkg/transpile"
 )
 
+// ErrNotRegistered is returned when an unregistered team asks for something only registered teams get.
+var ErrNotRegistered = errors.New("team is not registered")
+
 // Category represents a puzzle category.
 type Category struct {
 	Name    string
 	Puzzles []int
+
+	// Slugs maps the slugs of puzzles named by slug to their point values
+	Slugs map[string]int
+
+	// Tokens is true for categories of tokens, which have no puzzles
+	Tokens bool
 }
 
 // ReadSeekCloser defines a struct that can read, seek, and close.
@@ -34,13 +52,25 @@ type StateExport struct {
 	TeamNames map[string]string
 	PointsLog award.List
 	Puzzles   map[string][]int
+
+	// Categories describes the categories in Puzzles, for those which have a description
+	Categories map[string]transpile.CategoryInfo `json:",omitempty"`
+
+	// Slugs maps categories to their unlocked puzzles named by slug, and those puzzles' point values
+	Slugs map[string]map[string]int `json:",omitempty"`
+
+	// Titles maps categories to the titles of their unlocked puzzles, by puzzle ID
+	Titles map[string]map[string]string `json:",omitempty"`
 }
 
 // PuzzleProvider defines what's required to provide puzzles.
+//
+// Open and CheckAnswer are given the requesting team's ID,
+// so providers can serve each team its own variant of a puzzle.
 type PuzzleProvider interface {
-	Open(cat string, points int, path string) (ReadSeekCloser, time.Time, error)
+	Open(teamID string, cat string, points int, path string) (ReadSeekCloser, time.Time, error)
 	Inventory() []Category
-	CheckAnswer(cat string, points int, answer string) (bool, error)
+	CheckAnswer(teamID string, cat string, points int, answer string) (bool, error)
 	Mothball(cat string, w io.Writer) error
 	Maintainer
 }
@@ -58,10 +88,50 @@ type StateProvider interface {
 	TeamName(teamID string) (string, error)
 	SetTeamName(teamID, teamName string) error
 	AwardPoints(teamID string, cat string, points int) error
+	AwardSlug(teamID string, cat string, slug string, points int) error
 	LogEvent(event, teamID, cat string, points int, extra ...string)
 	Maintainer
 }
 
+// ChangeNotifier is implemented by puzzle providers which can announce changes to their puzzles.
+type ChangeNotifier interface {
+	// Subscribe returns a channel which receives the names of changed categories,
+	// and a function to call when no longer interested.
+	Subscribe() (<-chan string, func())
+}
+
+// CategoryDescriber is implemented by puzzle providers which can describe their categories.
+type CategoryDescriber interface {
+	// Describe returns a description of category cat,
+	// and whether it has one.
+	Describe(cat string) (transpile.CategoryInfo, bool)
+}
+
+// PuzzleTitler is implemented by puzzle providers which know the titles of their puzzles.
+type PuzzleTitler interface {
+	// Titles maps the IDs of puzzles in category cat to their titles.
+	// Puzzles without a title are left out.
+	Titles(cat string) map[string]string
+}
+
+// SlugProvider is implemented by puzzle providers with puzzles named by slug,
+// instead of by point value.
+//
+// Slug puzzles are listed in Category.Slugs.
+type SlugProvider interface {
+	// OpenSlug opens a file belonging to the puzzle named slug.
+	OpenSlug(teamID string, cat string, slug string, path string) (ReadSeekCloser, time.Time, error)
+
+	// CheckSlugAnswer checks whether answer is correct for the puzzle named slug.
+	CheckSlugAnswer(teamID string, cat string, slug string, answer string) (bool, error)
+}
+
+// TokenChecker is implemented by puzzle providers with token categories.
+type TokenChecker interface {
+	// CheckToken checks whether category cat has a token worth points with the given nonce.
+	CheckToken(cat string, points int, nonce string) (bool, error)
+}
+
 // Maintainer is something that can be maintained.
 type Maintainer interface {
 	// Maintain is the maintenance loop.
@@ -79,6 +149,9 @@ type MothServer struct {
 	Theme           ThemeProvider
 	State           StateProvider
 	Config          Configuration
+
+	// collisions remembers which duplicate categories have already been reported
+	collisions sync.Map
 }
 
 // NewMothServer returns a new MothServer.
@@ -91,6 +164,146 @@ func NewMothServer(config Configuration, theme ThemeProvider, state StateProvide
 	}
 }
 
+// catalog is a snapshot of every provider's inventory,
+// saying which provider owns each category.
+//
+// Asking a provider for its inventory can be expensive,
+// so anything that needs several lookups should take one catalog and use it throughout.
+type catalog struct {
+	categories []Category
+	byName     map[string]Category
+	owners     map[string]PuzzleProvider
+}
+
+// catalog asks every puzzle provider for its inventory, once.
+//
+// Providers are consulted in order.
+// If more than one provider offers a category with the same name,
+// the first provider wins, and a warning is logged.
+func (s *MothServer) catalog() catalog {
+	c := catalog{
+		categories: make([]Category, 0, 20),
+		byName:     make(map[string]Category),
+		owners:     make(map[string]PuzzleProvider),
+	}
+	owners := make(map[string]int)
+	for i, provider := range s.PuzzleProviders {
+		for _, category := range provider.Inventory() {
+			if owner, ok := owners[category.Name]; ok {
+				key := fmt.Sprintf("%s/%d/%d", category.Name, owner, i)
+				if _, reported := s.collisions.LoadOrStore(key, true); !reported {
+					log.Printf(
+						"WARNING: category %s offered by providers %d and %d; using provider %d",
+						category.Name, owner, i, owner,
+					)
+				}
+				continue
+			}
+			owners[category.Name] = i
+			c.categories = append(c.categories, category)
+			c.byName[category.Name] = category
+			c.owners[category.Name] = provider
+		}
+	}
+	return c
+}
+
+// provider returns the puzzle provider which owns category cat.
+func (c catalog) provider(cat string) (PuzzleProvider, error) {
+	provider, ok := c.owners[cat]
+	if !ok {
+		return nil, fmt.Errorf("no such category: %s", cat)
+	}
+	return provider, nil
+}
+
+// describe returns a description of category cat from the provider which owns it,
+// and whether it has one.
+func (c catalog) describe(cat string) (transpile.CategoryInfo, bool) {
+	describer, ok := c.owners[cat].(CategoryDescriber)
+	if !ok {
+		return transpile.CategoryInfo{}, false
+	}
+	return describer.Describe(cat)
+}
+
+// titles returns the titles of puzzles in category cat,
+// from the provider which owns it.
+func (c catalog) titles(cat string) map[string]string {
+	titler, ok := c.owners[cat].(PuzzleTitler)
+	if !ok {
+		return nil
+	}
+	return titler.Titles(cat)
+}
+
+// Inventory returns the categories offered by all puzzle providers.
+//
+// Providers are consulted in order.
+// If more than one provider offers a category with the same name,
+// the first provider wins, and a warning is logged.
+func (s *MothServer) Inventory() []Category {
+	return s.catalog().categories
+}
+
+// Provider returns the puzzle provider which owns category cat.
+//
+// A provider owns every category it lists in its Inventory.
+// When more than one provider lists a category,
+// the first one in PuzzleProviders owns it.
+func (s *MothServer) Provider(cat string) (PuzzleProvider, error) {
+	return s.catalog().provider(cat)
+}
+
+// Describe returns a description of category cat from the provider which owns it,
+// and whether it has one.
+func (s *MothServer) Describe(cat string) (transpile.CategoryInfo, bool) {
+	return s.catalog().describe(cat)
+}
+
+// Titles returns the titles of puzzles in category cat,
+// from the provider which owns it.
+func (s *MothServer) Titles(cat string) map[string]string {
+	return s.catalog().titles(cat)
+}
+
+// Subscribe returns a channel which receives the names of categories
+// changed in any provider which announces changes,
+// and a function to call when no longer interested.
+func (s *MothServer) Subscribe() (<-chan string, func()) {
+	changes := make(chan string, 16)
+	done := make(chan struct{})
+	var cancels []func()
+	for _, provider := range s.PuzzleProviders {
+		notifier, ok := provider.(ChangeNotifier)
+		if !ok {
+			continue
+		}
+		ch, cancel := notifier.Subscribe()
+		cancels = append(cancels, cancel)
+		go func() {
+			for {
+				select {
+				case cat := <-ch:
+					select {
+					case changes <- cat:
+					default:
+						// Nobody's keeping up; they'll get the next one
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	return changes, func() {
+		close(done)
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
+
 // NewHandler returns a new http.RequestHandler for the provided teamID.
 func (s *MothServer) NewHandler(teamID string) MothRequestHandler {
 	return MothRequestHandler{
@@ -106,62 +319,272 @@ type MothRequestHandler struct {
 }
 
 // PuzzlesOpen opens a file associated with a puzzle.
-// BUG(neale): Multiple providers with the same category name are not detected or handled well.
-func (mh *MothRequestHandler) PuzzlesOpen(cat string, points int, path string) (r ReadSeekCloser, ts time.Time, err error) {
-	export := mh.exportStateIfRegistered(true)
-	found := false
-	for _, p := range export.Puzzles[cat] {
-		if p == points {
-			found = true
-		}
-	}
+//
+// Puzzles are named by ID: their point value, or their slug if they have one.
+func (mh *MothRequestHandler) PuzzlesOpen(cat string, id string, path string) (r ReadSeekCloser, ts time.Time, err error) {
+	c := mh.catalog()
+	export := mh.exportState(c, true)
+	points, found := export.unlocked(cat, id)
 	if !found {
 		return nil, time.Time{}, fmt.Errorf("puzzle does not exist or is locked")
 	}
 
-	// Try every provider until someone doesn't return an error
-	for _, provider := range mh.PuzzleProviders {
-		r, ts, err = provider.Open(cat, points, path)
-		if err != nil {
-			return r, ts, err
-		}
+	provider, err := c.provider(cat)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	r, ts, err = openPuzzle(provider, mh.teamID, cat, id, path)
+	if err != nil {
+		return r, ts, err
 	}
 
 	// Log puzzle.json loads
 	if path == "puzzle.json" {
-		mh.State.LogEvent("load", mh.teamID, cat, points)
+		mh.logPuzzleEvent("load", cat, id, points)
 	}
 
 	return
 }
 
-// CheckAnswer returns an error if answer is not a correct answer for puzzle points in category cat
-func (mh *MothRequestHandler) CheckAnswer(cat string, points int, answer string) error {
-	correct := false
-	for _, provider := range mh.PuzzleProviders {
-		if ok, err := provider.CheckAnswer(cat, points, answer); err != nil {
-			return err
-		} else if ok {
-			correct = true
+// unlocked returns the point value of the puzzle in cat with the given ID,
+// and whether it's unlocked.
+func (export *StateExport) unlocked(cat string, id string) (int, bool) {
+	if transpile.IsSlug(id) {
+		points, ok := export.Slugs[cat][id]
+		return points, ok
+	}
+	points, _ := strconv.Atoi(id)
+	for _, p := range export.Puzzles[cat] {
+		if p == points {
+			return points, true
 		}
 	}
-	if !correct {
-		mh.State.LogEvent("wrong", mh.teamID, cat, points)
-		return fmt.Errorf("incorrect answer")
+	return points, false
+}
+
+// openPuzzle opens a file belonging to the puzzle in cat with the given ID.
+func openPuzzle(provider PuzzleProvider, teamID string, cat string, id string, path string) (ReadSeekCloser, time.Time, error) {
+	if !transpile.IsSlug(id) {
+		points, _ := strconv.Atoi(id)
+		return provider.Open(teamID, cat, points, path)
 	}
+	if sp, ok := provider.(SlugProvider); ok {
+		return sp.OpenSlug(teamID, cat, id, path)
+	}
+	return nil, time.Time{}, fmt.Errorf("no such puzzle: %s/%s", cat, id)
+}
 
-	mh.State.LogEvent("correct", mh.teamID, cat, points)
+// logPuzzleEvent logs an event about the puzzle in cat with the given ID.
+// Slug puzzles have their slug logged after the point value.
+func (mh *MothRequestHandler) logPuzzleEvent(event string, cat string, id string, points int) {
+	if transpile.IsSlug(id) {
+		mh.State.LogEvent(event, mh.teamID, cat, points, id)
+	} else {
+		mh.State.LogEvent(event, mh.teamID, cat, points)
+	}
+}
 
-	if _, err := mh.State.TeamName(mh.teamID); err != nil {
-		return fmt.Errorf("invalid team ID")
+// Download writes a zip file of every puzzle the team has unlocked,
+// with each puzzle's puzzle.json, attachments, and scripts under CATEGORY/ID/.
+//
+// Puzzles are unlocked by the same rules as PuzzlesOpen,
+// but only registered teams may download them.
+// ErrNotRegistered is returned before anything is written.
+// Files which can't be opened are logged and left out,
+// so one broken puzzle doesn't spoil the whole download.
+func (mh *MothRequestHandler) Download(w io.Writer) error {
+	if _, err := mh.State.TeamName(mh.teamID); (err != nil) && !mh.Config.Devel {
+		return ErrNotRegistered
+	}
+	c := mh.catalog()
+	export := mh.exportState(c, true)
+
+	cats := make([]string, 0, len(export.Puzzles))
+	for cat := range export.Puzzles {
+		cats = append(cats, cat)
+	}
+	sort.Strings(cats)
+
+	zw := zip.NewWriter(w)
+	for _, cat := range cats {
+		provider, err := c.provider(cat)
+		if err != nil {
+			log.Printf("Download: %v", err)
+			continue
+		}
+		for _, points := range export.Puzzles[cat] {
+			if points == 0 {
+				// Sentry: all puzzles in this category are open
+				continue
+			}
+			if err := mh.downloadPuzzle(zw, provider, cat, strconv.Itoa(points)); err != nil {
+				return err
+			}
+			mh.State.LogEvent("download", mh.teamID, cat, points)
+		}
+		slugs := make([]string, 0, len(export.Slugs[cat]))
+		for slug := range export.Slugs[cat] {
+			slugs = append(slugs, slug)
+		}
+		sort.Strings(slugs)
+		for _, slug := range slugs {
+			if err := mh.downloadPuzzle(zw, provider, cat, slug); err != nil {
+				return err
+			}
+			mh.logPuzzleEvent("download", cat, slug, export.Slugs[cat][slug])
+		}
+	}
+	return zw.Close()
+}
+
+// downloadPuzzle writes one puzzle and its files into zw.
+// Only errors writing to zw are returned.
+func (mh *MothRequestHandler) downloadPuzzle(zw *zip.Writer, provider PuzzleProvider, cat string, id string) error {
+	f, mtime, err := openPuzzle(provider, mh.teamID, cat, id, "puzzle.json")
+	if err != nil {
+		log.Printf("Download: %s/%s: %v", cat, id, err)
+		return nil
+	}
+	buf, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		log.Printf("Download: %s/%s: %v", cat, id, err)
+		return nil
 	}
-	if err := mh.State.AwardPoints(mh.teamID, cat, points); err != nil {
+	var puzzle transpile.Puzzle
+	if err := json.Unmarshal(buf, &puzzle); err != nil {
+		log.Printf("Download: %s/%s: %v", cat, id, err)
+		return nil
+	}
+
+	prefix := fmt.Sprintf("%s/%s/", cat, id)
+	zf, err := zw.CreateHeader(&zip.FileHeader{Name: prefix + "puzzle.json", Method: zip.Deflate, Modified: mtime})
+	if err != nil {
+		return err
+	}
+	if _, err := zf.Write(buf); err != nil {
 		return err
 	}
 
+	for _, filename := range append(puzzle.Attachments, puzzle.Scripts...) {
+		// Don't let a puzzle write outside its own directory
+		clean := path.Clean(filename)
+		if path.IsAbs(clean) || (clean == "..") || strings.HasPrefix(clean, "../") {
+			log.Printf("Download: %s/%s: refusing attachment %q", cat, id, filename)
+			continue
+		}
+		f, mtime, err := openPuzzle(provider, mh.teamID, cat, id, filename)
+		if err != nil {
+			log.Printf("Download: %s/%s/%s: %v", cat, id, filename, err)
+			continue
+		}
+		zf, err := zw.CreateHeader(&zip.FileHeader{Name: prefix + clean, Method: zip.Deflate, Modified: mtime})
+		if err == nil {
+			_, err = io.Copy(zf, f)
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// CheckAnswer returns an error if answer is not a correct answer
+// for the puzzle with the given ID in category cat.
+// Otherwise, it awards the puzzle's points, and returns how many there were.
+func (mh *MothRequestHandler) CheckAnswer(cat string, id string, answer string) (int, error) {
+	c := mh.catalog()
+	provider, err := c.provider(cat)
+	if err != nil {
+		return 0, err
+	}
+	points, ok := c.puzzlePoints(cat, id)
+	if !ok {
+		return 0, fmt.Errorf("no such puzzle: %s/%s", cat, id)
+	}
+
+	var correct bool
+	if !transpile.IsSlug(id) {
+		correct, err = provider.CheckAnswer(mh.teamID, cat, points, answer)
+	} else if sp, ok := provider.(SlugProvider); ok {
+		correct, err = sp.CheckSlugAnswer(mh.teamID, cat, id, answer)
+	}
+	if err != nil {
+		return 0, err
+	} else if !correct {
+		mh.logPuzzleEvent("wrong", cat, id, points)
+		return 0, fmt.Errorf("incorrect answer")
+	}
+
+	mh.logPuzzleEvent("correct", cat, id, points)
+
+	if _, err := mh.State.TeamName(mh.teamID); err != nil {
+		return 0, fmt.Errorf("invalid team ID")
+	}
+	if transpile.IsSlug(id) {
+		err = mh.State.AwardSlug(mh.teamID, cat, id, points)
+	} else {
+		err = mh.State.AwardPoints(mh.teamID, cat, points)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return points, nil
+}
+
+// RedeemToken awards the points for token, if it's a real token.
+//
+// Each token can be redeemed once by each team.
+//
+// Categories which aren't token categories are checked as if the token's nonce
+// was an answer to the puzzle worth its points,
+// which is how tokens used to be done.
+func (mh *MothRequestHandler) RedeemToken(token string) (transpile.Token, error) {
+	t, err := transpile.ParseToken(token)
+	if err != nil {
+		return t, err
+	}
+	c := mh.catalog()
+	provider, err := c.provider(t.Category)
+	if err != nil {
+		return t, err
+	}
+	tc, ok := provider.(TokenChecker)
+	if !ok || !c.byName[t.Category].Tokens {
+		_, err := mh.CheckAnswer(t.Category, strconv.Itoa(t.Points), t.Nonce)
+		return t, err
+	}
+
+	if correct, err := tc.CheckToken(t.Category, t.Points, t.Nonce); err != nil {
+		return t, err
+	} else if !correct {
+		mh.logPuzzleEvent("wrong", t.Category, t.Nonce, t.Points)
+		return t, fmt.Errorf("unknown token")
+	}
+
+	mh.logPuzzleEvent("correct", t.Category, t.Nonce, t.Points)
+
+	if _, err := mh.State.TeamName(mh.teamID); err != nil {
+		return t, fmt.Errorf("invalid team ID")
+	}
+	return t, mh.State.AwardSlug(mh.teamID, t.Category, t.Nonce, t.Points)
+}
+
+// puzzlePoints returns the point value of the puzzle in cat with the given ID,
+// and whether there is such a puzzle.
+//
+// Puzzles named by point value aren't looked up:
+// providers decide whether those exist.
+func (c catalog) puzzlePoints(cat string, id string) (int, bool) {
+	if !transpile.IsSlug(id) {
+		points, err := strconv.Atoi(id)
+		return points, err == nil
+	}
+	points, ok := c.byName[cat].Slugs[id]
+	return points, ok
+}
+
 // ThemeOpen opens a file from a theme.
 func (mh *MothRequestHandler) ThemeOpen(path string) (ReadSeekCloser, time.Time, error) {
 	return mh.Theme.Open(path)
@@ -181,13 +604,14 @@ func (mh *MothRequestHandler) Register(teamName string) error {
 // the anonymized team name for this teamID has the special value "self".
 // If not, the puzzles list is empty.
 func (mh *MothRequestHandler) ExportState() *StateExport {
-	return mh.exportStateIfRegistered(false)
+	return mh.exportState(mh.catalog(), false)
 }
 
 // Export state, replacing the team ID with "self" if the team is registered.
 //
-// If forceRegistered is true, go ahead and export it anyway
-func (mh *MothRequestHandler) exportStateIfRegistered(forceRegistered bool) *StateExport {
+// If forceRegistered is true, go ahead and export it anyway.
+// Categories come from c, so callers which already have a catalog can share it.
+func (mh *MothRequestHandler) exportState(c catalog, forceRegistered bool) *StateExport {
 	export := StateExport{}
 	export.Config = mh.Config
 
@@ -217,9 +641,17 @@ func (mh *MothRequestHandler) exportStateIfRegistered(forceRegistered bool) *Sta
 			awd.TeamID = exportID
 			export.TeamNames[exportID] = name
 		}
+		if c.byName[awd.Category].Tokens {
+			// The slug is the token's nonce: anybody who read it could redeem the token
+			awd.Slug = ""
+		}
 		export.PointsLog[logno] = awd
 
 		// Record the highest-value unlocked puzzle in each category
+		if _, _, recurring := awd.Recurring(); recurring {
+			// Holding a hill doesn't solve anything
+			continue
+		}
 		if awd.Points > maxSolved[awd.Category] {
 			maxSolved[awd.Category] = awd.Points
 		}
@@ -230,21 +662,76 @@ func (mh *MothRequestHandler) exportStateIfRegistered(forceRegistered bool) *Sta
 		// We used to hand this out to everyone,
 		// but then we got a bad reputation on some secretive blacklist,
 		// and now the Navy can't register for events.
-		for _, provider := range mh.PuzzleProviders {
-			for _, category := range provider.Inventory() {
+		for _, category := range c.categories {
+			info, described := c.describe(category.Name)
+			if described {
+				if export.Categories == nil {
+					export.Categories = make(map[string]transpile.CategoryInfo)
+				}
+				export.Categories[category.Name] = info
+			}
+			if category.Tokens {
+				// Nothing to open: tokens are found elsewhere
+				continue
+			}
+
+			// Everything up to the next point value after the most valuable puzzle solved is open.
+			// Slug puzzles count, too.
+			max := maxSolved[category.Name]
+			next := 0
+			for _, points := range category.Puzzles {
+				if (points > max) && ((next == 0) || (points < next)) {
+					next = points
+				}
+			}
+			for _, points := range category.Slugs {
+				if (points > max) && ((next == 0) || (points < next)) {
+					next = points
+				}
+			}
+			openAll := mh.Config.Devel || (info.Unlock == transpile.UnlockAll) || (next == 0)
+
+			puzzles := make([]int, 0, len(category.Puzzles)+1)
+			var ids []string
+			for _, points := range category.Puzzles {
+				if openAll || (points <= next) {
+					puzzles = append(puzzles, points)
+					ids = append(ids, strconv.Itoa(points))
+				}
+			}
+			if openAll {
 				// Append sentry (end of puzzles)
-				allPuzzles := append(category.Puzzles, 0)
+				puzzles = append(puzzles, 0)
+			}
+			export.Puzzles[category.Name] = puzzles
 
-				max := maxSolved[category.Name]
+			for slug, points := range category.Slugs {
+				if !openAll && (points > next) {
+					continue
+				}
+				if export.Slugs == nil {
+					export.Slugs = make(map[string]map[string]int)
+				}
+				if export.Slugs[category.Name] == nil {
+					export.Slugs[category.Name] = make(map[string]int)
+				}
+				export.Slugs[category.Name][slug] = points
+				ids = append(ids, slug)
+			}
 
-				puzzles := make([]int, 0, len(allPuzzles))
-				for i, val := range allPuzzles {
-					puzzles = allPuzzles[:i+1]
-					if !mh.Config.Devel && (val > max) {
-						break
-					}
+			titles := c.titles(category.Name)
+			for _, id := range ids {
+				title, ok := titles[id]
+				if !ok {
+					continue
 				}
-				export.Puzzles[category.Name] = puzzles
+				if export.Titles == nil {
+					export.Titles = make(map[string]map[string]string)
+				}
+				if export.Titles[category.Name] == nil {
+					export.Titles[category.Name] = make(map[string]string)
+				}
+				export.Titles[category.Name][id] = title
 			}
 		}
 	}
@@ -254,15 +741,12 @@ func (mh *MothRequestHandler) exportStateIfRegistered(forceRegistered bool) *Sta
 
 // Mothball generates a mothball for the given category.
 func (mh *MothRequestHandler) Mothball(cat string, w io.Writer) error {
-	var err error
-
 	if !mh.Config.Devel {
 		return fmt.Errorf("cannot mothball in production mode")
 	}
-	for _, provider := range mh.PuzzleProviders {
-		if err = provider.Mothball(cat, w); err == nil {
-			return nil
-		}
+	provider, err := mh.Provider(cat)
+	if err != nil {
+		return err
 	}
-	return err
+	return provider.Mothball(cat, w)
 }
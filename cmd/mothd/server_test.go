@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"io/ioutil"
+	"strings"
 	"testing"
 
+	"github.com/dirtbags/moth/v4/pkg/transpile"
 	"github.com/spf13/afero"
 )
 
@@ -111,7 +114,7 @@ func TestProdServer(t *testing.T) {
 		}
 	}
 
-	if r, _, err := handler.PuzzlesOpen("pategory", 1, "moo.txt"); err != nil {
+	if r, _, err := handler.PuzzlesOpen("pategory", "1", "moo.txt"); err != nil {
 		t.Error(err)
 	} else if contents, err := ioutil.ReadAll(r); err != nil {
 		r.Close()
@@ -123,20 +126,20 @@ func TestProdServer(t *testing.T) {
 		r.Close()
 	}
 
-	if r, _, err := handler.PuzzlesOpen("pategory", 2, "puzzle.json"); err == nil {
+	if r, _, err := handler.PuzzlesOpen("pategory", "2", "puzzle.json"); err == nil {
 		t.Error("Opening locked puzzle shouldn't work")
 		r.Close()
 	}
 
-	if r, _, err := handler.PuzzlesOpen("pategory", 20, "puzzle.json"); err == nil {
+	if r, _, err := handler.PuzzlesOpen("pategory", "20", "puzzle.json"); err == nil {
 		t.Error("Opening non-existent puzzle shouldn't work")
 		r.Close()
 	}
 
-	if err := anonHandler.CheckAnswer("pategory", 1, "answer123"); err == nil {
+	if _, err := anonHandler.CheckAnswer("pategory", "1", "answer123"); err == nil {
 		t.Error("Invalid team ID was able to get points with correct answer")
 	}
-	if err := handler.CheckAnswer("pategory", 1, "answer123"); err != nil {
+	if _, err := handler.CheckAnswer("pategory", "1", "answer123"); err != nil {
 		t.Error("Right answer marked wrong", err)
 	}
 
@@ -154,18 +157,18 @@ func TestProdServer(t *testing.T) {
 		}
 	}
 
-	if r, _, err := handler.PuzzlesOpen("pategory", 2, "puzzle.json"); err != nil {
+	if r, _, err := handler.PuzzlesOpen("pategory", "2", "puzzle.json"); err != nil {
 		t.Error("Opening unlocked puzzle should work")
 	} else {
 		r.Close()
 	}
-	if r, _, err := anonHandler.PuzzlesOpen("pategory", 2, "puzzle.json"); err != nil {
+	if r, _, err := anonHandler.PuzzlesOpen("pategory", "2", "puzzle.json"); err != nil {
 		t.Error("Opening unlocked puzzle anonymously should work")
 	} else {
 		r.Close()
 	}
 
-	if err := handler.CheckAnswer("pategory", 2, "wat"); err != nil {
+	if _, err := handler.CheckAnswer("pategory", "2", "wat"); err != nil {
 		t.Error("Right answer marked wrong:", err)
 	}
 
@@ -192,3 +195,308 @@ func TestProdServer(t *testing.T) {
 
 	// BUG(neale): We aren't currently testing the various ways to disable the server
 }
+
+func TestMultipleProviders(t *testing.T) {
+	first := NewTestMothballs()
+	second := NewMothballs(new(afero.MemMapFs))
+	second.createMothballWithFiles(
+		"pategory",
+		[]testFileContents{
+			{"1/moo.txt", "second"},
+		},
+	)
+	second.createMothball("nealegory")
+	second.refresh()
+
+	state := NewTestState()
+	afero.WriteFile(state, "teamids.txt", []byte("teamID\n"), 0644)
+	state.refresh()
+
+	server := NewMothServer(Configuration{}, NewTestTheme(), state, first, second)
+
+	inv := server.Inventory()
+	if len(inv) != 2 {
+		t.Error("Wrong inventory:", inv)
+	}
+
+	handler := server.NewHandler(TestTeamID)
+	if err := handler.Register("team"); err != nil {
+		t.Error(err)
+	}
+	state.refresh()
+
+	es := handler.ExportState()
+	if len(es.Puzzles) != 2 {
+		t.Error("Wrong categories exported:", es.Puzzles)
+	}
+
+	if r, _, err := handler.PuzzlesOpen("pategory", "1", "moo.txt"); err != nil {
+		t.Error(err)
+	} else if contents, err := ioutil.ReadAll(r); err != nil {
+		t.Error(err)
+	} else if string(contents) != "moo" {
+		t.Error("First provider didn't win:", string(contents))
+	}
+
+	if r, _, err := handler.PuzzlesOpen("nealegory", "1", "moo.txt"); err != nil {
+		t.Error("Second provider's category didn't open:", err)
+	} else {
+		r.Close()
+	}
+
+	if _, err := handler.CheckAnswer("nealegory", "1", "answer123"); err != nil {
+		t.Error("Answer not routed to second provider:", err)
+	}
+}
+
+func TestProviderRouting(t *testing.T) {
+	mothballs := NewTestMothballs()
+	command := ProviderCommand{Path: "testdata/testpiler.sh"}
+
+	state := NewTestState()
+	afero.WriteFile(state, "teamids.txt", []byte("teamID\n"), 0644)
+	state.refresh()
+
+	server := NewMothServer(Configuration{}, NewTestTheme(), state, mothballs, command)
+	handler := server.NewHandler(TestTeamID)
+	if err := handler.Register("team"); err != nil {
+		t.Error(err)
+	}
+	state.refresh()
+
+	if p, err := server.Provider("pategory"); err != nil {
+		t.Error(err)
+	} else if p != mothballs {
+		t.Error("pategory should belong to the mothballs provider")
+	}
+	if p, err := server.Provider("nealegory"); err != nil {
+		t.Error(err)
+	} else if _, ok := p.(ProviderCommand); !ok {
+		t.Error("nealegory should belong to the command provider")
+	}
+	if _, err := server.Provider("bozo"); err == nil {
+		t.Error("Nobody should own the bozo category")
+	}
+
+	if r, _, err := handler.PuzzlesOpen("pategory", "1", "moo.txt"); err != nil {
+		t.Error(err)
+	} else if contents, err := ioutil.ReadAll(r); err != nil {
+		t.Error(err)
+	} else if string(contents) != "moo" {
+		t.Error("Command provider shadowed mothball:", string(contents))
+	}
+
+	if r, _, err := handler.PuzzlesOpen("nealegory", "1", "moo.txt"); err != nil {
+		t.Error(err)
+	} else if contents, err := ioutil.ReadAll(r); err != nil {
+		t.Error(err)
+	} else if string(contents) != "Moo.\n" {
+		t.Error("Wrong contents from command provider:", string(contents))
+	}
+
+	// The command provider would reject this, but it doesn't own pategory
+	if _, err := handler.CheckAnswer("pategory", "1", "answer123"); err != nil {
+		t.Error("Right answer marked wrong:", err)
+	}
+	if _, err := handler.CheckAnswer("bozo", "1", "answer123"); err == nil {
+		t.Error("Answer accepted for non-existent category")
+	}
+}
+
+func TestCategoryMetadata(t *testing.T) {
+	server := NewTestServer()
+	mothballs := server.PuzzleProviders[0].(*Mothballs)
+	mothballs.createMothballWithFiles(
+		"described",
+		[]testFileContents{
+			{"mothball.json", `{"Format": 2, "Category": {"Title": "Described Category", "Unlock": "all"}}`},
+		},
+	)
+	server.refresh()
+
+	handler := server.NewHandler(TestTeamID)
+	if err := handler.Register("team"); err != nil {
+		t.Error(err)
+	}
+	server.refresh()
+
+	es := handler.ExportState()
+	if info, ok := es.Categories["described"]; !ok {
+		t.Error("Category description not exported:", es.Categories)
+	} else if info.Title != "Described Category" {
+		t.Error("Wrong title:", info.Title)
+	}
+	if _, ok := es.Categories["pategory"]; ok {
+		t.Error("Description exported for a mothball without one")
+	}
+	if len(es.Puzzles["described"]) != 4 {
+		t.Error("Unlock policy ignored:", es.Puzzles["described"])
+	}
+	if len(es.Puzzles["pategory"]) != 1 {
+		t.Error("Progressive unlocking broken:", es.Puzzles["pategory"])
+	}
+}
+
+func TestPuzzleTitles(t *testing.T) {
+	server := NewTestServer()
+	mothballs := server.PuzzleProviders[0].(*Mothballs)
+	mothballs.createMothballWithFiles(
+		"titled",
+		[]testFileContents{
+			{"puzzles.json", `[{"Points": 1, "Title": "One"}, {"Points": 2, "Title": "Two"}, {"Points": 3}]`},
+		},
+	)
+	server.refresh()
+
+	handler := server.NewHandler(TestTeamID)
+	if err := handler.Register("team"); err != nil {
+		t.Error(err)
+	}
+	server.refresh()
+
+	es := handler.ExportState()
+	titles := es.Titles["titled"]
+	if titles["1"] != "One" {
+		t.Error("Wrong titles:", es.Titles)
+	}
+	if _, ok := titles["2"]; ok {
+		t.Error("Title of a locked puzzle exported:", titles)
+	}
+	if _, ok := es.Titles["pategory"]; ok {
+		t.Error("Titles exported for a mothball without any")
+	}
+}
+
+func TestSlugPuzzles(t *testing.T) {
+	server := NewTestServer()
+	mothballs := server.PuzzleProviders[0].(*Mothballs)
+
+	fs := afero.NewMemMapFs()
+	for _, points := range []string{"1", "2", "3"} {
+		afero.WriteFile(fs, "slugs/"+points+"/puzzle.md", []byte("---\nanswers: [answer"+points+"]\n---\nBody\n"), 0644)
+	}
+	afero.WriteFile(fs, "slugs/bonus/puzzle.md", []byte("---\npoints: 2\ntitle: Bonus\nanswers: [xyzzy]\n---\nBonus\n"), 0644)
+	mb := new(bytes.Buffer)
+	if err := transpile.Mothball(transpile.NewFsCategory(fs, "slugs"), mb); err != nil {
+		t.Fatal(err)
+	}
+	afero.WriteFile(mothballs.Fs, "slugs.mb", mb.Bytes(), 0644)
+	server.refresh()
+
+	handler := server.NewHandler(TestTeamID)
+	if err := handler.Register("team"); err != nil {
+		t.Error(err)
+	}
+	server.refresh()
+
+	es := handler.ExportState()
+	if _, ok := es.Slugs["slugs"]["bonus"]; ok {
+		t.Error("Locked slug puzzle exported:", es.Slugs)
+	}
+	if _, _, err := handler.PuzzlesOpen("slugs", "bonus", "puzzle.json"); err == nil {
+		t.Error("Opened a locked slug puzzle")
+	}
+
+	if _, err := handler.CheckAnswer("slugs", "1", "answer1"); err != nil {
+		t.Fatal(err)
+	}
+	server.refresh()
+
+	es = handler.ExportState()
+	if es.Slugs["slugs"]["bonus"] != 2 {
+		t.Error("Slug puzzle not unlocked:", es.Slugs)
+	}
+	if es.Titles["slugs"]["bonus"] != "Bonus" {
+		t.Error("Wrong titles:", es.Titles)
+	}
+	if r, _, err := handler.PuzzlesOpen("slugs", "bonus", "puzzle.json"); err != nil {
+		t.Error(err)
+	} else {
+		r.Close()
+	}
+
+	if _, err := handler.CheckAnswer("slugs", "bonus", "answer2"); err == nil {
+		t.Error("Wrong answer accepted")
+	}
+	if _, err := handler.CheckAnswer("slugs", "bonus", "xyzzy"); err != nil {
+		t.Fatal(err)
+	}
+	server.refresh()
+
+	// Solving the slug puzzle doesn't count as solving puzzle 2
+	if _, err := handler.CheckAnswer("slugs", "2", "answer2"); err != nil {
+		t.Error(err)
+	}
+	if _, err := handler.CheckAnswer("slugs", "bonus", "xyzzy"); err == nil {
+		t.Error("Slug puzzle awarded twice")
+	}
+	server.refresh()
+
+	var slugAwards int
+	for _, awd := range server.State.PointsLog() {
+		if awd.Slug == "bonus" {
+			slugAwards++
+			if awd.Points != 2 {
+				t.Error("Wrong points for slug award:", awd)
+			}
+		}
+	}
+	if slugAwards != 1 {
+		t.Error("Wrong slug awards:", server.State.PointsLog())
+	}
+}
+
+// countingProvider counts calls to Inventory.
+type countingProvider struct {
+	*Mothballs
+	inventories int
+}
+
+func (p *countingProvider) Inventory() []Category {
+	p.inventories++
+	return p.Mothballs.Inventory()
+}
+
+func TestInventoryOncePerRequest(t *testing.T) {
+	mothballs := NewTestMothballs()
+	mothballs.refresh()
+	provider := &countingProvider{Mothballs: mothballs}
+
+	state := NewTestState()
+	afero.WriteFile(state, "teamids.txt", []byte("teamID\n"), 0644)
+	state.refresh()
+
+	server := NewMothServer(Configuration{}, NewTestTheme(), state, provider)
+	handler := server.NewHandler(TestTeamID)
+	if err := handler.Register("team"); err != nil {
+		t.Error(err)
+	}
+	state.refresh()
+
+	if es := handler.ExportState(); len(es.Puzzles) == 0 {
+		t.Error("No puzzles exported:", es.Puzzles)
+	}
+	if provider.inventories != 1 {
+		t.Error("Exporting state took this many inventories:", provider.inventories)
+	}
+
+	provider.inventories = 0
+	if r, _, err := handler.PuzzlesOpen("pategory", "1", "puzzle.json"); err != nil {
+		t.Error(err)
+	} else {
+		r.Close()
+	}
+	if provider.inventories != 1 {
+		t.Error("Opening a puzzle took this many inventories:", provider.inventories)
+	}
+
+	provider.inventories = 0
+	hs := NewHTTPServer("/", server)
+	r := hs.TestRequest("/answer", map[string]string{"cat": "pategory", "points": "1", "answer": "answer123"})
+	if body := r.Body.String(); !strings.Contains(body, "1 points awarded in pategory") {
+		t.Error("Wrong answer response:", body)
+	}
+	if provider.inventories != 1 {
+		t.Error("Answering took this many inventories:", provider.inventories)
+	}
+}
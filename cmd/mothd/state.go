@@ -49,6 +49,7 @@ type State struct {
 	teamNamesLastChange time.Time
 	teamNames           map[string]string
 	pointsLog           award.List
+	awarded             map[award.Key]bool // everything in pointsLog, for duplicate checks
 	lock                sync.RWMutex
 }
 
@@ -61,6 +62,7 @@ func NewState(fs afero.Fs) *State {
 		eventStream: make(chan []string, 80),
 
 		teamNames: make(map[string]string),
+		awarded:   make(map[award.Key]bool),
 	}
 	if err := s.reopenEventLog(); err != nil {
 		log.Fatal(err)
@@ -207,18 +209,33 @@ func (s *State) AwardPoints(teamID, category string, points int) error {
 	return s.awardPointsAtTime(time.Now().Unix(), teamID, category, points)
 }
 
+// AwardSlug gives points to teamID for the puzzle named slug in category.
+// It's otherwise just like AwardPoints.
+func (s *State) AwardSlug(teamID, category, slug string, points int) error {
+	return s.award(award.T{
+		When:     time.Now().Unix(),
+		TeamID:   teamID,
+		Category: category,
+		Points:   points,
+		Slug:     slug,
+	})
+}
+
 func (s *State) awardPointsAtTime(when int64, teamID string, category string, points int) error {
-	a := award.T{
+	return s.award(award.T{
 		When:     when,
 		TeamID:   teamID,
 		Category: category,
 		Points:   points,
-	}
+	})
+}
 
-	for _, e := range s.PointsLog() {
-		if a.Equal(e) {
-			return fmt.Errorf("points already awarded to this team in this category")
-		}
+func (s *State) award(a award.T) error {
+	s.lock.RLock()
+	duplicate := s.awarded[a.Key()]
+	s.lock.RUnlock()
+	if duplicate {
+		return fmt.Errorf("points already awarded to this team in this category")
 	}
 
 	//fn := fmt.Sprintf("%s-%s-%d", a.TeamID, a.Category, a.Points)
@@ -261,14 +278,8 @@ func (s *State) collectPoints() {
 			continue
 		}
 
-		duplicate := false
 		s.lock.RLock()
-		for _, e := range s.pointsLog {
-			if awd.Equal(e) {
-				duplicate = true
-				break
-			}
-		}
+		duplicate := s.awarded[awd.Key()]
 		s.lock.RUnlock()
 
 		if duplicate {
@@ -287,6 +298,7 @@ func (s *State) collectPoints() {
 			// Stick this on the cache too
 			s.lock.Lock()
 			s.pointsLog = append(s.pointsLog, awd)
+			s.awarded[awd.Key()] = true
 			s.lock.Unlock()
 		}
 
@@ -413,6 +425,7 @@ func (s *State) updateCaches() {
 		defer f.Close()
 
 		pointsLog := make(award.List, 0, 200)
+		awarded := make(map[award.Key]bool)
 		scanner := bufio.NewScanner(f)
 		for scanner.Scan() {
 			line := scanner.Text()
@@ -422,8 +435,10 @@ func (s *State) updateCaches() {
 				continue
 			}
 			pointsLog = append(pointsLog, cur)
+			awarded[cur.Key()] = true
 		}
 		s.pointsLog = pointsLog
+		s.awarded = awarded
 	}
 
 	// Only do this if the teams directory has a newer mtime; directories with
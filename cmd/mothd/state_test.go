@@ -128,6 +128,21 @@ func TestState(t *testing.T) {
 		t.Error("Intentional parse error screws up all parsing")
 	}
 
+	// Points logs from before slugs could have anything after the points
+	afero.WriteFile(s, "points.log", []byte(fmt.Sprintf(
+		"1536958399 %[1]s %[2]s %[3]d cli:first blood bonus\n1536958400 %[1]s %[2]s %[4]d hand scored\n1536958401 %[1]s %[2]s %[5]d bonus\n",
+		teamID, category, points, points+1, points+2,
+	)), 0644)
+	s.refresh()
+	if pl := s.PointsLog(); len(pl) != 3 {
+		t.Error("Old points log lines dropped:", pl)
+	}
+	for _, p := range []int{points, points + 2} {
+		if err := s.AwardPoints(teamID, category, p); err == nil {
+			t.Error("Points awarded again after an old points log line:", p)
+		}
+	}
+
 	s.Fs.Remove("initialized")
 	s.refresh()
 
@@ -2,9 +2,17 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dirtbags/moth/v4/pkg/transpile"
@@ -12,29 +20,248 @@ import (
 )
 
 // NewTranspilerProvider returns a new TranspilerProvider.
+//
+// Transpiled puzzles and the inventory are cached,
+// and the maintenance loop throws out anything from categories whose files have changed.
 func NewTranspilerProvider(fs afero.Fs) TranspilerProvider {
-	return TranspilerProvider{fs}
+	return TranspilerProvider{
+		fs:    fs,
+		cache: newPuzzleCache(),
+	}
+}
+
+// NewTeamTranspilerProvider returns a new TranspilerProvider
+// which generates a separate variant of every puzzle for each team.
+//
+// Generators (mkpuzzle and mkcategory) are run with SEED derived from seed and the team ID.
+// Generated puzzles are cached,
+// and answers are checked against the requesting team's variant.
+// Answers and debugging information are never sent to clients,
+// so this is suitable for production servers.
+func NewTeamTranspilerProvider(fs afero.Fs, seed string) TranspilerProvider {
+	return TranspilerProvider{
+		fs:      fs,
+		seed:    seed,
+		perTeam: true,
+		cache:   newPuzzleCache(),
+	}
 }
 
 // TranspilerProvider provides puzzles generated from source files on disk
 type TranspilerProvider struct {
-	fs afero.Fs
+	// CommandConfig controls how generators are run,
+	// for anything not set in a category's configuration file.
+	// Anything not set here comes from transpile.DefaultCommandConfig.
+	CommandConfig transpile.CommandConfig
+
+	fs      afero.Fs
+	seed    string
+	perTeam bool
+	cache   *puzzleCache
+}
+
+// puzzleCache holds transpiled puzzles and the inventory.
+type puzzleCache struct {
+	// puzzles maps category names to puzzles, by cache key
+	puzzles map[string]map[string]transpile.Puzzle
+
+	// stamps maps category names to a summary of their files' names, sizes, and modification times
+	stamps map[string]string
+
+	// infos maps category names to their descriptions
+	infos map[string]transpile.CategoryInfo
+
+	// titles maps category names to their puzzles' titles, by puzzle ID
+	titles map[string]map[string]string
+
+	// files maps category names to spooled copies of per-team attachments, by cache key
+	files map[string]map[string]cachedFile
+
+	// tempDir holds everything in files, and is made the first time it's needed
+	tempDir string
+
+	inventory []Category
+
+	// subscribers are told the names of changed categories
+	subscribers map[chan string]struct{}
+
+	lock sync.RWMutex
+}
+
+func newPuzzleCache() *puzzleCache {
+	return &puzzleCache{
+		puzzles:     make(map[string]map[string]transpile.Puzzle),
+		stamps:      make(map[string]string),
+		infos:       make(map[string]transpile.CategoryInfo),
+		titles:      make(map[string]map[string]string),
+		files:       make(map[string]map[string]cachedFile),
+		subscribers: make(map[chan string]struct{}),
+	}
+}
+
+// createFile creates a new temporary file for a cached attachment.
+// The caller must hold the write lock.
+func (c *puzzleCache) createFile() (*os.File, error) {
+	if c.tempDir == "" {
+		dir, err := os.MkdirTemp("", "moth-attachments-")
+		if err != nil {
+			return nil, err
+		}
+		c.tempDir = dir
+	}
+	return os.CreateTemp(c.tempDir, "attachment-")
+}
+
+// cachedFile is a generated attachment, spooled to a temporary file.
+type cachedFile struct {
+	path  string
+	mtime time.Time
+}
+
+// TeamSeed derives a team's generator seed from the server seed.
+func TeamSeed(seed, teamID string) string {
+	sum := sha256.Sum256([]byte(seed + "\x00" + teamID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// category returns the transpile.Category teamID should see.
+func (p TranspilerProvider) category(teamID string, cat string) transpile.Category {
+	if p.perTeam {
+		return p.fsCategory(cat, TeamSeed(p.seed, teamID))
+	}
+	return p.fsCategory(cat, "")
+}
+
+// fsCategory returns cat, with generators run with SEED set to seed.
+func (p TranspilerProvider) fsCategory(cat string, seed string) transpile.Category {
+	return transpile.NewFsCategoryConfig(p.fs, cat, seed, p.CommandConfig.Merge(transpile.DefaultCommandConfig))
+}
+
+// puzzle returns the puzzle with the given ID that teamID should see, answers and all.
+func (p TranspilerProvider) puzzle(teamID string, cat string, id string) (transpile.Puzzle, error) {
+	key := id
+	if p.perTeam {
+		key = TeamSeed(p.seed, teamID) + "/" + key
+	}
+
+	p.cache.lock.RLock()
+	puzzle, ok := p.cache.puzzles[cat][key]
+	p.cache.lock.RUnlock()
+	if ok {
+		return puzzle, nil
+	}
+
+	puzzle, err := transpile.CategoryPuzzle(p.category(teamID, cat), id)
+	if err != nil {
+		// Don't cache errors: the author is probably fixing it right now
+		return puzzle, err
+	}
+	p.cache.lock.Lock()
+	if p.cache.puzzles[cat] == nil {
+		p.cache.puzzles[cat] = make(map[string]transpile.Puzzle)
+	}
+	p.cache.puzzles[cat][key] = puzzle
+	p.cache.lock.Unlock()
+	return puzzle, nil
 }
 
 // Inventory returns a Category list for this provider.
 func (p TranspilerProvider) Inventory() []Category {
+	p.cache.lock.RLock()
+	inv := p.cache.inventory
+	p.cache.lock.RUnlock()
+	if inv != nil {
+		return inv
+	}
+
+	inv = p.inventory()
+	p.cache.lock.Lock()
+	p.cache.inventory = inv
+	p.cache.lock.Unlock()
+	return inv
+}
+
+// inventory reads the inventory from disk.
+func (p TranspilerProvider) inventory() []Category {
 	ret := make([]Category, 0)
-	inv, err := transpile.FsInventory(p.fs)
+	inv, err := transpile.FsInventoryConfig(p.fs, p.CommandConfig.Merge(transpile.DefaultCommandConfig))
 	if err != nil {
 		log.Print(err)
 		return ret
 	}
 	for name, points := range inv {
-		ret = append(ret, Category{name, points})
+		category := Category{Name: name, Puzzles: points}
+		if sc, ok := p.fsCategory(name, "").(transpile.SlugCategory); ok {
+			if category.Slugs, err = sc.Slugs(); err != nil {
+				log.Printf("%s: %v", name, err)
+			}
+		}
+		ret = append(ret, category)
 	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
 	return ret
 }
 
+// Describe returns the description from cat's configuration file, if it has one.
+func (p TranspilerProvider) Describe(cat string) (transpile.CategoryInfo, bool) {
+	p.cache.lock.RLock()
+	info, ok := p.cache.infos[cat]
+	p.cache.lock.RUnlock()
+	if !ok {
+		if d, isDescriber := p.fsCategory(cat, "").(transpile.Describer); isDescriber {
+			info = d.Describe()
+		}
+		p.cache.lock.Lock()
+		p.cache.infos[cat] = info
+		p.cache.lock.Unlock()
+	}
+	return info, !info.IsZero()
+}
+
+// Titles returns the titles of puzzles in cat.
+//
+// Every puzzle has to be transpiled to find its title.
+// Puzzles which fail are left out, until their category changes.
+// Per-team puzzles might have different titles for each team, so they have none.
+func (p TranspilerProvider) Titles(cat string) map[string]string {
+	if p.perTeam {
+		return nil
+	}
+	p.cache.lock.RLock()
+	titles, ok := p.cache.titles[cat]
+	p.cache.lock.RUnlock()
+	if ok {
+		return titles
+	}
+
+	titles = make(map[string]string)
+	for _, category := range p.Inventory() {
+		if category.Name != cat {
+			continue
+		}
+		ids := make([]string, 0, len(category.Puzzles)+len(category.Slugs))
+		for _, points := range category.Puzzles {
+			ids = append(ids, strconv.Itoa(points))
+		}
+		for slug := range category.Slugs {
+			ids = append(ids, slug)
+		}
+		for _, id := range ids {
+			puzzle, err := p.puzzle("", cat, id)
+			if err != nil {
+				continue
+			}
+			if puzzle.Title != "" {
+				titles[id] = puzzle.Title
+			}
+		}
+	}
+	p.cache.lock.Lock()
+	p.cache.titles[cat] = titles
+	p.cache.lock.Unlock()
+	return titles
+}
+
 type nopCloser struct {
 	io.ReadSeeker
 }
@@ -44,42 +271,254 @@ func (c nopCloser) Close() error {
 }
 
 // Open returns a file associated with the given category and point value.
-func (p TranspilerProvider) Open(cat string, points int, filename string) (ReadSeekCloser, time.Time, error) {
-	c := transpile.NewFsCategory(p.fs, cat)
+func (p TranspilerProvider) Open(teamID string, cat string, points int, filename string) (ReadSeekCloser, time.Time, error) {
+	return p.open(teamID, cat, strconv.Itoa(points), filename)
+}
+
+// OpenSlug returns a file associated with the puzzle named slug.
+func (p TranspilerProvider) OpenSlug(teamID string, cat string, slug string, filename string) (ReadSeekCloser, time.Time, error) {
+	return p.open(teamID, cat, slug, filename)
+}
+
+func (p TranspilerProvider) open(teamID string, cat string, id string, filename string) (ReadSeekCloser, time.Time, error) {
 	switch filename {
 	case "", "puzzle.json":
-		p, err := c.Puzzle(points)
+		puzzle, err := p.exportPuzzle(teamID, cat, id)
 		if err != nil {
-			return nopCloser{new(bytes.Reader)}, time.Time{}, err
+			return nopCloser{new(bytes.Reader)}, time.Time{}, fmt.Errorf("%s/%s: %v", cat, id, err)
 		}
-		jp, err := json.Marshal(p)
+		jp, err := json.Marshal(puzzle)
 		if err != nil {
 			return nopCloser{new(bytes.Reader)}, time.Time{}, err
 		}
 		return nopCloser{bytes.NewReader(jp)}, time.Now(), nil
 	default:
-		r, err := c.Open(points, filename)
+		if p.perTeam {
+			return p.openCached(teamID, cat, id, filename)
+		}
+		r, err := transpile.CategoryOpen(p.category(teamID, cat), id, filename)
 		return r, time.Now(), err
 	}
 }
 
+// openCached opens teamID's copy of an attachment.
+//
+// Generating an attachment can be expensive,
+// so each team's copy is spooled to a temporary file the first time it's opened,
+// and kept until the category changes or the provider is closed.
+func (p TranspilerProvider) openCached(teamID string, cat string, id string, filename string) (ReadSeekCloser, time.Time, error) {
+	key := TeamSeed(p.seed, teamID) + "/" + id + "/" + filename
+
+	p.cache.lock.RLock()
+	cf, ok := p.cache.files[cat][key]
+	p.cache.lock.RUnlock()
+	if ok {
+		if f, err := os.Open(cf.path); err == nil {
+			return f, cf.mtime, nil
+		}
+	}
+
+	r, err := transpile.CategoryOpen(p.category(teamID, cat), id, filename)
+	if err != nil {
+		return r, time.Time{}, err
+	}
+	defer r.Close()
+	p.cache.lock.Lock()
+	f, err := p.cache.createFile()
+	p.cache.lock.Unlock()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	cf = cachedFile{path: f.Name(), mtime: time.Now()}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(cf.path)
+		return nil, time.Time{}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(cf.path)
+		return nil, time.Time{}, err
+	}
+
+	p.cache.lock.Lock()
+	if p.cache.files[cat] == nil {
+		p.cache.files[cat] = make(map[string]cachedFile)
+	}
+	if _, ok := p.cache.files[cat][key]; ok {
+		// Somebody else got there first: keep theirs
+		os.Remove(cf.path)
+	} else {
+		p.cache.files[cat][key] = cf
+	}
+	p.cache.lock.Unlock()
+	return f, cf.mtime, nil
+}
+
+// exportPuzzle returns the puzzle to send to teamID.
+func (p TranspilerProvider) exportPuzzle(teamID string, cat string, id string) (transpile.Puzzle, error) {
+	puzzle, err := p.puzzle(teamID, cat, id)
+	if err != nil {
+		return puzzle, err
+	}
+	if p.perTeam {
+		puzzle.RemoveSecrets()
+	}
+	return puzzle, nil
+}
+
 // CheckAnswer checks whether an answer si correct.
-func (p TranspilerProvider) CheckAnswer(cat string, points int, answer string) (bool, error) {
-	c := transpile.NewFsCategory(p.fs, cat)
-	return c.Answer(points, answer), nil
+func (p TranspilerProvider) CheckAnswer(teamID string, cat string, points int, answer string) (bool, error) {
+	return p.checkAnswer(teamID, cat, strconv.Itoa(points), answer)
+}
+
+// CheckSlugAnswer checks whether an answer is correct for the puzzle named slug.
+func (p TranspilerProvider) CheckSlugAnswer(teamID string, cat string, slug string, answer string) (bool, error) {
+	return p.checkAnswer(teamID, cat, slug, answer)
+}
+
+func (p TranspilerProvider) checkAnswer(teamID string, cat string, id string, answer string) (bool, error) {
+	puzzle, err := p.puzzle(teamID, cat, id)
+	if err != nil {
+		if p.perTeam {
+			return false, err
+		}
+		return transpile.CategoryAnswer(p.category(teamID, cat), id, answer), nil
+	}
+	for _, a := range puzzle.Answers {
+		if a == answer {
+			return true, nil
+		}
+	}
+	if p.perTeam && (len(puzzle.Answers) > 0) {
+		return false, nil
+	}
+	// Some generators only check answers when asked,
+	// or accept more than they list
+	return transpile.CategoryAnswer(p.category(teamID, cat), id, answer), nil
 }
 
 // Mothball packages up a category into a mothball.
 func (p TranspilerProvider) Mothball(cat string, w io.Writer) error {
-	c := transpile.NewFsCategory(p.fs, cat)
-	return transpile.Mothball(c, w)
+	if p.perTeam {
+		return fmt.Errorf("refusing to package per-team puzzles into a single mothball")
+	}
+	return transpile.Mothball(p.fsCategory(cat, ""), w)
 }
 
 // Maintain performs housekeeping.
 func (p TranspilerProvider) Maintain(updateInterval time.Duration) {
-	// Nothing to do here.
+	p.refresh()
+	for range time.NewTicker(updateInterval).C {
+		p.refresh()
+	}
 }
 
+// refresh throws out cached puzzles from any category whose files have changed.
+// If anything changed, the inventory is read again.
 func (p TranspilerProvider) refresh() {
-	// Nothing to do for a theme
+	stamps := make(map[string]string)
+	dirEnts, err := afero.ReadDir(p.fs, "")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	for _, ent := range dirEnts {
+		if ent.IsDir() && !strings.HasPrefix(ent.Name(), ".") {
+			stamps[ent.Name()] = p.stamp(ent.Name())
+		}
+	}
+
+	p.cache.lock.Lock()
+	defer p.cache.lock.Unlock()
+	for cat := range p.cache.puzzles {
+		if old, ok := p.cache.stamps[cat]; !ok || (stamps[cat] != old) {
+			delete(p.cache.puzzles, cat)
+		}
+	}
+	for cat := range p.cache.infos {
+		if old, ok := p.cache.stamps[cat]; !ok || (stamps[cat] != old) {
+			delete(p.cache.infos, cat)
+		}
+	}
+	for cat := range p.cache.titles {
+		if old, ok := p.cache.stamps[cat]; !ok || (stamps[cat] != old) {
+			delete(p.cache.titles, cat)
+		}
+	}
+	for cat, files := range p.cache.files {
+		if old, ok := p.cache.stamps[cat]; !ok || (stamps[cat] != old) {
+			for _, cf := range files {
+				os.Remove(cf.path)
+			}
+			delete(p.cache.files, cat)
+		}
+	}
+	var changed []string
+	for cat, stamp := range stamps {
+		if p.cache.stamps[cat] != stamp {
+			changed = append(changed, cat)
+		}
+	}
+	for cat := range p.cache.stamps {
+		if _, ok := stamps[cat]; !ok {
+			changed = append(changed, cat)
+		}
+	}
+	p.cache.stamps = stamps
+	if len(changed) > 0 {
+		p.cache.inventory = nil
+	}
+
+	for _, cat := range changed {
+		for ch := range p.cache.subscribers {
+			select {
+			case ch <- cat:
+			default:
+				// Subscriber isn't keeping up
+			}
+		}
+	}
+}
+
+// Close removes every cached attachment.
+func (p TranspilerProvider) Close() error {
+	p.cache.lock.Lock()
+	defer p.cache.lock.Unlock()
+	p.cache.files = make(map[string]map[string]cachedFile)
+	if p.cache.tempDir == "" {
+		return nil
+	}
+	err := os.RemoveAll(p.cache.tempDir)
+	p.cache.tempDir = ""
+	return err
+}
+
+// Subscribe returns a channel which receives the names of categories
+// whose files have changed,
+// and a function to call when no longer interested.
+func (p TranspilerProvider) Subscribe() (<-chan string, func()) {
+	ch := make(chan string, 16)
+	p.cache.lock.Lock()
+	p.cache.subscribers[ch] = struct{}{}
+	p.cache.lock.Unlock()
+	return ch, func() {
+		p.cache.lock.Lock()
+		delete(p.cache.subscribers, ch)
+		p.cache.lock.Unlock()
+	}
+}
+
+// stamp summarizes the names, sizes, and modification times of every file in cat.
+func (p TranspilerProvider) stamp(cat string) string {
+	h := sha256.New()
+	afero.Walk(p.fs, cat, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			fmt.Fprintf(h, "%s\x00%v\x00", path, err)
+			return nil
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil))
 }
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/spf13/afero"
@@ -17,3 +21,143 @@ func TestTranspiler(t *testing.T) {
 		t.Error("Wrong puzzles:", inv)
 	}
 }
+
+func TestTranspilerCache(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "cat/1/puzzle.md", []byte("---\nanswers: [first]\n---\nOriginal body\n"), 0644)
+	p := NewTranspilerProvider(fs)
+	p.refresh()
+
+	body := func() string {
+		f, _, err := p.Open("", "cat", 1, "puzzle.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, _ := ioutil.ReadAll(f)
+		return string(buf)
+	}
+
+	if !strings.Contains(body(), "Original") {
+		t.Error("Wrong puzzle body")
+	}
+	if inv := p.Inventory(); len(inv[0].Puzzles) != 1 {
+		t.Error("Wrong inventory:", inv)
+	}
+
+	afero.WriteFile(fs, "cat/1/puzzle.md", []byte("---\nanswers: [second]\n---\nUpdated body\n"), 0644)
+	afero.WriteFile(fs, "cat/2/puzzle.md", []byte("---\nanswers: [two]\n---\nNew puzzle\n"), 0644)
+	if !strings.Contains(body(), "Original") {
+		t.Error("Puzzle wasn't cached")
+	}
+
+	p.refresh()
+	if !strings.Contains(body(), "Updated") {
+		t.Error("Cache wasn't invalidated by changed file")
+	}
+	if ok, _ := p.CheckAnswer("", "cat", 1, "second"); !ok {
+		t.Error("Updated answer not accepted")
+	}
+	if ok, _ := p.CheckAnswer("", "cat", 1, "first"); ok {
+		t.Error("Stale answer accepted")
+	}
+	if inv := p.Inventory(); len(inv[0].Puzzles) != 2 {
+		t.Error("Inventory not updated:", inv)
+	}
+}
+
+func TestTeamTranspiler(t *testing.T) {
+	fs := afero.NewBasePathFs(afero.NewOsFs(), "testdata")
+	p := NewTeamTranspilerProvider(fs, "s33d")
+
+	if TeamSeed("s33d", "alpha") == TeamSeed("s33d", "beta") {
+		t.Error("Different teams got the same seed")
+	}
+	if TeamSeed("s33d", "alpha") == TeamSeed("other", "alpha") {
+		t.Error("Different server seeds gave the same team seed")
+	}
+
+	alphaAnswer := "answer-" + TeamSeed("s33d", "alpha")
+	betaAnswer := "answer-" + TeamSeed("s33d", "beta")
+
+	if ok, err := p.CheckAnswer("alpha", "cat0", 2, alphaAnswer); err != nil {
+		t.Error(err)
+	} else if !ok {
+		t.Error("Team's own answer rejected")
+	}
+	if ok, err := p.CheckAnswer("alpha", "cat0", 2, betaAnswer); err != nil {
+		t.Error(err)
+	} else if ok {
+		t.Error("Another team's answer was accepted")
+	}
+	if ok, _ := p.CheckAnswer("beta", "cat0", 2, betaAnswer); !ok {
+		t.Error("Second team's own answer rejected")
+	}
+
+	if f, _, err := p.Open("alpha", "cat0", 2, "puzzle.json"); err != nil {
+		t.Error(err)
+	} else if buf, err := ioutil.ReadAll(f); err != nil {
+		t.Error(err)
+	} else if strings.Contains(string(buf), alphaAnswer) {
+		t.Error("Answer leaked in puzzle.json:", string(buf))
+	}
+
+	for i := 0; i < 2; i++ {
+		if f, _, err := p.Open("alpha", "cat0", 2, "moo.txt"); err != nil {
+			t.Error(err)
+		} else if buf, err := ioutil.ReadAll(f); err != nil {
+			t.Error(err)
+		} else if string(buf) != "Moo.\n" {
+			t.Error("Wrong attachment:", string(buf))
+		} else {
+			f.Close()
+		}
+	}
+	if f, _, err := p.Open("beta", "cat0", 2, "moo.txt"); err != nil {
+		t.Error(err)
+	} else {
+		f.Close()
+	}
+	if files := p.cache.files["cat0"]; len(files) != 2 {
+		t.Error("Each team's attachment should be cached once:", files)
+	}
+	tempDir := p.cache.tempDir
+	for _, cf := range p.cache.files["cat0"] {
+		if filepath.Dir(cf.path) != tempDir {
+			t.Error("Attachment cached outside the provider's directory:", cf.path)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(tempDir); !os.IsNotExist(err) {
+		t.Error("Close left cached attachments behind:", err)
+	}
+
+	if err := p.Mothball("cat0", ioutil.Discard); err == nil {
+		t.Error("Per-team puzzles shouldn't be mothballed")
+	}
+}
+
+func TestTranspilerDescribe(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "cat/category.yaml", []byte("title: The Cat\nweight: 5\ntags: [felines]\n"), 0644)
+	afero.WriteFile(fs, "cat/1/puzzle.md", []byte("---\nanswers: [a]\n---\nBody\n"), 0644)
+	afero.WriteFile(fs, "dog/1/puzzle.md", []byte("---\nanswers: [a]\n---\nBody\n"), 0644)
+	p := NewTranspilerProvider(fs)
+	p.refresh()
+
+	if info, ok := p.Describe("cat"); !ok {
+		t.Error("No description")
+	} else if (info.Title != "The Cat") || (info.Weight != 5) || (len(info.Tags) != 1) {
+		t.Error("Wrong description:", info)
+	}
+	if info, ok := p.Describe("dog"); ok {
+		t.Error("Description for a category without one:", info)
+	}
+
+	afero.WriteFile(fs, "cat/category.yaml", []byte("title: The Kitty\n"), 0644)
+	p.refresh()
+	if info, _ := p.Describe("cat"); info.Title != "The Kitty" {
+		t.Error("Cache wasn't invalidated by changed description:", info)
+	}
+}
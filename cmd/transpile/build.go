@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dirtbags/moth/v4/pkg/transpile"
+	"github.com/spf13/afero"
+)
+
+// buildResult is what happened to one category during a build.
+type buildResult struct {
+	cat string
+	err error
+
+	// unchanged is true if the category's source hadn't changed since the last build
+	unchanged bool
+}
+
+// Build writes a mothball for every category in the tree to the output directory.
+//
+// Categories are built in parallel.
+// A category whose source hasn't changed since its last build is skipped,
+// unless -force is given.
+// Each mothball is written to a temporary file and renamed into place,
+// so a running server never sees a half-written mothball.
+func (t *T) Build() error {
+	if t.outDir == "" {
+		return fmt.Errorf("no output directory: use -out")
+	}
+	if err := t.BaseFs.MkdirAll(t.outDir, 0755); err != nil {
+		return err
+	}
+
+	dirEnts, err := afero.ReadDir(t.fs, "")
+	if err != nil {
+		return err
+	}
+	var cats []string
+	for _, ent := range dirEnts {
+		if ent.IsDir() && !strings.HasPrefix(ent.Name(), ".") {
+			cats = append(cats, ent.Name())
+		}
+	}
+
+	jobs := t.jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	results := make([]buildResult, len(cats))
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, cat := range cats {
+		wg.Add(1)
+		go func(i int, cat string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = t.buildCategory(cat)
+		}(i, cat)
+	}
+	wg.Wait()
+
+	built, unchanged, failed := 0, 0, 0
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			failed++
+			fmt.Fprintf(t.Stdout, "%s: FAILED: %v\n", r.cat, r.err)
+		case r.unchanged:
+			unchanged++
+			fmt.Fprintf(t.Stdout, "%s: unchanged\n", r.cat)
+		default:
+			built++
+			fmt.Fprintf(t.Stdout, "%s: built\n", r.cat)
+		}
+	}
+	fmt.Fprintf(t.Stdout, "%d built, %d unchanged, %d failed\n", built, unchanged, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d categories failed to build", failed)
+	}
+	return nil
+}
+
+// buildCategory builds one category's mothball, if its source has changed.
+func (t *T) buildCategory(cat string) buildResult {
+	result := buildResult{cat: cat}
+	mbPath := path.Join(t.outDir, cat+".mb")
+	hashPath := path.Join(t.outDir, "."+cat+".mb.source")
+
+	hash, err := t.sourceHash(cat)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	if !t.force {
+		if _, err := t.BaseFs.Stat(mbPath); err == nil {
+			if old, err := afero.ReadFile(t.BaseFs, hashPath); (err == nil) && (string(old) == hash) {
+				result.unchanged = true
+				return result
+			}
+		}
+	}
+
+	opts, err := t.mothballOptions(cat)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	c := transpile.NewFsCategory(t.fs, cat)
+	err = t.writeAtomic(mbPath, func(w io.Writer) error {
+		return transpile.MothballWithOptions(c, w, opts)
+	})
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.err = t.writeAtomic(hashPath, func(w io.Writer) error {
+		_, err := io.WriteString(w, hash)
+		return err
+	})
+	return result
+}
+
+// writeAtomic calls write with a temporary file in the same directory as filename,
+// then renames it to filename.
+//
+// Mothballs are written straight to the file,
+// so building several at once doesn't hold them all in memory.
+func (t *T) writeAtomic(filename string, write func(w io.Writer) error) error {
+	f, err := afero.TempFile(t.BaseFs, path.Dir(filename), "."+path.Base(filename)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+	if err := write(f); err != nil {
+		f.Close()
+		t.BaseFs.Remove(tmpName)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		t.BaseFs.Remove(tmpName)
+		return err
+	}
+	if err := t.BaseFs.Chmod(tmpName, 0644); err != nil {
+		t.BaseFs.Remove(tmpName)
+		return err
+	}
+	if err := t.BaseFs.Rename(tmpName, filename); err != nil {
+		t.BaseFs.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// sourceHash returns a hash of everything that goes into building cat:
+// every file's name, mode, and contents,
+// plus the options for building variants and SOURCE_DATE_EPOCH.
+//
+// The source commit isn't included,
+// so a new commit doesn't rebuild categories it didn't touch.
+func (t *T) sourceHash(cat string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "variants=%d\x00seed=%s\x00", t.variants, t.seed)
+	fmt.Fprintf(h, "epoch=%s\x00", os.Getenv("SOURCE_DATE_EPOCH"))
+	if t.teamsFile != "" {
+		teams, err := afero.ReadFile(t.BaseFs, t.teamsFile)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "teams=%d\x00", len(teams))
+		h.Write(teams)
+	}
+
+	var paths []string
+	infos := make(map[string]os.FileInfo)
+	err := afero.Walk(t.fs, cat, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, filename)
+		infos[filename] = info
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(paths)
+
+	for _, filename := range paths {
+		info := infos[filename]
+		fmt.Fprintf(h, "\x00%s\x00%o\x00", filename, info.Mode())
+		if info.IsDir() {
+			continue
+		}
+		f, err := t.fs.Open(filename)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestBuild(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "puzzles/animals/1/puzzle.md", testMothYaml, 0644)
+	afero.WriteFile(fs, "puzzles/animals/1/moo.txt", []byte("Moo."), 0644)
+	afero.WriteFile(fs, "puzzles/plants/1/puzzle.md", []byte("---\nanswers: [fern]\n---\nA plant.\n"), 0644)
+	afero.WriteFile(fs, "puzzles/.git/HEAD", []byte("ref: refs/heads/main\n"), 0644)
+	tp := T{
+		Stdout: stdout,
+		Stderr: new(bytes.Buffer),
+		BaseFs: fs,
+	}
+
+	if err := tp.Run("build", "-dir=puzzles"); err == nil {
+		t.Error("Build without -out should fail")
+	}
+
+	if err := tp.Run("build", "-dir=puzzles", "-out=mothballs"); err != nil {
+		t.Fatal(err, stdout.String())
+	}
+	for _, name := range []string{"mothballs/animals.mb", "mothballs/plants.mb"} {
+		if _, err := fs.Stat(name); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := fs.Stat("mothballs/.git.mb"); err == nil {
+		t.Error("Hidden directory built into a mothball")
+	}
+	if !strings.Contains(stdout.String(), "2 built, 0 unchanged, 0 failed") {
+		t.Error("Wrong summary:", stdout.String())
+	}
+
+	afero.WriteFile(fs, "puzzles/plants/1/puzzle.md", []byte("---\nanswers: [moss]\n---\nA plant.\n"), 0644)
+	stdout.Reset()
+	if err := tp.Run("build", "-dir=puzzles", "-out=mothballs"); err != nil {
+		t.Fatal(err, stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "animals: unchanged") || !strings.Contains(stdout.String(), "plants: built") {
+		t.Error("Wrong categories rebuilt:", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := tp.Run("build", "-dir=puzzles", "-out=mothballs", "-force"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "2 built, 0 unchanged") {
+		t.Error("-force didn't rebuild everything:", stdout.String())
+	}
+
+	before, _ := afero.ReadFile(fs, "mothballs/plants.mb")
+	afero.WriteFile(fs, "puzzles/plants/1/puzzle.md", []byte("---\nbogus: field\n---\n"), 0644)
+	stdout.Reset()
+	if err := tp.Run("build", "-dir=puzzles", "-out=mothballs"); err == nil {
+		t.Error("Broken category didn't fail the build")
+	}
+	if !strings.Contains(stdout.String(), "plants: FAILED") {
+		t.Error("Failure not reported:", stdout.String())
+	}
+	if after, _ := afero.ReadFile(fs, "mothballs/plants.mb"); !bytes.Equal(before, after) {
+		t.Error("Failed build replaced the previous mothball")
+	}
+
+	ents, _ := afero.ReadDir(fs, "mothballs")
+	for _, ent := range ents {
+		if strings.Contains(ent.Name(), ".tmp-") {
+			t.Error("Temporary file left behind:", ent.Name())
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dirtbags/moth/v4/pkg/transpile"
+)
+
+// mothballSummary describes what's in a mothball.
+type mothballSummary struct {
+	Version  string `json:",omitempty"`
+	Source   string `json:",omitempty"`
+	Metadata transpile.MothballMetadata
+	Variants int `json:",omitempty"`
+	Puzzles  []puzzleSummary
+
+	// Tokens maps the nonces of tokens in a token mothball to their point values
+	Tokens map[string]int `json:",omitempty"`
+}
+
+// puzzleSummary describes one puzzle in a mothball.
+type puzzleSummary struct {
+	Slug      string `json:",omitempty"`
+	Points    int
+	Title     string `json:",omitempty"`
+	Answers   int
+	Authors   []string
+	Objective string         `json:",omitempty"`
+	KSAs      []string       `json:",omitempty"`
+	Extra     map[string]any `json:",omitempty"`
+	Files     []fileSummary
+
+	// Variants lists the variants with their own copy of this puzzle
+	Variants []int `json:",omitempty"`
+}
+
+// fileSummary describes one file belonging to a puzzle.
+type fileSummary struct {
+	Name string
+	Size int64
+}
+
+// openMothball opens a mothball file for reading.
+func (t *T) openMothball(filename string) (*transpile.MothballReader, func() error, error) {
+	f, err := t.BaseFs.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	mr, err := transpile.NewMothballReader(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return mr, f.Close, nil
+}
+
+// Inspect describes the puzzles in a mothball.
+func (t *T) Inspect() error {
+	if len(t.Args) != 1 {
+		return fmt.Errorf("usage: transpile inspect FILE.mb")
+	}
+	mr, closer, err := t.openMothball(t.Args[0])
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	summary := mothballSummary{
+		Version:  mr.Version(),
+		Source:   mr.Manifest().Source,
+		Metadata: mr.Metadata(),
+		Variants: mr.Variants(),
+		Puzzles:  []puzzleSummary{},
+		Tokens:   mr.Tokens(),
+	}
+	list, err := mr.PuzzleList()
+	if err != nil {
+		return err
+	}
+	for _, entry := range list {
+		id := entry.ID()
+		puzzle, err := mr.Puzzle(-1, id)
+		if err != nil {
+			return err
+		}
+		answers, err := mr.Answers(-1, id)
+		if err != nil {
+			return err
+		}
+		ps := puzzleSummary{
+			Slug:      entry.Slug,
+			Points:    entry.Points,
+			Title:     puzzle.Title,
+			Answers:   len(answers),
+			Authors:   puzzle.Authors,
+			Objective: puzzle.Objective,
+			KSAs:      puzzle.KSAs,
+			Extra:     puzzle.Extra,
+			Variants:  mr.PuzzleVariants(id),
+		}
+		for _, name := range append([]string{"puzzle.json"}, append(puzzle.Attachments, puzzle.Scripts...)...) {
+			fs := fileSummary{Name: name, Size: -1}
+			if fi, err := mr.Stat(id + "/" + name); err == nil {
+				fs.Size = fi.Size()
+			}
+			ps.Files = append(ps.Files, fs)
+		}
+		summary.Puzzles = append(summary.Puzzles, ps)
+	}
+
+	if t.jsonOutput {
+		return json.NewEncoder(t.Stdout).Encode(summary)
+	}
+
+	if summary.Version != "" {
+		fmt.Fprintln(t.Stdout, "version:", summary.Version)
+	}
+	if summary.Source != "" {
+		fmt.Fprintln(t.Stdout, "source:", summary.Source)
+	}
+	fmt.Fprintln(t.Stdout, "format:", summary.Metadata.Format)
+	if summary.Metadata.Built != nil {
+		fmt.Fprintln(t.Stdout, "built:", summary.Metadata.Built.Format(time.RFC3339))
+	}
+	if summary.Metadata.Transpiler != "" {
+		fmt.Fprintln(t.Stdout, "transpiler:", summary.Metadata.Transpiler)
+	}
+	info := summary.Metadata.Category
+	if info.Title != "" {
+		fmt.Fprintln(t.Stdout, "title:", info.Title)
+	}
+	if info.Description != "" {
+		fmt.Fprintln(t.Stdout, "description:", info.Description)
+	}
+	if len(info.Authors) > 0 {
+		fmt.Fprintln(t.Stdout, "authors:", strings.Join(info.Authors, ", "))
+	}
+	if info.Unlock != "" {
+		fmt.Fprintln(t.Stdout, "unlock:", info.Unlock)
+	}
+	if info.Weight != 0 {
+		fmt.Fprintln(t.Stdout, "weight:", info.Weight)
+	}
+	if info.Icon != "" {
+		fmt.Fprintln(t.Stdout, "icon:", info.Icon)
+	}
+	if len(info.Tags) > 0 {
+		fmt.Fprintln(t.Stdout, "tags:", strings.Join(info.Tags, ", "))
+	}
+	if summary.Variants > 0 {
+		fmt.Fprintln(t.Stdout, "variants:", summary.Variants)
+	}
+	for _, ps := range summary.Puzzles {
+		if ps.Slug != "" {
+			fmt.Fprintf(t.Stdout, "%s: %d points, ", ps.Slug, ps.Points)
+		} else {
+			fmt.Fprintf(t.Stdout, "%d: ", ps.Points)
+		}
+		if ps.Title != "" {
+			fmt.Fprintf(t.Stdout, "%s, ", ps.Title)
+		}
+		fmt.Fprintf(t.Stdout, "%d answers", ps.Answers)
+		if len(ps.Authors) > 0 {
+			fmt.Fprintf(t.Stdout, ", by %s", strings.Join(ps.Authors, ", "))
+		}
+		fmt.Fprintln(t.Stdout)
+		if ps.Objective != "" {
+			fmt.Fprintln(t.Stdout, "    objective:", ps.Objective)
+		}
+		if len(ps.KSAs) > 0 {
+			fmt.Fprintln(t.Stdout, "    KSAs:", strings.Join(ps.KSAs, ", "))
+		}
+		keys := make([]string, 0, len(ps.Extra))
+		for key := range ps.Extra {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(t.Stdout, "    %s: %v\n", key, ps.Extra[key])
+		}
+		if len(ps.Variants) > 0 {
+			fmt.Fprintln(t.Stdout, "    variants:", strings.Trim(fmt.Sprint(ps.Variants), "[]"))
+		}
+		for _, fs := range ps.Files {
+			if fs.Size < 0 {
+				fmt.Fprintf(t.Stdout, "    %s (missing)\n", fs.Name)
+			} else {
+				fmt.Fprintf(t.Stdout, "    %s (%d bytes)\n", fs.Name, fs.Size)
+			}
+		}
+	}
+	if summary.Metadata.Tokens {
+		nonces := make([]string, 0, len(summary.Tokens))
+		for nonce := range summary.Tokens {
+			nonces = append(nonces, nonce)
+		}
+		sort.Strings(nonces)
+		fmt.Fprintln(t.Stdout, "tokens:", len(nonces))
+		for _, nonce := range nonces {
+			fmt.Fprintf(t.Stdout, "%s: %d points\n", nonce, summary.Tokens[nonce])
+		}
+	}
+	return nil
+}
+
+// Diff describes what changed between two mothballs.
+func (t *T) Diff() error {
+	if len(t.Args) != 2 {
+		return fmt.Errorf("usage: transpile diff OLD.mb NEW.mb")
+	}
+	before, closeBefore, err := t.openMothball(t.Args[0])
+	if err != nil {
+		return err
+	}
+	defer closeBefore()
+	after, closeAfter, err := t.openMothball(t.Args[1])
+	if err != nil {
+		return err
+	}
+	defer closeAfter()
+
+	changes, err := transpile.DiffMothballs(before, after)
+	if err != nil {
+		return err
+	}
+
+	if t.jsonOutput {
+		if changes == nil {
+			changes = []transpile.Change{}
+		}
+		if err := json.NewEncoder(t.Stdout).Encode(changes); err != nil {
+			return err
+		}
+	} else {
+		for _, change := range changes {
+			fmt.Fprintln(t.Stdout, change)
+		}
+	}
+
+	// Like diff(1), differences are a failure, so scripts can tell
+	if len(changes) > 0 {
+		return fmt.Errorf("%d changes found", len(changes))
+	}
+	return nil
+}
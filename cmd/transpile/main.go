@@ -1,13 +1,20 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/exec"
+	"runtime"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dirtbags/moth/v4/pkg/transpile"
 
@@ -22,6 +29,23 @@ type T struct {
 	Args   []string
 	BaseFs afero.Fs
 	fs     afero.Fs
+
+	teamsFile string
+	variants  int
+	seed      string
+	source    string
+	dir       string
+
+	tree       bool
+	jsonOutput bool
+
+	outDir string
+	jobs   int
+	force  bool
+
+	tokensFile string
+	tokenSize  int
+	htmlOutput bool
 }
 
 // Command is a function invoked by the user
@@ -34,6 +58,8 @@ func nothing() error {
 func usage(w io.Writer) {
 	fmt.Fprintln(w, " Usage: transpile mothball [FLAGS] [MOTHBALL]")
 	fmt.Fprintln(w, "        Compile a mothball")
+	fmt.Fprintln(w, " Usage: transpile build [FLAGS] -out DIRECTORY")
+	fmt.Fprintln(w, "        Compile a mothball for every category in a tree")
 	fmt.Fprintln(w, " Usage: inventory [FLAGS]")
 	fmt.Fprintln(w, "        Show category inventory")
 	fmt.Fprintln(w, " Usage: puzzle [FLAGS]")
@@ -42,11 +68,46 @@ func usage(w io.Writer) {
 	fmt.Fprintln(w, "        Open a file for a puzzle")
 	fmt.Fprintln(w, " Usage: answer [FLAGS] ANSWER")
 	fmt.Fprintln(w, "        Check correctness of an answer")
+	fmt.Fprintln(w, " Usage: lint [FLAGS]")
+	fmt.Fprintln(w, "        Report every problem with a category's puzzles")
+	fmt.Fprintln(w, " Usage: verify [FLAGS]")
+	fmt.Fprintln(w, "        Build a mothball in memory and check every answer against it")
+	fmt.Fprintln(w, " Usage: inspect [FLAGS] MOTHBALL")
+	fmt.Fprintln(w, "        List the puzzles and files in a mothball")
+	fmt.Fprintln(w, " Usage: diff [FLAGS] OLD NEW")
+	fmt.Fprintln(w, "        Show what changed between two mothballs")
+	fmt.Fprintln(w, " Usage: tokens [FLAGS] CATEGORY POINTS[xCOUNT]...")
+	fmt.Fprintln(w, "        Generate tokens, like 5x10 for ten 5-point tokens")
 	fmt.Fprintln(w, " Usage: markdown [FLAGS]")
 	fmt.Fprintln(w, "        Format stdin with markdown")
 	fmt.Fprintln(w, "")
 	fmt.Fprintln(w, "-dir DIRECTORY")
 	fmt.Fprintln(w, "        Use puzzle in DIRECTORY")
+	fmt.Fprintln(w, "-teams FILE")
+	fmt.Fprintln(w, "        mothball, verify: build a variant of generated puzzles for each team ID in FILE")
+	fmt.Fprintln(w, "-variants N")
+	fmt.Fprintln(w, "        mothball, verify: build N variants of generated puzzles, assigned to teams by hash")
+	fmt.Fprintln(w, "-seed SEED")
+	fmt.Fprintln(w, "        mothball, verify: prefix for variant seeds (default: random, and printed)")
+	fmt.Fprintln(w, "-source COMMIT")
+	fmt.Fprintln(w, "        mothball, build: source commit to record in the manifest (default: git HEAD)")
+	fmt.Fprintln(w, "-out DIRECTORY")
+	fmt.Fprintln(w, "        build: write mothballs to DIRECTORY")
+	fmt.Fprintln(w, "-jobs N")
+	fmt.Fprintln(w, "        build: build N categories at once")
+	fmt.Fprintln(w, "-force")
+	fmt.Fprintln(w, "        build: rebuild categories even if they haven't changed")
+	fmt.Fprintln(w, "-tree")
+	fmt.Fprintln(w, "        lint: DIRECTORY is a tree of categories")
+	fmt.Fprintln(w, "-json")
+	fmt.Fprintln(w, "        lint, verify, inspect, diff: write output as JSON")
+	fmt.Fprintln(w, "-tokens FILE")
+	fmt.Fprintln(w, "        mothball: build a token mothball from the tokens in FILE")
+	fmt.Fprintln(w, "        tokens: print the tokens in FILE instead of generating new ones")
+	fmt.Fprintln(w, "-size N")
+	fmt.Fprintln(w, "        tokens: use N random bytes for each token (default 16; don't go below 8)")
+	fmt.Fprintln(w, "-html")
+	fmt.Fprintln(w, "        tokens: write a printable HTML page")
 }
 
 // ParseArgs parses arguments and runs the appropriate action.
@@ -61,10 +122,24 @@ func (t *T) ParseArgs() (Command, error) {
 	flags := flag.NewFlagSet(t.Args[1], flag.ContinueOnError)
 	flags.SetOutput(t.Stderr)
 	directory := flags.String("dir", "", "Work directory")
+	flags.StringVar(&t.teamsFile, "teams", "", "File of team IDs to build variants for")
+	flags.IntVar(&t.variants, "variants", 0, "Number of variants to build")
+	flags.StringVar(&t.seed, "seed", "", "Prefix for variant seeds")
+	flags.StringVar(&t.source, "source", "", "Source commit to record in mothball manifests (default: git HEAD of the work directory)")
+	flags.StringVar(&t.outDir, "out", "", "Output directory")
+	flags.IntVar(&t.jobs, "jobs", runtime.NumCPU(), "Number of categories to build at once")
+	flags.BoolVar(&t.force, "force", false, "Rebuild unchanged categories")
+	flags.BoolVar(&t.tree, "tree", false, "Directory is a tree of categories")
+	flags.BoolVar(&t.jsonOutput, "json", false, "Write output as JSON")
+	flags.StringVar(&t.tokensFile, "tokens", "", "File of tokens")
+	flags.IntVar(&t.tokenSize, "size", transpile.DefaultTokenSize, "Random bytes in each token")
+	flags.BoolVar(&t.htmlOutput, "html", false, "Write tokens as a printable HTML page")
 
 	switch t.Args[1] {
 	case "mothball":
 		cmd = t.DumpMothball
+	case "build":
+		cmd = t.Build
 	case "inventory":
 		cmd = t.PrintInventory
 	case "puzzle":
@@ -73,6 +148,16 @@ func (t *T) ParseArgs() (Command, error) {
 		cmd = t.DumpFile
 	case "answer":
 		cmd = t.CheckAnswer
+	case "lint":
+		cmd = t.Lint
+	case "verify":
+		cmd = t.Verify
+	case "inspect":
+		cmd = t.Inspect
+	case "diff":
+		cmd = t.Diff
+	case "tokens":
+		cmd = t.Tokens
 	case "markdown":
 		cmd = t.Markdown
 	case "help":
@@ -87,6 +172,7 @@ func (t *T) ParseArgs() (Command, error) {
 	if err := flags.Parse(t.Args[2:]); err != nil {
 		return nothing, err
 	}
+	t.dir = *directory
 	if *directory != "" {
 		t.fs = afero.NewBasePathFs(t.BaseFs, *directory)
 	} else {
@@ -94,9 +180,40 @@ func (t *T) ParseArgs() (Command, error) {
 	}
 	t.Args = flags.Args()
 
+	if ((t.variants > 0) || (t.teamsFile != "")) && (t.seed == "") {
+		// Predictable seeds would let anyone with the generators work out every variant's answers
+		seed := make([]byte, 16)
+		if _, err := rand.Read(seed); err != nil {
+			return nothing, err
+		}
+		t.seed = hex.EncodeToString(seed) + "-"
+		fmt.Fprintf(t.Stderr, "No -seed given; to build these variants again, use -seed %s\n", t.seed)
+	}
+
 	return cmd, nil
 }
 
+// sourceCommit returns the commit to record in mothball manifests:
+// the -source flag if given,
+// otherwise the git HEAD of the work directory, if there is one.
+func (t *T) sourceCommit() string {
+	if t.source != "" {
+		return t.source
+	}
+	if _, ok := t.BaseFs.(*afero.OsFs); !ok {
+		return ""
+	}
+	dir := t.dir
+	if dir == "" {
+		dir = "."
+	}
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
 // PrintInventory prints a puzzle inventory to stdout
 func (t *T) PrintInventory() error {
 	c := transpile.NewFsCategory(t.fs, "")
@@ -157,10 +274,25 @@ func (t *T) DumpFile() error {
 }
 
 // DumpMothball writes a mothball to the writer, or an output file if specified.
+//
+// With -tokens, a token mothball is written instead.
 func (t *T) DumpMothball() error {
 	var w io.Writer
 	c := transpile.NewFsCategory(t.fs, "")
 
+	var tokens []transpile.Token
+	if t.tokensFile != "" {
+		var err error
+		if tokens, err = t.readTokens(); err != nil {
+			return err
+		}
+	}
+
+	opts, err := t.mothballOptions("")
+	if err != nil {
+		return err
+	}
+
 	filename := ""
 	if len(t.Args) == 0 {
 		w = t.Stdout
@@ -175,7 +307,12 @@ func (t *T) DumpMothball() error {
 		log.Println("Writing mothball to", filename)
 	}
 
-	if err := transpile.Mothball(c, w); err != nil {
+	if t.tokensFile != "" {
+		err = transpile.TokenMothball(tokens, w, opts)
+	} else {
+		err = transpile.MothballWithOptions(c, w, opts)
+	}
+	if err != nil {
 		if filename != "" {
 			t.BaseFs.Remove(filename)
 		}
@@ -184,6 +321,53 @@ func (t *T) DumpMothball() error {
 	return nil
 }
 
+// mothballOptions builds per-team variants, if requested.
+//
+// With -teams, every team ID listed in the file gets its own variant.
+// With -variants, that many variants are built,
+// and teams are assigned to them by transpile.VariantIndex.
+func (t *T) mothballOptions(cat string) (transpile.MothballOptions, error) {
+	opts := transpile.MothballOptions{
+		Source: t.sourceCommit(),
+	}
+	nvariants := t.variants
+
+	// https://reproducible-builds.org/specs/source-date-epoch/
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		secs, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return opts, fmt.Errorf("SOURCE_DATE_EPOCH: %v", err)
+		}
+		opts.Modified = time.Unix(secs, 0).UTC()
+	}
+
+	if t.teamsFile != "" {
+		buf, err := afero.ReadFile(t.BaseFs, t.teamsFile)
+		if err != nil {
+			return opts, err
+		}
+		opts.Teams = make(map[string]int)
+		for _, teamID := range strings.Fields(string(buf)) {
+			if _, ok := opts.Teams[teamID]; !ok {
+				opts.Teams[teamID] = len(opts.Teams)
+			}
+		}
+		if nvariants == 0 {
+			nvariants = len(opts.Teams)
+		} else {
+			for teamID, v := range opts.Teams {
+				opts.Teams[teamID] = v % nvariants
+			}
+		}
+	}
+
+	for i := 0; i < nvariants; i++ {
+		seed := fmt.Sprintf("%s%d", t.seed, i)
+		opts.Variants = append(opts.Variants, transpile.NewFsCategorySeed(t.fs, cat, seed))
+	}
+	return opts, nil
+}
+
 // CheckAnswer prints whether an answer is correct.
 func (t *T) CheckAnswer() error {
 	answer := ""
@@ -195,6 +379,60 @@ func (t *T) CheckAnswer() error {
 	return err
 }
 
+// Lint prints every problem found with a category, or a tree of categories.
+//
+// If there are any problems, an error is returned,
+// so scripts can tell.
+func (t *T) Lint() error {
+	var problems []transpile.Problem
+	if t.tree {
+		problems = transpile.LintTree(t.fs)
+	} else {
+		problems = transpile.Lint(t.fs, "")
+	}
+
+	return t.report(problems)
+}
+
+// Verify builds a mothball in memory,
+// and prints every problem found checking answers against it.
+//
+// If there are any problems, an error is returned.
+func (t *T) Verify() error {
+	c := transpile.NewFsCategory(t.fs, "")
+	opts, err := t.mothballOptions("")
+	if err != nil {
+		return err
+	}
+	problems, err := transpile.Verify(c, opts, transpile.DefaultDecoys)
+	if err != nil {
+		return err
+	}
+	return t.report(problems)
+}
+
+// report prints problems, as text or JSON,
+// and returns an error if there were any.
+func (t *T) report(problems []transpile.Problem) error {
+	if t.jsonOutput {
+		if problems == nil {
+			problems = []transpile.Problem{}
+		}
+		if err := json.NewEncoder(t.Stdout).Encode(problems); err != nil {
+			return err
+		}
+	} else {
+		for _, problem := range problems {
+			fmt.Fprintln(t.Stdout, problem)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems found", len(problems))
+	}
+	return nil
+}
+
 // Markdown runs stdin through a Markdown engine
 func (t *T) Markdown() error {
 	return transpile.Markdown(t.Stdin, t.Stdout)
@@ -233,3 +233,229 @@ func TestCwd(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestMothballVariants(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	tp := T{
+		Stdout: stdout,
+		Stderr: new(bytes.Buffer),
+		BaseFs: newTestFs(),
+	}
+	afero.WriteFile(tp.BaseFs, "teamids.txt", []byte("alpha\nbeta\n"), 0644)
+
+	if err := tp.Run("mothball", "-dir=unbroken", "-teams=teamids.txt", "unbroken.mb"); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := afero.ReadFile(tp.BaseFs, "unbroken.mb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zmb, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, zf := range zmb.File {
+		if zf.Name == "variants.txt" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("No variants.txt in mothball")
+	}
+	if !strings.Contains(tp.Stderr.(*bytes.Buffer).String(), "-seed ") {
+		t.Error("Random seed wasn't printed:", tp.Stderr)
+	}
+
+	// The printed seed builds the same mothball again
+	seed := strings.Fields(tp.Stderr.(*bytes.Buffer).String())
+	if err := tp.Run("mothball", "-dir=unbroken", "-teams=teamids.txt", "-seed", seed[len(seed)-1], "again.mb"); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := afero.ReadFile(tp.BaseFs, "again.mb"); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(buf, again) {
+		t.Error("Printed seed didn't rebuild the same mothball")
+	}
+
+	if err := tp.Run("mothball", "-dir=unbroken", "-teams=nonexistent.txt", "broken.mb"); err == nil {
+		t.Error("Missing teams file should have raised an error")
+	}
+	if _, err := tp.BaseFs.Stat("broken.mb"); err == nil {
+		t.Error("Failed mothball left an output file behind")
+	}
+}
+
+func TestLint(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	tp := T{
+		Stdout: stdout,
+		Stderr: new(bytes.Buffer),
+		BaseFs: newTestFs(),
+	}
+
+	if err := tp.Run("lint", "-dir=unbroken"); err != nil {
+		t.Error(err, stdout.String())
+	}
+
+	stdout.Reset()
+	if err := tp.Run("lint", "-dir=cat0"); err == nil {
+		t.Error("Problems in cat0 weren't reported")
+	} else if !strings.Contains(stdout.String(), "2/puzzle.moth:9: missing attachment: moo.txt") {
+		t.Error("Wrong lint output:", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := tp.Run("lint", "-tree", "-json"); err == nil {
+		t.Error("Problems in tree weren't reported")
+	}
+	problems := []transpile.Problem{}
+	if err := json.Unmarshal(stdout.Bytes(), &problems); err != nil {
+		t.Error(err)
+	}
+	for _, p := range problems {
+		if strings.HasPrefix(p.File, "unbroken/") {
+			t.Error("Problem reported in unbroken category:", p)
+		}
+	}
+	if len(problems) == 0 {
+		t.Error("No problems in JSON output")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	tp := T{
+		Stdout: stdout,
+		Stderr: new(bytes.Buffer),
+		BaseFs: newTestFs(),
+	}
+
+	if err := tp.Run("verify", "-dir=unbroken"); err != nil {
+		t.Error(err, stdout.String())
+	}
+
+	afero.WriteFile(tp.BaseFs, "noanswers/1/puzzle.md", []byte("---\nauthors: [me]\n---\n"), 0644)
+	stdout.Reset()
+	if err := tp.Run("verify", "-dir=noanswers", "-json"); err == nil {
+		t.Error("Puzzle without answers passed verification")
+	}
+	problems := []transpile.Problem{}
+	if err := json.Unmarshal(stdout.Bytes(), &problems); err != nil {
+		t.Error(err)
+	} else if len(problems) != 1 {
+		t.Error("Wrong problems:", problems)
+	}
+}
+
+func TestInspectDiff(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	tp := T{
+		Stdout: stdout,
+		Stderr: new(bytes.Buffer),
+		BaseFs: newTestFs(),
+	}
+
+	if err := tp.Run("mothball", "-dir=unbroken", "-source=abc", "old.mb"); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if err := tp.Run("inspect", "old.mb"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"source: abc\n", "1: 1 answers, by Arthur, Buster, DW\n", "    moo.txt (4 bytes)\n"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("Inspect output doesn't contain %q: %s", want, stdout.String())
+		}
+	}
+
+	stdout.Reset()
+	if err := tp.Run("diff", "old.mb", "old.mb"); err != nil {
+		t.Error(err, stdout.String())
+	}
+
+	afero.WriteFile(tp.BaseFs, "unbroken/2/moo.txt", []byte("Moooo."), 0644)
+	if err := tp.Run("mothball", "-dir=unbroken", "new.mb"); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if err := tp.Run("diff", "-json", "old.mb", "new.mb"); err == nil {
+		t.Error("Changes weren't reported")
+	}
+	changes := []transpile.Change{}
+	if err := json.Unmarshal(stdout.Bytes(), &changes); err != nil {
+		t.Error(err)
+	} else if (len(changes) != 1) || (changes[0].Detail != "changed moo.txt") {
+		t.Error("Wrong changes:", changes)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	tp := T{
+		Stdout: stdout,
+		Stderr: new(bytes.Buffer),
+		BaseFs: afero.NewMemMapFs(),
+	}
+
+	if err := tp.Run("tokens", "scavenger", "1x3", "5"); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := transpile.ReadTokens(bytes.NewReader(stdout.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 4 {
+		t.Fatal("Wrong number of tokens:", tokens)
+	}
+	if (tokens[0].Category != "scavenger") || (tokens[0].Points != 1) || (tokens[3].Points != 5) {
+		t.Error("Wrong tokens:", tokens)
+	}
+	afero.WriteFile(tp.BaseFs, "scavenger.txt", stdout.Bytes(), 0644)
+	if stderr := tp.Stderr.(*bytes.Buffer); stderr.Len() > 0 {
+		t.Error("Default token size gave a warning:", stderr.String())
+	}
+
+	// Short tokens still work, but can be guessed
+	if err := tp.Run("tokens", "-size=4", "scavenger", "1"); err != nil {
+		t.Error(err)
+	} else if stderr := tp.Stderr.(*bytes.Buffer).String(); !strings.Contains(stderr, "can be guessed") {
+		t.Error("No warning about short tokens:", stderr)
+	}
+	stdout.Reset()
+
+	for _, args := range [][]string{
+		{"tokens", "scavenger"},
+		{"tokens", "scavenger", "0"},
+		{"tokens", "scavenger", "1x"},
+		{"tokens", "scav:enger", "1"},
+		{"tokens", "-size=0", "scavenger", "1"},
+	} {
+		if err := tp.Run(args...); err == nil {
+			t.Error("No error from", args)
+		}
+	}
+
+	stdout.Reset()
+	if err := tp.Run("tokens", "-tokens=scavenger.txt", "-html"); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if !strings.Contains(stdout.String(), "<code>"+token.String()+"</code>") {
+			t.Errorf("Token %s not in HTML: %s", token, stdout.String())
+		}
+	}
+
+	if err := tp.Run("mothball", "-tokens=scavenger.txt", "scavenger.mb"); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if err := tp.Run("inspect", "scavenger.mb"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"tokens: 4\n", tokens[3].Nonce + ": 5 points\n"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("Inspect output doesn't contain %q: %s", want, stdout.String())
+		}
+	}
+}
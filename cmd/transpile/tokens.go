@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+
+	"github.com/dirtbags/moth/v4/pkg/transpile"
+)
+
+// tokenCards is a printable page of tokens, to cut up and hand out.
+var tokenCards = template.Must(template.New("tokens").Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <title>Tokens</title>
+    <style>
+      body { font-family: sans-serif; margin: 0; }
+      main { display: grid; grid-template-columns: repeat(auto-fill, minmax(18em, 1fr)); }
+      .token { border: 1px dashed #888; padding: 1em; break-inside: avoid; }
+      .points { float: right; font-weight: bold; }
+      .category { font-size: 120%; }
+      code { display: block; margin-top: 0.5em; font-size: 110%; }
+    </style>
+  </head>
+  <body>
+    <main>
+{{- range .}}
+      <div class="token">
+        <span class="points">{{.Points}}</span>
+        <span class="category">{{.Category}}</span>
+        <code>{{.}}</code>
+      </div>
+{{- end}}
+    </main>
+  </body>
+</html>
+`))
+
+// Tokens prints tokens, one per line, or as a printable HTML page with -html.
+//
+// Tokens are generated for CATEGORY, with arguments like 5x10 for ten 5-point tokens,
+// or read from a file given with -tokens.
+func (t *T) Tokens() error {
+	var tokens []transpile.Token
+	if t.tokensFile != "" {
+		if len(t.Args) > 0 {
+			return fmt.Errorf("usage: transpile tokens -tokens FILE")
+		}
+		var err error
+		if tokens, err = t.readTokens(); err != nil {
+			return err
+		}
+	} else {
+		if len(t.Args) < 2 {
+			return fmt.Errorf("usage: transpile tokens CATEGORY POINTS[xCOUNT]...")
+		}
+		if (t.tokenSize > 0) && (t.tokenSize < transpile.MinTokenSize) {
+			fmt.Fprintf(t.Stderr, "WARNING: %d-byte tokens can be guessed; use at least %d\n", t.tokenSize, transpile.MinTokenSize)
+		}
+		var err error
+		if tokens, err = generateTokens(t.Args[0], t.Args[1:], t.tokenSize); err != nil {
+			return err
+		}
+	}
+
+	if t.htmlOutput {
+		return tokenCards.Execute(t.Stdout, tokens)
+	}
+	for _, token := range tokens {
+		fmt.Fprintln(t.Stdout, token)
+	}
+	return nil
+}
+
+// readTokens reads the token list given with -tokens.
+func (t *T) readTokens() ([]transpile.Token, error) {
+	f, err := t.BaseFs.Open(t.tokensFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tokens, err := transpile.ReadTokens(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", t.tokensFile, err)
+	}
+	return tokens, nil
+}
+
+// generateTokens makes new tokens for category.
+// Each spec is a point value, optionally followed by "x" and how many tokens to make.
+func generateTokens(category string, specs []string, size int) ([]transpile.Token, error) {
+	if strings.ContainsAny(category, ":/") {
+		return nil, fmt.Errorf("invalid category name: %q", category)
+	}
+
+	var tokens []transpile.Token
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		pointsStr, countStr, found := strings.Cut(spec, "x")
+		points, err := strconv.Atoi(pointsStr)
+		if (err != nil) || (points <= 0) {
+			return nil, fmt.Errorf("invalid point value: %q", spec)
+		}
+		count := 1
+		if found {
+			if count, err = strconv.Atoi(countStr); (err != nil) || (count <= 0) {
+				return nil, fmt.Errorf("invalid count: %q", spec)
+			}
+		}
+		for i := 0; i < count; {
+			token, err := transpile.NewToken(category, points, size)
+			if err != nil {
+				return nil, err
+			}
+			if seen[token.Nonce] {
+				// Unlikely, but a repeated nonce would be worth less
+				continue
+			}
+			seen[token.Nonce] = true
+			tokens = append(tokens, token)
+			i++
+		}
+	}
+	return tokens, nil
+}
@@ -2,11 +2,9 @@
 package award
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/url"
-	"reflect"
 	"strconv"
 	"strings"
 )
@@ -18,6 +16,37 @@ type T struct {
 	TeamID   string
 	Category string
 	Points   int
+
+	// Slug names the puzzle, if it isn't named by its point value.
+	// Recurring awards have a slug made by RecurringSlug.
+	Slug string
+
+	// extra holds extra fields, URL query encoded with sorted keys,
+	// so awards can still be compared with ==.
+	extra string
+}
+
+// RecurringSlug returns the slug for a recurring award:
+// one made over and over, like the points for holding a hill,
+// which can only be made once for each tick.
+//
+// Puzzle slugs never contain "@", so recurring awards can't be mistaken for them.
+func RecurringSlug(name string, tick int64) string {
+	return fmt.Sprintf("%s@%d", name, tick)
+}
+
+// Recurring returns the name and tick of a recurring award,
+// and whether a is a recurring award.
+func (a T) Recurring() (name string, tick int64, ok bool) {
+	name, tickStr, found := strings.Cut(a.Slug, "@")
+	if !found {
+		return "", 0, false
+	}
+	tick, err := strconv.ParseInt(tickStr, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return name, tick, true
 }
 
 // List is a collection of award events.
@@ -40,6 +69,32 @@ func (awards List) Swap(i, j int) {
 	awards[j] = tmp
 }
 
+// Log line format versions.
+//
+// Parse reads every version.
+// String writes the oldest version that can hold the award,
+// so tools that only know older versions keep working until something new shows up.
+const (
+	// Format1 lines are "when teamID category points"
+	Format1 = 1
+
+	// Format2 lines add the slug of the puzzle in a URL query encoded field:
+	// "when teamID category points slug=bonus"
+	Format2 = 2
+
+	// Format3 lines add extra fields to that field:
+	// "when teamID category points slug=bonus&reason=first+blood&source=cli".
+	// The slug is left out if there isn't one.
+	Format3 = 3
+)
+
+// slugKey names the slug in the last field of format 2 and 3 lines.
+// It's written first, so the slug is easy to find.
+//
+// Before slugs, anything after the point value was ignored,
+// so a bare word there is a comment, not a slug.
+const slugKey = "slug"
+
 // Parse parses a string log entry into an award.T.
 func Parse(s string) (T, error) {
 	ret := T{}
@@ -53,16 +108,147 @@ func Parse(s string) (T, error) {
 		return ret, fmt.Errorf("malformed award string: only parsed %d fields", n)
 	}
 
-	return ret, nil
+	rest := strings.Fields(s)[4:]
+	if parsed, ok := parseRest(ret, rest); ok {
+		return parsed, nil
+	}
+
+	// Before slugs, everything after the points was ignored,
+	// so old lines can have anything there.
+	// Keep it as the reason, instead of throwing away the award.
+	comment := strings.Join(rest, " ")
+	if strings.HasPrefix(comment, "cli:") {
+		// contrib/award used to write "cli:" and then a comment, which could be several words
+		ret = ret.WithExtra("source", "cli")
+		comment = strings.TrimPrefix(comment, "cli:")
+	}
+	return ret.WithExtra("reason", comment), nil
+}
+
+// parseRest parses the fields after the point value of a format 2 or 3 line into a.
+// It returns false if they aren't laid out like either format.
+func parseRest(a T, rest []string) (T, bool) {
+	switch {
+	case len(rest) == 0:
+		return a, true
+	case (len(rest) > 1) || strings.HasPrefix(rest[0], "cli:") || !strings.Contains(rest[0], "="):
+		return a, false
+	}
+
+	values, err := url.ParseQuery(rest[0])
+	if (err != nil) || (len(values[slugKey]) > 1) {
+		return a, false
+	}
+	a.Slug = values.Get(slugKey)
+	values.Del(slugKey)
+	if a.extra, err = parseExtra(values.Encode()); err != nil {
+		return a, false
+	}
+	return a, true
+}
+
+// parseExtra parses URL query encoded extra fields,
+// and returns them in canonical form.
+func parseExtra(s string) (string, error) {
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return "", err
+	}
+	for key, vals := range values {
+		switch {
+		case key == "":
+			return "", fmt.Errorf("extra field with no name")
+		case len(vals) > 1:
+			return "", fmt.Errorf("extra field %q given more than once", key)
+		case vals[0] == "":
+			delete(values, key)
+		}
+	}
+	return values.Encode(), nil
+}
+
+// Format returns the oldest log line format version that can hold a.
+func (a T) Format() int {
+	switch {
+	case a.extra != "":
+		return Format3
+	case a.Slug != "":
+		return Format2
+	}
+	return Format1
+}
+
+// Extra returns the extra field named key, or the empty string if there isn't one.
+func (a T) Extra(key string) string {
+	values, _ := url.ParseQuery(a.extra)
+	return values.Get(key)
+}
+
+// Extras returns every extra field.
+func (a T) Extras() map[string]string {
+	values, _ := url.ParseQuery(a.extra)
+	ret := make(map[string]string, len(values))
+	for key := range values {
+		ret[key] = values.Get(key)
+	}
+	return ret
+}
+
+// WithExtra returns a copy of a, with the extra field key set to value.
+// An empty value removes the field.
+//
+// Extra fields say more about an award, like why it was made,
+// but they don't make it a different award: Equal ignores them.
+// The slug isn't an extra field, so a key of "slug" is ignored.
+func (a T) WithExtra(key, value string) T {
+	if key == slugKey {
+		return a
+	}
+	values, _ := url.ParseQuery(a.extra)
+	if value == "" {
+		values.Del(key)
+	} else {
+		values.Set(key, value)
+	}
+	a.extra = values.Encode()
+	return a
 }
 
 // String returns a log entry string for an award.T.
 func (a T) String() string {
-	return fmt.Sprintf("%d %s %s %d", a.When, a.TeamID, a.Category, a.Points)
+	fields := []string{
+		strconv.FormatInt(a.When, 10),
+		a.TeamID,
+		a.Category,
+		strconv.Itoa(a.Points),
+	}
+	var last []string
+	if a.Slug != "" {
+		// "@" is fine in a query, and recurring slugs are easier to read without it escaped
+		slug := strings.ReplaceAll(url.QueryEscape(a.Slug), "%40", "@")
+		last = append(last, slugKey+"="+slug)
+	}
+	if a.extra != "" {
+		last = append(last, a.extra)
+	}
+	if len(last) > 0 {
+		fields = append(fields, strings.Join(last, "&"))
+	}
+	return strings.Join(fields, " ")
 }
 
 // Filename returns a string version of an award suitable for a filesystem
 func (a T) Filename() string {
+	if a.Slug != "" {
+		return fmt.Sprintf(
+			"%d-%s-%s-%d-%s.award",
+			a.When,
+			url.PathEscape(a.TeamID),
+			url.PathEscape(a.Category),
+			a.Points,
+			url.PathEscape(a.Slug),
+		)
+	}
 	return fmt.Sprintf(
 		"%d-%s-%s-%d.award",
 		a.When,
@@ -73,6 +259,10 @@ func (a T) Filename() string {
 }
 
 // MarshalJSON returns the award event, encoded as a list.
+//
+// The slug goes on the end, if there is one.
+// Extra fields go after that, as an object,
+// with an empty slug if there isn't one.
 func (a T) MarshalJSON() ([]byte, error) {
 	ao := []interface{}{
 		a.When,
@@ -80,93 +270,74 @@ func (a T) MarshalJSON() ([]byte, error) {
 		a.Category,
 		a.Points,
 	}
+	if (a.Slug != "") || (a.extra != "") {
+		ao = append(ao, a.Slug)
+	}
+	if a.extra != "" {
+		ao = append(ao, a.Extras())
+	}
 
 	return json.Marshal(ao)
 }
 
-// UnmarshalJSON decodes the JSON string b.
-func (a T) UnmarshalJSON(b []byte) error {
-	r := bytes.NewReader(b)
-	dec := json.NewDecoder(r)
-	dec.UseNumber() // Don't use floats
-
-	// All this to make sure we get `[`
-	t, err := dec.Token()
-	if err != nil {
-		return err
-	}
-	switch token := t.(type) {
-	case json.Delim:
-		if token.String() != "[" {
-			return &json.UnmarshalTypeError{
-				Value:  token.String(),
-				Type:   reflect.TypeOf(a),
-				Offset: 0,
-			}
-		}
-	default:
-		return &json.UnmarshalTypeError{
-			Value:  fmt.Sprintf("%v", t),
-			Type:   reflect.TypeOf(a),
-			Offset: 0,
-		}
-	}
-
-	var num json.Number
-	if err := dec.Decode(&num); err != nil {
-		return err
-	}
-	if a.When, err = strconv.ParseInt(string(num), 10, 64); err != nil {
+// UnmarshalJSON decodes the JSON string b,
+// which is a list like the one written by MarshalJSON.
+func (a *T) UnmarshalJSON(b []byte) error {
+	var ao []json.RawMessage
+	if err := json.Unmarshal(b, &ao); err != nil {
 		return err
 	}
-	if err := dec.Decode(&a.Category); err != nil {
-		return err
-	}
-	if err := dec.Decode(&a.TeamID); err != nil {
-		return err
-	}
-	if err := dec.Decode(&num); err != nil {
-		return err
-	}
-	if a.Points, err = strconv.Atoi(string(num)); err != nil {
-		return err
+	if (len(ao) < 4) || (len(ao) > 6) {
+		return fmt.Errorf("award has %d fields, wanted 4 to 6", len(ao))
 	}
 
-	// All this to make sure we get `]`
-	t, err = dec.Token()
-	if err != nil {
-		return err
-	}
-	switch token := t.(type) {
-	case json.Delim:
-		if token.String() != "]" {
-			return &json.UnmarshalTypeError{
-				Value:  token.String(),
-				Type:   reflect.TypeOf(a),
-				Offset: 0,
+	ret := T{}
+	fields := []interface{}{&ret.When, &ret.TeamID, &ret.Category, &ret.Points, &ret.Slug}
+	for i, raw := range ao {
+		if i == 5 {
+			var extra map[string]string
+			if err := json.Unmarshal(raw, &extra); err != nil {
+				return err
 			}
+			for key, value := range extra {
+				if key == "" {
+					return fmt.Errorf("extra field with no name")
+				}
+				ret = ret.WithExtra(key, value)
+			}
+			continue
 		}
-	default:
-		return &json.UnmarshalTypeError{
-			Value:  fmt.Sprintf("%v", t),
-			Type:   reflect.TypeOf(a),
-			Offset: 0,
+		if err := json.Unmarshal(raw, fields[i]); err != nil {
+			return err
 		}
 	}
 
+	*a = ret
 	return nil
 }
 
+// Key identifies an award, ignoring when it happened and any extras.
+// Two awards are Equal if they have the same Key,
+// so keys can be used to index a points log.
+type Key struct {
+	TeamID   string
+	Category string
+	Points   int
+	Slug     string
+}
+
+// Key returns the Key for a.
+func (a T) Key() Key {
+	return Key{
+		TeamID:   a.TeamID,
+		Category: a.Category,
+		Points:   a.Points,
+		Slug:     a.Slug,
+	}
+}
+
 // Equal returns true if two award events represent the same award.
 // Timestamps are ignored in this comparison!
 func (a T) Equal(o T) bool {
-	switch {
-	case a.TeamID != o.TeamID:
-		return false
-	case a.Category != o.Category:
-		return false
-	case a.Points != o.Points:
-		return false
-	}
-	return true
+	return a.Key() == o.Key()
 }
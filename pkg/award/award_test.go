@@ -1,7 +1,9 @@
 package award
 
 import (
+	"encoding/json"
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -41,6 +43,9 @@ func TestAward(t *testing.T) {
 	if a.Equal(c) {
 		t.Error("Different pount values compare equal")
 	}
+	if (a.Key() != b.Key()) || (a.Key() == c.Key()) {
+		t.Error("Keys don't agree with Equal")
+	}
 
 	ja, err := a.MarshalJSON()
 	if err != nil {
@@ -90,3 +95,248 @@ func TestAwardList(t *testing.T) {
 		t.Error("Sorted list thinks it isn't")
 	}
 }
+
+func TestAwardSlug(t *testing.T) {
+	entry := "1536958399 1a2b3c4d counting 10 slug=bonus"
+	a, err := Parse(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (a.Points != 10) || (a.Slug != "bonus") {
+		t.Error("Slug parsed wrong:", a)
+	}
+	if a.String() != entry {
+		t.Error("String conversion wonky:", a.String())
+	}
+	if a.Filename() != "1536958399-1a2b3c4d-counting-10-bonus.award" {
+		t.Error("Wrong filename:", a.Filename())
+	}
+
+	if ja, err := a.MarshalJSON(); err != nil {
+		t.Error(err)
+	} else if string(ja) != `[1536958399,"1a2b3c4d","counting",10,"bonus"]` {
+		t.Error("JSON wrong:", string(ja))
+	}
+
+	b, _ := Parse("1536958399 1a2b3c4d counting 10 slug=other")
+	if a.Equal(b) {
+		t.Error("Different slugs compare equal")
+	}
+	c, _ := Parse("1536958399 1a2b3c4d counting 10")
+	if a.Equal(c) {
+		t.Error("Slug and point value compare equal")
+	}
+
+	if d, err := Parse(entry + " extra"); err != nil {
+		t.Error(err)
+	} else if (d.Slug != "") || (d.Extra("reason") != "slug=bonus extra") {
+		t.Error("Unexpected fields not kept as a comment:", d)
+	}
+
+	// A bare word is a comment from before slugs, even if it looks like a slug
+	if d, err := Parse("1536958399 1a2b3c4d counting 10 bonus"); err != nil {
+		t.Error(err)
+	} else if (d.Slug != "") || (d.Extra("reason") != "bonus") || !d.Equal(c) {
+		t.Error("One word comment read as a slug:", d)
+	}
+}
+
+func TestAwardRecurring(t *testing.T) {
+	slug := RecurringSlug("web", 26711716)
+	if slug != "web@26711716" {
+		t.Error("Wrong recurring slug:", slug)
+	}
+
+	a, err := Parse("1602702960 2255 hills 5 slug=" + slug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, tick, ok := a.Recurring(); !ok || (name != "web") || (tick != 26711716) {
+		t.Error("Recurring award parsed wrong:", name, tick, ok)
+	}
+	if a.String() != "1602702960 2255 hills 5 slug=web@26711716" {
+		t.Error("Recurring award written wrong:", a.String())
+	}
+
+	for _, s := range []string{"1602702960 2255 hills 5", "1602702960 2255 hills 5 slug=bonus", "1602702960 2255 hills 5 slug=web@soon", "1602702960 2255 hills 5 web@26711716"} {
+		a, _ := Parse(s)
+		if _, _, ok := a.Recurring(); ok {
+			t.Error("Not a recurring award:", s)
+		}
+	}
+
+	b, _ := Parse("1602703020 2255 hills 5 slug=" + RecurringSlug("web", 26711717))
+	if a.Equal(b) {
+		t.Error("Different ticks compare equal")
+	}
+}
+
+func TestAwardExtra(t *testing.T) {
+	entry := "1536958399 1a2b3c4d counting 10 slug=bonus&reason=first+blood&source=cli"
+	a, err := Parse(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (a.Slug != "bonus") || (a.Extra("reason") != "first blood") || (a.Extra("source") != "cli") {
+		t.Error("Extra fields parsed wrong:", a)
+	}
+	if a.Format() != Format3 {
+		t.Error("Wrong format:", a.Format())
+	}
+	if a.String() != entry {
+		t.Error("String conversion wonky:", a.String())
+	}
+	if b, _ := Parse("1536958399 1a2b3c4d counting 10 slug=bonus"); !a.Equal(b) {
+		t.Error("Extra fields made a different award")
+	}
+
+	// Keys are sorted, so the same fields always make the same award
+	if b, err := Parse("1536958399 1a2b3c4d counting 10 source=cli&reason=first%20blood&slug=bonus"); err != nil {
+		t.Error(err)
+	} else if a != b {
+		t.Error("Same extra fields in a different order aren't the same:", a, b)
+	}
+
+	c, err := Parse("1536958399 1a2b3c4d counting 10 participant=alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (c.Slug != "") || (c.Extra("participant") != "alice") {
+		t.Error("Extra fields without a slug parsed wrong:", c)
+	}
+	if c = c.WithExtra("participant", ""); (c.Format() != Format1) || (c.String() != "1536958399 1a2b3c4d counting 10") {
+		t.Error("Removing the last extra field didn't:", c)
+	}
+	if c = c.WithExtra("bonus", "double & then some"); c.String() != "1536958399 1a2b3c4d counting 10 bonus=double+%26+then+some" {
+		t.Error("Extra field encoded wrong:", c)
+	}
+	if extras := c.Extras(); (len(extras) != 1) || (extras["bonus"] != "double & then some") {
+		t.Error("Wrong extras:", extras)
+	}
+	if d := c.WithExtra("slug", "bonus"); (d != c) || (d.Slug != "") {
+		t.Error("Slug set as an extra field:", d)
+	}
+
+	// Anything else after the points is an old comment
+	for s, comment := range map[string]string{
+		"1536958399 1a2b3c4d counting 10 reason=x bonus": "reason=x bonus",
+		"1536958399 1a2b3c4d counting 10 bonus other":    "bonus other",
+		"1536958399 1a2b3c4d counting 10 bonus a=1 b=2":  "bonus a=1 b=2",
+		"1536958399 1a2b3c4d counting 10 a=1&a=2":        "a=1&a=2",
+		"1536958399 1a2b3c4d counting 10 =1":             "=1",
+		"1536958399 1a2b3c4d counting 10 a=%zz":          "a=%zz",
+		"1536958399 1a2b3c4d counting 10 bonus reason=x": "bonus reason=x",
+		"1536958399 1a2b3c4d counting 10 slug=a&slug=b":  "slug=a&slug=b",
+	} {
+		a, err := Parse(s)
+		if err != nil {
+			t.Error(err)
+		} else if (a.Slug != "") || (a.Extra("reason") != comment) || (len(a.Extras()) != 1) {
+			t.Error("Bad extra fields not kept as a comment:", s, a)
+		}
+	}
+}
+
+func TestAwardOldFormats(t *testing.T) {
+	for entry, format := range map[string]int{
+		"1536958399 1a2b3c4d counting 10":                  Format1,
+		"1536958399 1a2b3c4d counting 10 slug=bonus":       Format2,
+		"1536958399 1a2b3c4d counting 10 slug=web@2560973": Format2,
+	} {
+		a, err := Parse(entry)
+		if err != nil {
+			t.Error(err)
+		} else if a.Format() != format {
+			t.Errorf("%s: wanted format %d, got %d", entry, format, a.Format())
+		} else if a.String() != entry {
+			t.Error("Old format not written back the same:", a.String())
+		}
+	}
+
+	// contrib/award used to write a comment in the fifth field
+	a, err := Parse("1536958399 1a2b3c4d counting 10 cli:late+submission")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (a.Slug != "") || (a.Extra("source") != "cli") || (a.Extra("reason") != "late+submission") {
+		t.Error("Old comment parsed wrong:", a)
+	}
+	if a, _ := Parse("1536958399 1a2b3c4d counting 10 cli:"); (a.Slug != "") || (a.Extra("reason") != "") {
+		t.Error("Old empty comment parsed wrong:", a)
+	}
+
+	// Lines from points logs written before slugs,
+	// when everything after the points was ignored
+	for entry, reason := range map[string]string{
+		"1536958399 1a2b3c4d counting 10 cli:first blood bonus":  "first blood bonus",
+		"1536958399 1a2b3c4d counting 10 cli:late: network down": "late: network down",
+		"1536958399 1a2b3c4d counting 10 cli:bonus":              "bonus",
+		"1536958399 1a2b3c4d counting 10 hand-scored by neale":   "hand-scored by neale",
+		"1536958399 1a2b3c4d counting 10 see ticket #42":         "see ticket #42",
+		"1536958399 1a2b3c4d counting 10 bonus":                  "bonus",
+		"1536958399 1a2b3c4d counting 10 web@2560973":            "web@2560973",
+	} {
+		a, err := Parse(entry)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if (a.TeamID != "1a2b3c4d") || (a.Category != "counting") || (a.Points != 10) || (a.Slug != "") {
+			t.Error("Old line parsed wrong:", entry, a)
+		}
+		if a.Extra("reason") != reason {
+			t.Errorf("%s: wanted reason %q, got %q", entry, reason, a.Extra("reason"))
+		}
+		if strings.Contains(entry, " cli:") != (a.Extra("source") == "cli") {
+			t.Error("Wrong source:", entry, a)
+		}
+
+		// What gets written back must read back the same
+		if b, err := Parse(a.String()); (err != nil) || (b.Extra("reason") != reason) || (b.Slug != "") {
+			t.Error("Old line doesn't survive being written again:", a.String(), b, err)
+		}
+	}
+}
+
+func TestAwardJSON(t *testing.T) {
+	for _, entry := range []string{
+		"1536958399 1a2b3c4d counting 10",
+		"1536958399 1a2b3c4d counting 10 slug=bonus",
+		"1536958399 1a2b3c4d counting 10 slug=bonus&reason=first+blood",
+		"1536958399 1a2b3c4d counting 10 reason=first+blood&source=cli",
+	} {
+		a, err := Parse(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ja, err := json.Marshal(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b T
+		if err := json.Unmarshal(ja, &b); err != nil {
+			t.Error(entry, err)
+		} else if a != b {
+			t.Errorf("JSON round trip: %q became %q", a, b)
+		}
+	}
+
+	a, _ := Parse("1536958399 1a2b3c4d counting 10 reason=first+blood")
+	if ja, _ := json.Marshal(a); string(ja) != `[1536958399,"1a2b3c4d","counting",10,"",{"reason":"first blood"}]` {
+		t.Error("JSON wrong:", string(ja))
+	}
+
+	var list List
+	if err := json.Unmarshal([]byte(`[[1,"team","cat",2],[3,"team","cat",4,"slug",{"source":"cli"}]]`), &list); err != nil {
+		t.Fatal(err)
+	}
+	if (len(list) != 2) || (list[0].TeamID != "team") || (list[0].Category != "cat") || (list[1].Extra("source") != "cli") {
+		t.Error("List unmarshaled wrong:", list)
+	}
+
+	for _, s := range []string{`[1,"a","b",4,"",{"":"x"}]`, `[1,"a","b",4,"",{"x":1}]`, `[1,"a","b",4,"",{},7]`} {
+		if err := a.UnmarshalJSON([]byte(s)); err == nil {
+			t.Error("Bad unmarshal didn't return error:", s)
+		}
+	}
+}
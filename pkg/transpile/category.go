@@ -8,9 +8,9 @@ import (
 	"log"
 	"os/exec"
 	"path"
+	"regexp"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/spf13/afero"
 )
@@ -35,6 +35,96 @@ type Category interface {
 	Answer(points int, answer string) bool
 }
 
+// SlugCategory is implemented by categories with puzzles named by slug,
+// instead of by point value.
+//
+// A slug puzzle's directory is named with its slug,
+// and its puzzle.md says how many points it's worth.
+// Any number of slug puzzles may be worth the same number of points.
+type SlugCategory interface {
+	// Slugs maps the slug of every slug puzzle to its point value.
+	Slugs() (map[string]int, error)
+
+	// SlugPuzzle provides a Puzzle structure for the puzzle named slug.
+	SlugPuzzle(slug string) (Puzzle, error)
+
+	// SlugOpen returns an io.ReadCloser for a file belonging to the puzzle named slug.
+	SlugOpen(slug string, filename string) (ReadSeekCloser, error)
+
+	// SlugAnswer returns whether the given answer is correct for the puzzle named slug.
+	SlugAnswer(slug string, answer string) bool
+}
+
+// slugPattern matches valid slugs.
+// Slugs start with a letter, so they can't be mistaken for point values.
+var slugPattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// ValidSlug returns true if s can be used as a slug.
+func ValidSlug(s string) bool {
+	return slugPattern.MatchString(s)
+}
+
+// IsSlug returns true if id names a puzzle by slug, rather than by point value.
+func IsSlug(id string) bool {
+	_, err := strconv.Atoi(id)
+	return err != nil
+}
+
+// PuzzleID returns the ID of a puzzle: its slug if it has one,
+// or else its point value.
+func PuzzleID(points int, slug string) string {
+	if slug != "" {
+		return slug
+	}
+	return strconv.Itoa(points)
+}
+
+// CategoryPuzzle returns the puzzle in c with the given ID.
+func CategoryPuzzle(c Category, id string) (Puzzle, error) {
+	if !IsSlug(id) {
+		points, _ := strconv.Atoi(id)
+		puzzle, err := c.Puzzle(points)
+		puzzle.Points = points
+		return puzzle, err
+	}
+	if sc, ok := c.(SlugCategory); ok {
+		return sc.SlugPuzzle(id)
+	}
+	return Puzzle{}, fmt.Errorf("no such puzzle: %s", id)
+}
+
+// CategoryOpen opens a file belonging to the puzzle in c with the given ID.
+func CategoryOpen(c Category, id string, filename string) (ReadSeekCloser, error) {
+	if !IsSlug(id) {
+		points, _ := strconv.Atoi(id)
+		return c.Open(points, filename)
+	}
+	if sc, ok := c.(SlugCategory); ok {
+		return sc.SlugOpen(id, filename)
+	}
+	return nopCloser{new(bytes.Reader)}, fmt.Errorf("no such puzzle: %s", id)
+}
+
+// CategoryAnswer asks the puzzle in c with the given ID whether answer is correct.
+func CategoryAnswer(c Category, id string, answer string) bool {
+	if !IsSlug(id) {
+		points, _ := strconv.Atoi(id)
+		return c.Answer(points, answer)
+	}
+	if sc, ok := c.(SlugCategory); ok {
+		return sc.SlugAnswer(id, answer)
+	}
+	return false
+}
+
+// categorySlugs returns c's slug puzzles, if it has any.
+func categorySlugs(c Category) (map[string]int, error) {
+	if sc, ok := c.(SlugCategory); ok {
+		return sc.Slugs()
+	}
+	return nil, nil
+}
+
 // NopReadCloser provides an io.ReadCloser which does nothing.
 type NopReadCloser struct {
 }
@@ -53,7 +143,33 @@ func (n NopReadCloser) Close() error {
 // If 'mkcategory' is present and executable, an FsCommandCategory is returned.
 // Otherwise, FsCategory is returned.
 func NewFsCategory(fs afero.Fs, cat string) Category {
+	return NewFsCategorySeed(fs, cat, "")
+}
+
+// NewFsCategorySeed returns a Category whose generators are run with SEED set to seed.
+//
+// This is how each team gets its own variant of generated puzzles:
+// mkcategory and mkpuzzle programs should derive everything random from SEED.
+// An empty seed leaves SEED alone.
+//
+// If the category has a configuration file,
+// its command settings are used to run generators,
+// and its description is returned by Describe.
+func NewFsCategorySeed(fs afero.Fs, cat string, seed string) Category {
+	return NewFsCategoryConfig(fs, cat, seed, DefaultCommandConfig)
+}
+
+// NewFsCategoryConfig returns a Category like NewFsCategorySeed,
+// with anything not set in the category's configuration file taken from defaults
+// instead of DefaultCommandConfig.
+func NewFsCategoryConfig(fs afero.Fs, cat string, seed string, defaults CommandConfig) Category {
 	bfs := NewRecursiveBasePathFs(fs, cat)
+	categoryConfig, err := ReadCategoryConfig(bfs)
+	if err != nil {
+		log.Printf("%s: %s: %v", cat, CategoryConfigFilename, err)
+	}
+	config := categoryConfig.Command.Merge(defaults)
+
 	if info, err := bfs.Stat("mkcategory"); (err == nil) && (info.Mode()&0100 != 0) {
 		if command, err := bfs.RealPath(info.Name()); err != nil {
 			log.Println("Unable to resolve full path to", info.Name())
@@ -61,16 +177,26 @@ func NewFsCategory(fs afero.Fs, cat string) Category {
 			return FsCommandCategory{
 				fs:      bfs,
 				command: command,
-				timeout: 2 * time.Second,
+				seed:    seed,
+				config:  config,
+				info:    categoryConfig.CategoryInfo,
 			}
 		}
 	}
-	return FsCategory{fs: bfs}
+	return FsCategory{fs: bfs, seed: seed, config: config, info: categoryConfig.CategoryInfo}
 }
 
 // FsCategory provides a category backed by a .md file.
 type FsCategory struct {
-	fs afero.Fs
+	fs     afero.Fs
+	seed   string
+	config CommandConfig
+	info   CategoryInfo
+}
+
+// Describe returns the description from the category's configuration file.
+func (c FsCategory) Describe() CategoryInfo {
+	return c.info
 }
 
 // Inventory returns a list of point values for this category.
@@ -85,24 +211,98 @@ func (c FsCategory) Inventory() ([]int, error) {
 		if !ent.IsDir() {
 			continue
 		}
-		if points, err := strconv.Atoi(ent.Name()); err != nil {
-			log.Println("Skipping non-numeric directory", ent.Name())
-			continue
-		} else {
+		if points, err := strconv.Atoi(ent.Name()); err == nil {
 			puzzles = append(puzzles, points)
+		} else if !ValidSlug(ent.Name()) {
+			log.Println("Skipping directory which isn't a point value or slug:", ent.Name())
 		}
 	}
 	return puzzles, nil
 }
 
+// Slugs maps the slugs of puzzles with a directory named by slug to their point values.
+//
+// Directories without puzzle.md or mkpuzzle are skipped,
+// so categories can keep other things in directories.
+// Puzzles which don't say how many points they're worth are skipped too.
+func (c FsCategory) Slugs() (map[string]int, error) {
+	puzzleEntries, err := afero.ReadDir(c.fs, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	slugs := make(map[string]int)
+	for _, ent := range puzzleEntries {
+		if !ent.IsDir() || !ValidSlug(ent.Name()) {
+			continue
+		}
+		if !isPuzzleDir(c.fs, ent.Name()) {
+			continue
+		}
+		puzzle, err := c.SlugPuzzle(ent.Name())
+		if err != nil {
+			log.Printf("Skipping puzzle %s: %v", ent.Name(), err)
+			continue
+		}
+		if puzzle.Points <= 0 {
+			log.Printf("Skipping puzzle %s: no points given", ent.Name())
+			continue
+		}
+		slugs[ent.Name()] = puzzle.Points
+	}
+	return slugs, nil
+}
+
+// isPuzzleDir returns true if dir has puzzle.md or mkpuzzle.
+func isPuzzleDir(fs afero.Fs, dir string) bool {
+	for _, name := range []string{"puzzle.md", "mkpuzzle"} {
+		if _, err := fs.Stat(path.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
 // Puzzle returns a Puzzle structure for the given point value.
 func (c FsCategory) Puzzle(points int) (Puzzle, error) {
-	return NewFsPuzzlePoints(c.fs, points).Puzzle()
+	puzzle, err := newFsPuzzlePoints(c.fs, points, c.seed, c.config).Puzzle()
+	puzzle.Points = points
+	return puzzle, err
+}
+
+// SlugPuzzle returns a Puzzle structure for the puzzle named slug.
+func (c FsCategory) SlugPuzzle(slug string) (Puzzle, error) {
+	if !ValidSlug(slug) {
+		return Puzzle{}, fmt.Errorf("invalid slug: %q", slug)
+	}
+	return newFsPuzzle(NewRecursiveBasePathFs(c.fs, slug), c.seed, c.config).Puzzle()
+}
+
+// SlugOpen returns an io.ReadCloser for a file belonging to the puzzle named slug.
+func (c FsCategory) SlugOpen(slug string, filename string) (ReadSeekCloser, error) {
+	if !ValidSlug(slug) {
+		return nopCloser{new(bytes.Reader)}, fmt.Errorf("invalid slug: %q", slug)
+	}
+	return newFsPuzzle(NewRecursiveBasePathFs(c.fs, slug), c.seed, c.config).Open(filename)
+}
+
+// SlugAnswer checks whether an answer is correct for the puzzle named slug.
+func (c FsCategory) SlugAnswer(slug string, answer string) bool {
+	p, err := c.SlugPuzzle(slug)
+	if err != nil {
+		return false
+	}
+	for _, a := range p.Answers {
+		if a == answer {
+			return true
+		}
+	}
+	return false
 }
 
 // Open returns an io.ReadCloser for the given filename.
 func (c FsCategory) Open(points int, filename string) (ReadSeekCloser, error) {
-	return NewFsPuzzlePoints(c.fs, points).Open(filename)
+	return newFsPuzzlePoints(c.fs, points, c.seed, c.config).Open(filename)
 }
 
 // Answer checks whether an answer is correct.
@@ -124,16 +324,27 @@ func (c FsCategory) Answer(points int, answer string) bool {
 type FsCommandCategory struct {
 	fs      afero.Fs
 	command string
-	timeout time.Duration
+	seed    string
+	config  CommandConfig
+	info    CategoryInfo
+}
+
+// Describe returns the description from the category's configuration file.
+func (c FsCommandCategory) Describe() CategoryInfo {
+	return c.info
+}
+
+func (c FsCommandCategory) cmd(ctx context.Context, command string, args ...string) *exec.Cmd {
+	cmdargs := append([]string{command}, args...)
+	cmd := c.config.Command(ctx, c.command, seedEnv(c.seed), cmdargs...)
+	return cmd
 }
 
 func (c FsCommandCategory) run(command string, args ...string) ([]byte, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
 	defer cancel()
 
-	cmdargs := append([]string{command}, args...)
-	cmd := exec.CommandContext(ctx, "./"+path.Base(c.command), cmdargs...)
-	cmd.Dir = path.Dir(c.command)
+	cmd := c.cmd(ctx, command, args...)
 	out, err := cmd.Output()
 	if err, ok := err.(*exec.ExitError); ok {
 		stderr := strings.TrimSpace(string(err.Stderr))
@@ -178,9 +389,16 @@ func (c FsCommandCategory) Puzzle(points int) (Puzzle, error) {
 }
 
 // Open returns an io.ReadCloser for the given filename.
+// Output is spooled to a temporary file, so large attachments don't fill up memory.
 func (c FsCommandCategory) Open(points int, filename string) (ReadSeekCloser, error) {
-	stdout, err := c.run("file", strconv.Itoa(points), filename)
-	return nopCloser{bytes.NewReader(stdout)}, err
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.FileTimeout)
+	defer cancel()
+
+	f, err := SpoolCommand(c.cmd(ctx, "file", strconv.Itoa(points), filename), c.config.MaxFileSize)
+	if err != nil {
+		return nopCloser{new(bytes.Reader)}, err
+	}
+	return f, nil
 }
 
 // Answer checks whether an answer is correct.
@@ -134,3 +134,74 @@ func TestOsFsCategory(t *testing.T) {
 		t.Error("Error answer didn't fail")
 	}
 }
+
+func TestSeededCategory(t *testing.T) {
+	fs := NewRecursiveBasePathFs(afero.NewOsFs(), "testdata")
+
+	for _, seed := range []string{"alpha", "beta"} {
+		c := NewFsCategorySeed(fs, "seeded", seed)
+		if p, err := c.Puzzle(1); err != nil {
+			t.Error(err)
+		} else if (len(p.Answers) != 1) || (p.Answers[0] != "answer-"+seed) {
+			t.Error("Seed not passed to mkpuzzle:", p.Answers)
+		}
+		if !c.Answer(1, "answer-"+seed) {
+			t.Error("Seeded answer not accepted for", seed)
+		}
+	}
+
+	c := NewFsCategorySeed(fs, "seeded", "alpha")
+	if c.Answer(1, "answer-beta") {
+		t.Error("Another seed's answer was accepted")
+	}
+}
+
+func TestFsCategorySlugs(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "cat/1/puzzle.md", []byte("---\nanswers: [one]\n---\nOne\n"), 0644)
+	afero.WriteFile(fs, "cat/bonus/puzzle.md", []byte("---\npoints: 5\nanswers: [bonus]\nattachments: [moo.txt]\n---\nBonus\n"), 0644)
+	afero.WriteFile(fs, "cat/bonus/moo.txt", []byte("Moo."), 0644)
+	afero.WriteFile(fs, "cat/also/puzzle.md", []byte("Points: 5\nAnswer: also\n\nAlso\n"), 0644)
+	afero.WriteFile(fs, "cat/nopoints/puzzle.md", []byte("---\nanswers: [none]\n---\nNone\n"), 0644)
+	afero.WriteFile(fs, "cat/lib/helper.py", []byte("pass\n"), 0644)
+	c := NewFsCategory(fs, "cat")
+
+	if inv, err := c.Inventory(); err != nil {
+		t.Error(err)
+	} else if (len(inv) != 1) || (inv[0] != 1) {
+		t.Error("Slug puzzles in inventory:", inv)
+	}
+
+	slugs, err := categorySlugs(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(slugs) != 2) || (slugs["bonus"] != 5) || (slugs["also"] != 5) {
+		t.Error("Wrong slugs:", slugs)
+	}
+
+	if p, err := CategoryPuzzle(c, "bonus"); err != nil {
+		t.Error(err)
+	} else if p.Points != 5 {
+		t.Error("Wrong points:", p.Points)
+	}
+	if p, err := CategoryPuzzle(c, "1"); err != nil {
+		t.Error(err)
+	} else if p.Points != 1 {
+		t.Error("Wrong points:", p.Points)
+	}
+	if !CategoryAnswer(c, "bonus", "bonus") {
+		t.Error("Correct answer not accepted")
+	}
+	if CategoryAnswer(c, "also", "bonus") {
+		t.Error("Answer to another puzzle accepted")
+	}
+	if r, err := CategoryOpen(c, "bonus", "moo.txt"); err != nil {
+		t.Error(err)
+	} else {
+		r.Close()
+	}
+	if _, err := CategoryPuzzle(c, "../cat"); err == nil {
+		t.Error("Invalid slug accepted")
+	}
+}
@@ -0,0 +1,173 @@
+package transpile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v2"
+)
+
+// CategoryConfigFilename is the name of the optional configuration file in a category directory.
+const CategoryConfigFilename = "category.yaml"
+
+// CommandConfig controls how generator programs (mkpuzzle, mkcategory, providers) are run.
+//
+// Zero values mean "use the default".
+// A negative MaxFileSize, CPU, or Memory means no limit.
+type CommandConfig struct {
+	// Timeout is how long the program gets to produce a puzzle, inventory, or answer
+	Timeout time.Duration
+
+	// FileTimeout is how long the program gets to produce an attachment
+	FileTimeout time.Duration
+
+	// MaxFileSize is the largest attachment the program may produce, in bytes
+	MaxFileSize int64
+
+	// Dir is the working directory, relative to the program's directory
+	Dir string
+
+	// Env lists environment variables passed through to the program.
+	// If nil, the program gets the whole environment.
+	// SEED is set for generated puzzles regardless of this list.
+	Env []string
+
+	// CPU limits the program's CPU time
+	CPU time.Duration
+
+	// Memory limits the program's address space, in bytes
+	Memory int64
+
+	// Sandbox isolates the program.
+	// It can't be set in a category's configuration:
+	// puzzle authors don't get to decide how much to trust their own programs.
+	Sandbox SandboxConfig `yaml:"-"`
+}
+
+// DefaultCommandConfig is used for anything not set in a category's configuration.
+//
+// Programs which want different defaults,
+// like a server with its own limits and sandbox,
+// should pass them to NewFsCategoryConfig instead of changing this.
+var DefaultCommandConfig = CommandConfig{
+	Timeout:     2 * time.Second,
+	FileTimeout: 2 * time.Second,
+	MaxFileSize: 1 << 30,
+}
+
+// Merge returns cc, with any zero values filled in from defaults.
+func (cc CommandConfig) Merge(defaults CommandConfig) CommandConfig {
+	if cc.Timeout == 0 {
+		cc.Timeout = defaults.Timeout
+	}
+	if cc.FileTimeout == 0 {
+		cc.FileTimeout = defaults.FileTimeout
+	}
+	if cc.MaxFileSize == 0 {
+		cc.MaxFileSize = defaults.MaxFileSize
+	}
+	if cc.Dir == "" {
+		cc.Dir = defaults.Dir
+	}
+	if cc.Env == nil {
+		cc.Env = defaults.Env
+	}
+	if cc.CPU == 0 {
+		cc.CPU = defaults.CPU
+	}
+	if cc.Memory == 0 {
+		cc.Memory = defaults.Memory
+	}
+	if cc.Sandbox.isZero() {
+		cc.Sandbox = defaults.Sandbox
+	}
+	return cc
+}
+
+// CategoryConfig is what can be set in a category's configuration file.
+type CategoryConfig struct {
+	// CategoryInfo describes the category, for people
+	CategoryInfo `yaml:",inline"`
+
+	// Command controls how generators in this category are run
+	Command CommandConfig
+}
+
+// ReadCategoryConfig reads the configuration file in fs, if there is one.
+// If there isn't, the zero CategoryConfig is returned.
+func ReadCategoryConfig(fs afero.Fs) (CategoryConfig, error) {
+	config := CategoryConfig{}
+	f, err := fs.Open(CategoryConfigFilename)
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return config, err
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.SetStrict(true)
+	if err := decoder.Decode(&config); err != nil {
+		return config, err
+	}
+	switch config.Unlock {
+	case "", UnlockProgressive, UnlockAll:
+	default:
+		return config, fmt.Errorf("unknown unlock policy: %q", config.Unlock)
+	}
+	return config, nil
+}
+
+// Command returns an *exec.Cmd to run program according to cc, inside cc's sandbox.
+//
+// program must be a full path.
+// extraEnv is added to whatever environment cc allows.
+func (cc CommandConfig) Command(ctx context.Context, program string, extraEnv []string, args ...string) *exec.Cmd {
+	name := "./" + path.Base(program)
+	dir := path.Dir(program)
+	if cc.Dir != "" {
+		name = program
+		if path.IsAbs(cc.Dir) {
+			dir = cc.Dir
+		} else {
+			dir = path.Join(dir, cc.Dir)
+		}
+	}
+
+	name, args = cc.limit(name, args)
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = cc.environ(extraEnv)
+	cc.Sandbox.apply(cmd)
+	return cmd
+}
+
+// environ returns the environment for a command,
+// or nil if it should inherit ours unchanged.
+func (cc CommandConfig) environ(extraEnv []string) []string {
+	if (cc.Env == nil) && (extraEnv == nil) {
+		return nil
+	}
+
+	env := make([]string, 0, len(cc.Env)+len(extraEnv))
+	for _, kv := range os.Environ() {
+		if cc.Env == nil {
+			env = append(env, kv)
+			continue
+		}
+		key, _, _ := strings.Cut(kv, "=")
+		for _, allowed := range cc.Env {
+			if key == allowed {
+				env = append(env, kv)
+				break
+			}
+		}
+	}
+	return append(env, extraEnv...)
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package transpile
+
+import (
+	"log"
+)
+
+// limit can't set resource limits on this platform.
+func (cc CommandConfig) limit(name string, args []string) (string, []string) {
+	if (cc.CPU > 0) || (cc.Memory > 0) {
+		log.Println("WARN: CPU and memory limits are not supported on this platform")
+	}
+	return name, args
+}
@@ -0,0 +1,128 @@
+package transpile
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestCommandConfigMerge(t *testing.T) {
+	cc := CommandConfig{Timeout: time.Second, Env: []string{}}.Merge(DefaultCommandConfig)
+	if cc.Timeout != time.Second {
+		t.Error("Timeout overridden by defaults:", cc.Timeout)
+	}
+	if cc.FileTimeout != DefaultCommandConfig.FileTimeout {
+		t.Error("FileTimeout not filled in:", cc.FileTimeout)
+	}
+	if cc.Env == nil {
+		t.Error("Empty Env replaced by defaults")
+	}
+
+	sandboxed := DefaultCommandConfig
+	sandboxed.Sandbox = SandboxConfig{Isolate: true}
+	cc = CommandConfig{MaxFileSize: -1}.Merge(sandboxed)
+	if cc.MaxFileSize != -1 {
+		t.Error("No limit replaced by defaults:", cc.MaxFileSize)
+	}
+	if !cc.Sandbox.Isolate {
+		t.Error("Sandbox not filled in:", cc.Sandbox)
+	}
+}
+
+func TestCommandConfigEnviron(t *testing.T) {
+	t.Setenv("MOTH_TEST_SECRET", "sekrit")
+
+	if env := (CommandConfig{}).environ(nil); env != nil {
+		t.Error("Unrestricted environment with nothing extra should be inherited")
+	}
+
+	env := CommandConfig{Env: []string{"PATH"}}.environ([]string{"SEED=1"})
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "MOTH_TEST_SECRET=") {
+			t.Error("Environment variable not in allow-list was passed through")
+		}
+	}
+	if env[len(env)-1] != "SEED=1" {
+		t.Error("Extra environment variables not added", env)
+	}
+	if os.Getenv("PATH") != "" && !strings.HasPrefix(env[0], "PATH=") {
+		t.Error("Allowed environment variable not passed through", env)
+	}
+}
+
+func TestCommandConfigLimits(t *testing.T) {
+	cc := CommandConfig{CPU: time.Second, Memory: 512 << 20}
+	cmd := cc.Command(context.Background(), "/bin/true", nil)
+	if cmd.Path == "/bin/true" {
+		t.Skip("Resource limits not supported on this platform")
+	}
+	if err := cmd.Run(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadCategoryConfig(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if config, err := ReadCategoryConfig(fs); err != nil {
+		t.Error(err)
+	} else if config.Command.Timeout != 0 {
+		t.Error("Missing configuration file isn't empty")
+	}
+
+	afero.WriteFile(fs, CategoryConfigFilename, []byte("command:\n  timeout: 3s\n  maxfilesize: 12\n"), 0644)
+	if config, err := ReadCategoryConfig(fs); err != nil {
+		t.Error(err)
+	} else if config.Command.Timeout != 3*time.Second {
+		t.Error("Wrong timeout", config.Command.Timeout)
+	} else if config.Command.MaxFileSize != 12 {
+		t.Error("Wrong max file size", config.Command.MaxFileSize)
+	}
+
+	afero.WriteFile(fs, CategoryConfigFilename, []byte("command:\n  maxfilesize: -1\n"), 0644)
+	if config, err := ReadCategoryConfig(fs); err != nil {
+		t.Error(err)
+	} else if config.Command.Merge(DefaultCommandConfig).MaxFileSize != -1 {
+		t.Error("Can't ask for no file size limit:", config.Command.MaxFileSize)
+	}
+
+	afero.WriteFile(fs, CategoryConfigFilename, []byte("command:\n  timout: 3s\n"), 0644)
+	if _, err := ReadCategoryConfig(fs); err == nil {
+		t.Error("Misspelled key accepted")
+	}
+
+	afero.WriteFile(fs, CategoryConfigFilename, []byte("command:\n  sandbox:\n    isolate: false\n"), 0644)
+	if _, err := ReadCategoryConfig(fs); err == nil {
+		t.Error("Category configuration changed the sandbox")
+	}
+}
+
+func TestConfiguredCategory(t *testing.T) {
+	t.Setenv("MOTH_TEST_SECRET", "sekrit")
+	fs := NewRecursiveBasePathFs(afero.NewOsFs(), "testdata")
+	c := NewFsCategory(fs, "configured")
+
+	if p, err := c.Puzzle(1); err != nil {
+		t.Error(err)
+	} else if p.Answers[0] != "secret:" {
+		t.Error("Environment not filtered by category.yaml:", p.Answers)
+	}
+
+	start := time.Now()
+	if _, err := c.Puzzle(2); err == nil {
+		t.Error("Slow generator didn't time out")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Error("Timeout from category.yaml not used:", time.Since(start))
+	}
+
+	// Generators run in the sandbox they're given
+	defaults := DefaultCommandConfig
+	defaults.Sandbox = SandboxConfig{Wrapper: []string{"/nonexistent/moth-sandbox"}}
+	if _, err := NewFsCategoryConfig(fs, "configured", "", defaults).Puzzle(1); err == nil {
+		t.Error("Generator ran outside its sandbox")
+	}
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package transpile
+
+import (
+	"fmt"
+)
+
+// limit wraps name and args in a shell which sets resource limits.
+func (cc CommandConfig) limit(name string, args []string) (string, []string) {
+	ulimits := ""
+	if cc.CPU > 0 {
+		ulimits += fmt.Sprintf("ulimit -t %d; ", int64(cc.CPU.Seconds()+0.5))
+	}
+	if cc.Memory > 0 {
+		ulimits += fmt.Sprintf("ulimit -v %d; ", cc.Memory/1024)
+	}
+	if ulimits == "" {
+		return name, args
+	}
+	return "/bin/sh", append([]string{"-c", ulimits + `exec "$0" "$@"`, name}, args...)
+}
@@ -13,6 +13,12 @@ type Inventory map[string][]int
 
 // FsInventory returns a mapping of category names to puzzle point values.
 func FsInventory(fs afero.Fs) (Inventory, error) {
+	return FsInventoryConfig(fs, DefaultCommandConfig)
+}
+
+// FsInventoryConfig is like FsInventory,
+// with generators run according to defaults instead of DefaultCommandConfig.
+func FsInventoryConfig(fs afero.Fs, defaults CommandConfig) (Inventory, error) {
 	dirEnts, err := afero.ReadDir(fs, "")
 	if err != nil {
 		log.Print(err)
@@ -26,7 +32,7 @@ func FsInventory(fs afero.Fs) (Inventory, error) {
 		}
 		if ent.IsDir() {
 			name := ent.Name()
-			c := NewFsCategory(fs, name)
+			c := NewFsCategoryConfig(fs, name, "", defaults)
 			puzzles, err := c.Inventory()
 			if err != nil {
 				log.Printf("Inventory: %s: %s", name, err)
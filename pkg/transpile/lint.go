@@ -0,0 +1,370 @@
+package transpile
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// Problem is something wrong with a puzzle, found by Lint.
+type Problem struct {
+	// File is the path of the file with the problem, relative to the tree being checked
+	File string
+
+	// Line is the line number in File, or 0 if it isn't known
+	Line int `json:",omitempty"`
+
+	// Message describes the problem
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.File, p.Message)
+}
+
+// linter collects problems.
+type linter struct {
+	problems []Problem
+}
+
+func (l *linter) add(file string, line int, format string, a ...any) {
+	l.problems = append(l.problems, Problem{
+		File:    file,
+		Line:    line,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// sorted returns all collected problems, ordered by file and line.
+func (l *linter) sorted() []Problem {
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		if l.problems[i].File != l.problems[j].File {
+			return l.problems[i].File < l.problems[j].File
+		}
+		return l.problems[i].Line < l.problems[j].Line
+	})
+	return l.problems
+}
+
+// LintTree checks every category in fs, and returns every problem found.
+//
+// Categories are found the same way as FsInventory finds them.
+func LintTree(fs afero.Fs) []Problem {
+	l := new(linter)
+	dirEnts, err := afero.ReadDir(fs, "")
+	if err != nil {
+		l.add(".", 0, "%v", err)
+		return l.problems
+	}
+	for _, ent := range dirEnts {
+		if ent.IsDir() && !strings.HasPrefix(ent.Name(), ".") {
+			l.category(fs, ent.Name())
+		}
+	}
+	return l.sorted()
+}
+
+// Lint checks every puzzle in the category cat of fs,
+// and returns every problem found.
+//
+// Unlike Mothball, Lint carries on after finding a problem.
+func Lint(fs afero.Fs, cat string) []Problem {
+	l := new(linter)
+	l.category(fs, cat)
+	return l.sorted()
+}
+
+func (l *linter) category(fs afero.Fs, cat string) {
+	bfs := NewRecursiveBasePathFs(fs, cat)
+	categoryConfig, err := ReadCategoryConfig(bfs)
+	if err != nil {
+		l.add(path.Join(cat, CategoryConfigFilename), yamlErrorLine(err, 1), "%v", err)
+	}
+	config := categoryConfig.Command.Merge(DefaultCommandConfig)
+
+	c := NewFsCategory(fs, cat)
+	if cc, ok := c.(FsCommandCategory); ok {
+		l.commandCategory(cc, path.Join(cat, "mkcategory"))
+		return
+	}
+
+	ents, err := afero.ReadDir(bfs, "")
+	if err != nil {
+		l.add(path.Join(cat), 0, "%v", err)
+		return
+	}
+	// Servers look for puzzles in the directory named for their point value,
+	// so that directory wins over any other with the same value, like "01".
+	dirs := make(map[int]string)
+	var slugDirs []string
+	for _, ent := range ents {
+		if !ent.IsDir() || strings.HasPrefix(ent.Name(), ".") {
+			continue
+		}
+		dir := path.Join(cat, ent.Name())
+		points, err := strconv.Atoi(ent.Name())
+		if ValidSlug(ent.Name()) {
+			// Slug directories without a puzzle are allowed to hold other things
+			if isPuzzleDir(bfs, ent.Name()) {
+				slugDirs = append(slugDirs, dir)
+			}
+			continue
+		} else if err != nil {
+			l.add(dir, 0, "directory name isn't a point value or slug, so it will be skipped")
+			continue
+		}
+		if other, ok := dirs[points]; !ok || (ent.Name() == strconv.Itoa(points)) {
+			dirs[points] = dir
+			if ok {
+				dir = other
+			}
+		}
+		if dirs[points] != dir {
+			l.add(dir, 0, "duplicate point value: %s is also worth %d points", dirs[points], points)
+		}
+	}
+	for _, dir := range dirs {
+		l.puzzle(fs, dir, config, false)
+	}
+	for _, dir := range slugDirs {
+		l.puzzle(fs, dir, config, true)
+	}
+}
+
+func (l *linter) commandCategory(c FsCommandCategory, filename string) {
+	inv, err := c.Inventory()
+	if err != nil {
+		l.add(filename, 0, "inventory: %v", err)
+		return
+	}
+	seen := make(map[int]bool)
+	for _, points := range inv {
+		if seen[points] {
+			l.add(filename, 0, "inventory: duplicate point value %d", points)
+			continue
+		}
+		seen[points] = true
+
+		puzzle, err := c.Puzzle(points)
+		if err != nil {
+			l.add(filename, 0, "puzzle %d: %v", points, err)
+			continue
+		}
+		prefix := fmt.Sprintf("puzzle %d: ", points)
+		l.generated(puzzle, filename, prefix, func(name string) error {
+			f, err := c.Open(points, name)
+			if err == nil {
+				f.Close()
+			}
+			return err
+		})
+	}
+}
+
+// puzzle checks the puzzle in dir.
+// Puzzles named by slug must say how many points they're worth.
+func (l *linter) puzzle(fs afero.Fs, dir string, config CommandConfig, slug bool) {
+	pfs := NewRecursiveBasePathFs(fs, dir)
+	switch fp := newFsPuzzle(pfs, "", config).(type) {
+	case FsCommandPuzzle:
+		filename := path.Join(dir, "mkpuzzle")
+		puzzle, err := fp.Puzzle()
+		if err != nil {
+			l.add(filename, 0, "%v", err)
+			return
+		}
+		if slug && (puzzle.Points <= 0) {
+			l.add(filename, 0, "no points given, so this slug puzzle will be skipped")
+		}
+		l.generated(puzzle, filename, "", func(name string) error {
+			f, err := fp.Open(name)
+			if err == nil {
+				f.Close()
+			}
+			return err
+		})
+	case FsPuzzle:
+		l.staticPuzzle(fp, dir, slug)
+	}
+}
+
+// generated checks a puzzle produced by mkpuzzle or mkcategory.
+func (l *linter) generated(puzzle Puzzle, filename string, prefix string, open func(string) error) {
+	for _, name := range append(puzzle.Attachments, puzzle.Scripts...) {
+		if err := open(name); err != nil {
+			l.add(filename, 0, "%sattachment %s: %v", prefix, name, err)
+		}
+	}
+	for _, msg := range answerProblems(puzzle.Answers, puzzle.AnswerPattern) {
+		l.add(filename, 0, "%s%s", prefix, msg)
+	}
+	for _, link := range brokenLinks(puzzle.Body, append(puzzle.Attachments, puzzle.Scripts...)) {
+		l.add(filename, 0, "%sbroken link: %s", prefix, link)
+	}
+}
+
+func (l *linter) staticPuzzle(fp FsPuzzle, dir string, slug bool) {
+	src, err := fp.readSource()
+	if err != nil {
+		l.add(dir, 0, "no puzzle.md: %v", err)
+		return
+	}
+	filename := path.Join(dir, src.filename)
+
+	static, err := src.static()
+	if err != nil {
+		line := 0
+		if src.yaml {
+			line = yamlErrorLine(err, src.headerLine)
+		} else if field := strings.TrimPrefix(err.Error(), "unknown header field: "); field != err.Error() {
+			line = src.headerLineOf(field)
+		}
+		l.add(filename, line, "%v", err)
+		return
+	}
+	if slug && (static.Points <= 0) {
+		l.add(filename, src.headerLine, "no points given, so this slug puzzle will be skipped")
+	}
+
+	// Attachments must exist, and every file should be attached
+	referenced := map[string]bool{src.filename: true}
+	var names []string
+	for _, att := range append(static.Attachments, static.Scripts...) {
+		fsPath := att.FilesystemPath
+		if fsPath == "" {
+			fsPath = att.Filename
+		}
+		referenced[fsPath] = true
+		names = append(names, att.Filename)
+		if _, err := fp.fs.Stat(fsPath); err != nil {
+			l.add(filename, src.headerLineOf(fsPath), "missing attachment: %s", fsPath)
+		}
+	}
+	ents, err := afero.ReadDir(fp.fs, "")
+	if err != nil {
+		l.add(dir, 0, "%v", err)
+	}
+	for _, ent := range ents {
+		if ent.IsDir() || strings.HasPrefix(ent.Name(), ".") || referenced[ent.Name()] {
+			continue
+		}
+		l.add(path.Join(dir, ent.Name()), 0, "not listed in attachments or scripts")
+	}
+
+	for _, msg := range answerProblems(static.Answers, static.AnswerPattern) {
+		l.add(filename, src.headerLineOf("answer"), "%s", msg)
+	}
+
+	html := new(strings.Builder)
+	if err := Markdown(strings.NewReader(string(src.body)), html); err != nil {
+		l.add(filename, src.bodyLine, "%v", err)
+		return
+	}
+	for _, link := range brokenLinks(html.String(), names) {
+		l.add(filename, src.bodyLineOf(link), "broken link: %s", link)
+	}
+}
+
+// headerLineOf returns the line number of the first header line containing s, ignoring case,
+// or 0 if there isn't one.
+func (src puzzleSource) headerLineOf(s string) int {
+	return lineOf(string(src.header), s, src.headerLine)
+}
+
+// bodyLineOf returns the line number of the first body line containing s, ignoring case,
+// or 0 if there isn't one.
+func (src puzzleSource) bodyLineOf(s string) int {
+	return lineOf(string(src.body), s, src.bodyLine)
+}
+
+func lineOf(text string, s string, first int) int {
+	s = strings.ToLower(s)
+	for i, line := range strings.Split(text, "\n") {
+		if strings.Contains(strings.ToLower(line), s) {
+			return first + i
+		}
+	}
+	return 0
+}
+
+var yamlLineRe = regexp.MustCompile(`line (\d+)`)
+
+// yamlErrorLine returns the line number mentioned in a YAML error,
+// for a document which starts at line first.
+// It returns 0 if no line number is mentioned.
+func yamlErrorLine(err error, first int) int {
+	m := yamlLineRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return first + n - 1
+}
+
+// answerProblems checks a puzzle's answers against each other and its answer pattern.
+func answerProblems(answers []string, pattern string) []string {
+	var problems []string
+	if len(answers) == 0 {
+		problems = append(problems, "no answers")
+	}
+	for _, answer := range answers {
+		if strings.TrimSpace(answer) == "" {
+			problems = append(problems, "empty answer")
+		}
+	}
+	if pattern == "" {
+		return problems
+	}
+
+	// Browsers require the whole answer to match the pattern
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return append(problems, fmt.Sprintf("answer pattern: %v", err))
+	}
+	for _, answer := range answers {
+		if (strings.TrimSpace(answer) != "") && !re.MatchString(answer) {
+			problems = append(problems, fmt.Sprintf("answer %q doesn't match pattern %q", answer, pattern))
+		}
+	}
+	return problems
+}
+
+var linkRe = regexp.MustCompile(`(?:href|src)="([^"]*)"`)
+
+// brokenLinks returns relative links in html which don't lead to one of attachments.
+//
+// Puzzles are served from the same directory as their attachments,
+// so those are the only files a relative link can reach.
+func brokenLinks(html string, attachments []string) []string {
+	var broken []string
+	for _, m := range linkRe.FindAllStringSubmatch(html, -1) {
+		link := m[1]
+		u, err := url.Parse(link)
+		if err != nil {
+			broken = append(broken, link)
+			continue
+		}
+		if (u.Scheme != "") || (u.Host != "") || (u.Path == "") || strings.HasPrefix(u.Path, "/") || strings.HasPrefix(u.Path, "..") {
+			continue
+		}
+		found := false
+		for _, name := range attachments {
+			if path.Clean(u.Path) == name {
+				found = true
+			}
+		}
+		if !found {
+			broken = append(broken, link)
+		}
+	}
+	return broken
+}
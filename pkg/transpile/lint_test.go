@@ -0,0 +1,107 @@
+package transpile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestLint(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "cat/1/puzzle.md", []byte(`---
+answers: [moo]
+attachments: [moo.txt]
+---
+See [the file](moo.txt).
+`), 0644)
+	afero.WriteFile(fs, "cat/1/moo.txt", []byte("Moo."), 0644)
+	afero.WriteFile(fs, "cat/2/puzzle.md", []byte(`---
+answers: [moo, ""]
+answerpattern: "[0-9]+"
+attachments: [missing.txt]
+---
+See [this](elsewhere.txt).
+`), 0644)
+	afero.WriteFile(fs, "cat/2/stray.txt", []byte("Nobody attached me"), 0644)
+	afero.WriteFile(fs, "cat/02/puzzle.md", []byte("---\nanswers: [a]\n---\n"), 0644)
+	afero.WriteFile(fs, "cat/3/puzzle.md", []byte(`---
+answers: [moo]
+colour: mauve
+---
+`), 0644)
+	afero.WriteFile(fs, "cat/4/puzzle.md", []byte(`Author: neale
+Flavour: strawberry
+
+Body
+`), 0644)
+	afero.WriteFile(fs, "cat/notes/README", []byte("Not a puzzle"), 0644)
+	afero.WriteFile(fs, "cat/Notes/README", []byte("Not a puzzle either"), 0644)
+	afero.WriteFile(fs, "cat/bonus/puzzle.md", []byte(`---
+points: 2
+answers: [moo]
+attachments: [moo.txt]
+---
+See [the file](moo.txt).
+`), 0644)
+	afero.WriteFile(fs, "cat/bonus/moo.txt", []byte("Moo."), 0644)
+	afero.WriteFile(fs, "cat/pointless/puzzle.md", []byte(`---
+answers: [moo]
+---
+See [this](elsewhere.txt).
+`), 0644)
+
+	problems := Lint(fs, "cat")
+	expected := []struct {
+		file    string
+		line    int
+		message string
+	}{
+		{"cat/02", 0, "duplicate point value"},
+		{"cat/2/puzzle.md", 4, "missing attachment: missing.txt"},
+		{"cat/2/puzzle.md", 2, "empty answer"},
+		{"cat/2/puzzle.md", 2, `answer "moo" doesn't match pattern`},
+		{"cat/2/puzzle.md", 6, "broken link: elsewhere.txt"},
+		{"cat/2/stray.txt", 0, "not listed in attachments"},
+		{"cat/3/puzzle.md", 3, "field colour not found"},
+		{"cat/4/puzzle.md", 2, "unknown header field: flavour"},
+		{"cat/Notes", 0, "isn't a point value or slug"},
+		{"cat/pointless/puzzle.md", 2, "no points given"},
+		{"cat/pointless/puzzle.md", 4, "broken link: elsewhere.txt"},
+	}
+	for _, e := range expected {
+		found := false
+		for _, p := range problems {
+			if (p.File == e.file) && (p.Line == e.line) && strings.Contains(p.Message, e.message) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Missing problem: %s:%d: %s", e.file, e.line, e.message)
+		}
+	}
+	for _, p := range problems {
+		if strings.HasPrefix(p.File, "cat/1/") || strings.HasPrefix(p.File, "cat/bonus/") || strings.HasPrefix(p.File, "cat/notes") {
+			t.Error("Problem found with a good puzzle:", p)
+		}
+	}
+	if len(problems) != len(expected) {
+		for _, p := range problems {
+			t.Log(p)
+		}
+		t.Error("Wrong number of problems")
+	}
+
+	if problems := LintTree(fs); len(problems) != len(expected) {
+		t.Error("Tree had different problems than its only category:", problems)
+	}
+}
+
+func TestLintGenerated(t *testing.T) {
+	fs := NewRecursiveBasePathFs(afero.NewOsFs(), "testdata")
+	for _, p := range Lint(fs, "generated") {
+		if !strings.HasPrefix(p.File, "generated/mkcategory") {
+			t.Error("Wrong file for generated category problem:", p)
+		}
+	}
+}
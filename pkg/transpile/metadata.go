@@ -0,0 +1,138 @@
+package transpile
+
+import (
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+// MothballFormat is the newest version of the mothball format written by MothballWithOptions.
+//
+// Format 1 mothballs have no mothball.json.
+// Format 3 mothballs have slug puzzles, or tokens.
+// Mothballs without slug puzzles are written as format 2,
+// so servers which don't know about slugs can still read them.
+const MothballFormat = 3
+
+// MetadataFilename is the name of the metadata file inside a mothball.
+const MetadataFilename = "mothball.json"
+
+// PuzzleListFilename is the name of the puzzle list inside a mothball.
+//
+// Mothballs also have puzzles.txt, with just the point values,
+// for older servers.
+const PuzzleListFilename = "puzzles.json"
+
+// Unlock policies, saying when teams can see a category's puzzles
+const (
+	// UnlockProgressive opens every puzzle worth no more than the most valuable one solved,
+	// plus the next one.
+	// This is the default.
+	UnlockProgressive = "progressive"
+
+	// UnlockAll opens every puzzle from the start
+	UnlockAll = "all"
+)
+
+// CategoryInfo describes a category, for people.
+//
+// It's read from the category's configuration file,
+// and stored in mothballs.
+type CategoryInfo struct {
+	// Title is the category's name, for display
+	Title string `json:",omitempty"`
+
+	// Description says what the category is about
+	Description string `json:",omitempty"`
+
+	// Authors names everybody who wrote the category
+	Authors []string `json:",omitempty"`
+
+	// Unlock is the policy for unlocking puzzles: UnlockProgressive or UnlockAll.
+	// Empty means UnlockProgressive.
+	Unlock string `json:",omitempty"`
+
+	// Weight orders categories for display: lighter categories go first.
+	// Categories of the same weight are ordered by name.
+	Weight int `json:",omitempty"`
+
+	// Icon is the URL of an image to show next to the title,
+	// relative to the theme
+	Icon string `json:",omitempty"`
+
+	// Tags are short labels, like "forensics" or "beginner"
+	Tags []string `json:",omitempty"`
+}
+
+// IsZero returns true if ci doesn't describe anything.
+func (ci CategoryInfo) IsZero() bool {
+	return reflect.DeepEqual(ci, CategoryInfo{})
+}
+
+// Describer is implemented by categories which can describe themselves.
+type Describer interface {
+	Describe() CategoryInfo
+}
+
+// MothballMetadata is stored in a mothball's mothball.json.
+type MothballMetadata struct {
+	// Format is the version of the mothball format
+	Format int
+
+	// Category describes the category
+	Category CategoryInfo
+
+	// Tokens is true for token mothballs,
+	// which have a list of tokens instead of puzzles
+	Tokens bool `json:",omitempty"`
+
+	// Built is when the mothball was built.
+	// So that builds are reproducible, it's only recorded when a build time is given.
+	Built *time.Time `json:",omitempty"`
+
+	// Transpiler identifies the program which built the mothball
+	Transpiler string `json:",omitempty"`
+}
+
+// PuzzleEntry describes one puzzle in a mothball's puzzle list.
+type PuzzleEntry struct {
+	// Slug names the puzzle, if it isn't named by its point value
+	Slug string `json:",omitempty"`
+
+	// Points is how many points the puzzle is worth
+	Points int
+
+	// Title is the puzzle's title, if it has one
+	Title string `json:",omitempty"`
+}
+
+// ID returns the puzzle's ID, which is also the name of its directory.
+func (pe PuzzleEntry) ID() string {
+	return PuzzleID(pe.Points, pe.Slug)
+}
+
+// sortPuzzleList orders puzzles by point value, then by slug.
+func sortPuzzleList(list []PuzzleEntry) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Points != list[j].Points {
+			return list[i].Points < list[j].Points
+		}
+		return list[i].Slug < list[j].Slug
+	})
+}
+
+// TranspilerVersion identifies this version of the transpiler.
+func TranspilerVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path == "github.com/dirtbags/moth/v4" {
+			return "moth " + info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/dirtbags/moth/v4" {
+				return "moth " + dep.Version
+			}
+		}
+	}
+	return "moth"
+}
@@ -2,89 +2,431 @@ package transpile
 
 import (
 	"archive/zip"
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
+	"hash/fnv"
 	"io"
 	"os/exec"
+	"sort"
+	"strconv"
+	"time"
 )
 
+// MothballOptions controls what goes into a mothball.
+type MothballOptions struct {
+	// Variants are per-team variants of the category being packaged,
+	// usually generated with NewFsCategorySeed.
+	// Only puzzles which differ from the base category are stored for each variant.
+	Variants []Category
+
+	// Teams maps team IDs to indexes into Variants.
+	// Teams not listed here are assigned a variant by VariantIndex.
+	Teams map[string]int
+
+	// Source identifies what the mothball was built from, usually a commit ID.
+	// It is recorded in the manifest.
+	Source string
+
+	// Modified is the modification time given to every file in the mothball.
+	// If zero, MothballEpoch is used.
+	// If set, it's also recorded in mothball.json as the build time.
+	Modified time.Time
+}
+
+// MothballEpoch is the modification time given to files in a mothball,
+// unless MothballOptions says otherwise.
+// Zip files can't record anything earlier.
+var MothballEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ManifestFilename is the name of the manifest inside a mothball.
+const ManifestFilename = "manifest.json"
+
+// Manifest lists every file in a mothball.
+type Manifest struct {
+	// Source identifies what the mothball was built from, usually a commit ID
+	Source string `json:",omitempty"`
+
+	// Files maps each file name to the hex SHA-256 of its contents
+	Files map[string]string
+}
+
+// Version returns a hash of the contents of every file listed in the manifest,
+// except mothball.json.
+//
+// Two mothballs with the same puzzles have the same version,
+// no matter what they were built from, or when.
+func (m Manifest) Version() string {
+	names := make([]string, 0, len(m.Files))
+	for name := range m.Files {
+		if name != MetadataFilename {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\x00%s\x00", name, m.Files[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// VariantIndex returns the variant assigned to teamID,
+// when there are n variants and teamID has no explicit assignment.
+func VariantIndex(teamID string, n int) int {
+	if n <= 0 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(teamID))
+	return int(h.Sum32() % uint32(n))
+}
+
 // Mothball packages a Category up for a production server run.
 func Mothball(c Category, w io.Writer) error {
-	zf := zip.NewWriter(w)
+	return MothballWithOptions(c, w, MothballOptions{})
+}
 
-	inv, err := c.Inventory()
+// mothballWriter writes files into a mothball,
+// recording each one's hash in a manifest.
+type mothballWriter struct {
+	zf       *zip.Writer
+	modified time.Time
+	manifest Manifest
+
+	// The file being written, and its running hash
+	current string
+	hash    hash.Hash
+}
+
+// create starts a new file in the mothball.
+// Files end up in the order they're created,
+// so callers must always create them in the same order
+// for the same input to produce the same mothball.
+func (mw *mothballWriter) create(name string) (io.Writer, error) {
+	mw.finish()
+	w, err := mw.zf.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: mw.modified,
+	})
+	if err != nil {
+		return nil, err
+	}
+	mw.current = name
+	mw.hash = sha256.New()
+	return io.MultiWriter(w, mw.hash), nil
+}
+
+// finish records the hash of the file being written.
+func (mw *mothballWriter) finish() {
+	if mw.hash != nil {
+		mw.manifest.Files[mw.current] = hex.EncodeToString(mw.hash.Sum(nil))
+		mw.hash = nil
+	}
+}
+
+// close writes the manifest, and closes the mothball.
+func (mw *mothballWriter) close() error {
+	mw.finish()
+	buf, err := json.MarshalIndent(mw.manifest, "", "  ")
 	if err != nil {
 		return err
 	}
+	// The manifest can't list itself, so it goes last
+	w, err := mw.zf.CreateHeader(&zip.FileHeader{
+		Name:     ManifestFilename,
+		Method:   zip.Deflate,
+		Modified: mw.modified,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	return mw.zf.Close()
+}
 
-	puzzlesTxt := new(bytes.Buffer)
-	answersTxt := new(bytes.Buffer)
+// sortedByName returns a copy of inv ordered by the names of the puzzle directories,
+// which is the order they appear in the mothball.
+func sortedByName(inv []int) []int {
+	sorted := append([]int{}, inv...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return strconv.Itoa(sorted[i]) < strconv.Itoa(sorted[j])
+	})
+	return sorted
+}
 
-	for _, points := range inv {
-		fmt.Fprintln(puzzlesTxt, points)
+// MothballWithOptions packages a Category up for a production server run,
+// including any per-team variants described in opts.
+//
+// Variants are stored under variants/N/,
+// with their own answers.txt,
+// and variants.txt maps team IDs to variants.
+//
+// The category's description, and details of the build,
+// are written to mothball.json.
+// puzzles.json lists every puzzle's point value and title.
+//
+// The same input always produces the same bytes:
+// puzzle directories (sorted by name), answers.txt, metadata and puzzle lists,
+// and then any variants, are written in that order with the same modification time,
+// followed by manifest.json, which lists the hash of every file.
+func MothballWithOptions(c Category, w io.Writer, opts MothballOptions) error {
+	mw := &mothballWriter{
+		zf:       zip.NewWriter(w),
+		modified: opts.Modified,
+		manifest: Manifest{
+			Source: opts.Source,
+			Files:  make(map[string]string),
+		},
+	}
+	if mw.modified.IsZero() {
+		mw.modified = MothballEpoch
+	}
 
-		puzzlePath := fmt.Sprintf("%d/puzzle.json", points)
-		pw, err := zf.Create(puzzlePath)
-		if err != nil {
+	puzzleList, err := categoryPuzzleList(c)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(puzzleList))
+	for i, entry := range puzzleList {
+		ids[i] = entry.ID()
+	}
+
+	// Puzzle directories are written in order of their names
+	dirs := append([]string{}, ids...)
+	sort.Strings(dirs)
+
+	answers := make(map[string][]string)
+	titles := make(map[string]string)
+	digests := make(map[string]string)
+	for _, id := range dirs {
+		if answers[id], titles[id], err = writePuzzle(mw, c, "", id); err != nil {
 			return err
 		}
-		puzzle, err := c.Puzzle(points)
-		if err != nil {
-			return fmt.Errorf("Puzzle %d: %s", points, err)
+		if len(opts.Variants) > 0 {
+			if digests[id], err = puzzleDigest(c, id); err != nil {
+				return err
+			}
 		}
+	}
+
+	if err := writeAnswers(mw, "answers.txt", ids, answers); err != nil {
+		return err
+	}
 
-		// Record answers in answers.txt
-		for _, answer := range puzzle.Answers {
-			fmt.Fprintln(answersTxt, points, answer)
+	metadata := MothballMetadata{
+		Format:     2,
+		Transpiler: TranspilerVersion(),
+	}
+	for i, entry := range puzzleList {
+		puzzleList[i].Title = titles[entry.ID()]
+		if entry.Slug != "" {
+			// Older servers would miss slug puzzles
+			metadata.Format = MothballFormat
 		}
+	}
+	if d, ok := c.(Describer); ok {
+		metadata.Category = d.Describe()
+	}
+	if !opts.Modified.IsZero() {
+		built := opts.Modified.UTC()
+		metadata.Built = &built
+	}
+	mdf, err := mw.create(MetadataFilename)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(mdf).Encode(metadata); err != nil {
+		return err
+	}
 
-		// Remove answers and debugging from puzzle object
-		puzzle.Answers = []string{}
-		puzzle.Debug.Errors = []string{}
-		puzzle.Debug.Hints = []string{}
-		puzzle.Debug.Log = []string{}
-		puzzle.Debug.Summary = ""
+	plf, err := mw.create(PuzzleListFilename)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(plf).Encode(puzzleList); err != nil {
+		return err
+	}
 
-		// Write out Puzzle object
-		penc := json.NewEncoder(pw)
-		if err := penc.Encode(puzzle); err != nil {
-			return fmt.Errorf("Puzzle %d: %s", points, err)
+	// puzzles.txt is for older servers, which only know about point values
+	pf, err := mw.create("puzzles.txt")
+	if err != nil {
+		return err
+	}
+	for _, entry := range puzzleList {
+		if entry.Slug == "" {
+			fmt.Fprintln(pf, entry.Points)
 		}
+	}
 
-		// Write out all attachments and scripts
-		attachments := append(puzzle.Attachments, puzzle.Scripts...)
-		for _, att := range attachments {
-			attPath := fmt.Sprintf("%d/%s", points, att)
-			aw, err := zf.Create(attPath)
-			if err != nil {
-				return err
-			}
-			ar, err := c.Open(points, att)
-			if exerr, ok := err.(*exec.ExitError); ok {
-				return fmt.Errorf("Puzzle %d: %s: %s: %s", points, att, err, string(exerr.Stderr))
-			} else if err != nil {
-				return fmt.Errorf("Puzzle %d: %s: %s", points, att, err)
+	if len(opts.Variants) > 0 {
+		vf, err := mw.create("variants.txt")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(vf, len(opts.Variants))
+		teamIDs := make([]string, 0, len(opts.Teams))
+		for teamID := range opts.Teams {
+			teamIDs = append(teamIDs, teamID)
+		}
+		sort.Strings(teamIDs)
+		for _, teamID := range teamIDs {
+			fmt.Fprintln(vf, teamID, opts.Teams[teamID])
+		}
+
+		for _, v := range sortedByName(indexes(len(opts.Variants))) {
+			variant := opts.Variants[v]
+			prefix := fmt.Sprintf("variants/%d/", v)
+			variantAnswers := make(map[string][]string)
+			for _, id := range dirs {
+				digest, err := puzzleDigest(variant, id)
+				if err != nil {
+					return fmt.Errorf("Variant %d: %v", v, err)
+				}
+				if digest == digests[id] {
+					// Same for everybody: the base puzzle will do
+					continue
+				}
+				if variantAnswers[id], _, err = writePuzzle(mw, variant, prefix, id); err != nil {
+					return fmt.Errorf("Variant %d: %v", v, err)
+				}
 			}
-			if _, err := io.Copy(aw, ar); err != nil {
-				return fmt.Errorf("Puzzle %d: %s: %s", points, att, err)
+			if err := writeAnswers(mw, prefix+"answers.txt", ids, variantAnswers); err != nil {
+				return err
 			}
 		}
 	}
 
-	pf, err := zf.Create("puzzles.txt")
+	return mw.close()
+}
+
+// categoryPuzzleList lists every puzzle in c, ordered by point value,
+// then by slug.
+// Puzzles named by point value go before slug puzzles worth the same.
+func categoryPuzzleList(c Category) ([]PuzzleEntry, error) {
+	inv, err := c.Inventory()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	slugs, err := categorySlugs(c)
+	if err != nil {
+		return nil, err
 	}
-	puzzlesTxt.WriteTo(pf)
+	list := make([]PuzzleEntry, 0, len(inv)+len(slugs))
+	for _, points := range inv {
+		list = append(list, PuzzleEntry{Points: points})
+	}
+	for slug, points := range slugs {
+		list = append(list, PuzzleEntry{Slug: slug, Points: points})
+	}
+	sortPuzzleList(list)
+	return list, nil
+}
+
+// indexes returns the integers from 0 to n-1.
+func indexes(n int) []int {
+	ret := make([]int, n)
+	for i := range ret {
+		ret[i] = i
+	}
+	return ret
+}
 
-	af, err := zf.Create("answers.txt")
+// writeAnswers writes an answers.txt file, in the order of ids.
+// Each line has a puzzle ID and an answer.
+func writeAnswers(mw *mothballWriter, filename string, ids []string, answers map[string][]string) error {
+	af, err := mw.create(filename)
 	if err != nil {
 		return err
 	}
-	answersTxt.WriteTo(af)
+	for _, id := range ids {
+		for _, answer := range answers[id] {
+			fmt.Fprintln(af, id, answer)
+		}
+	}
+	return nil
+}
 
-	zf.Close()
+// writePuzzle writes one puzzle, and its attachments, into mw under prefix,
+// and returns the puzzle's answers and title.
+func writePuzzle(mw *mothballWriter, c Category, prefix string, id string) ([]string, string, error) {
+	puzzle, err := CategoryPuzzle(c, id)
+	if err != nil {
+		return nil, "", fmt.Errorf("Puzzle %s: %s", id, err)
+	}
+	answers := puzzle.Answers
 
-	return nil
+	// Remove answers and debugging from puzzle object
+	puzzle.RemoveSecrets()
+
+	// puzzle.json goes in with all attachments and scripts, in sorted order
+	names := []string{"puzzle.json"}
+	seen := map[string]bool{"puzzle.json": true}
+	for _, att := range append(puzzle.Attachments, puzzle.Scripts...) {
+		if !seen[att] {
+			seen[att] = true
+			names = append(names, att)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		w, err := mw.create(prefix + id + "/" + name)
+		if err != nil {
+			return nil, "", err
+		}
+		if name == "puzzle.json" {
+			// Write out Puzzle object.
+			// Maps like Extra are encoded with sorted keys, so this is stable.
+			if err := json.NewEncoder(w).Encode(puzzle); err != nil {
+				return nil, "", fmt.Errorf("Puzzle %s: %s", id, err)
+			}
+			continue
+		}
+
+		ar, err := CategoryOpen(c, id, name)
+		if exerr, ok := err.(*exec.ExitError); ok {
+			return nil, "", fmt.Errorf("Puzzle %s: %s: %s: %s", id, name, err, string(exerr.Stderr))
+		} else if err != nil {
+			return nil, "", fmt.Errorf("Puzzle %s: %s: %s", id, name, err)
+		}
+		_, err = io.Copy(w, ar)
+		ar.Close()
+		if err != nil {
+			return nil, "", fmt.Errorf("Puzzle %s: %s: %s", id, name, err)
+		}
+	}
+
+	return answers, puzzle.Title, nil
+}
+
+// puzzleDigest returns a digest of everything about a puzzle,
+// including answers and attachment contents.
+func puzzleDigest(c Category, id string) (string, error) {
+	h := sha256.New()
+	puzzle, err := CategoryPuzzle(c, id)
+	if err != nil {
+		return "", fmt.Errorf("Puzzle %s: %s", id, err)
+	}
+	if err := json.NewEncoder(h).Encode(puzzle); err != nil {
+		return "", fmt.Errorf("Puzzle %s: %s", id, err)
+	}
+	for _, att := range append(puzzle.Attachments, puzzle.Scripts...) {
+		ar, err := CategoryOpen(c, id, att)
+		if err != nil {
+			return "", fmt.Errorf("Puzzle %s: %s: %s", id, att, err)
+		}
+		fmt.Fprintf(h, "\x00%s\x00", att)
+		_, err = io.Copy(h, ar)
+		ar.Close()
+		if err != nil {
+			return "", fmt.Errorf("Puzzle %s: %s: %s", id, att, err)
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
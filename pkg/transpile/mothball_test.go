@@ -3,11 +3,17 @@ package transpile
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"runtime"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/spf13/afero/zipfs"
@@ -52,3 +58,302 @@ func TestMothballsOsFs(t *testing.T) {
 		}
 	}
 }
+
+func TestMothballVariants(t *testing.T) {
+	fs := NewRecursiveBasePathFs(afero.NewOsFs(), "testdata")
+	opts := MothballOptions{
+		Variants: []Category{
+			NewFsCategorySeed(fs, "seeded", "0"),
+			NewFsCategorySeed(fs, "seeded", "1"),
+		},
+		Teams: map[string]int{"alpha": 1},
+	}
+	mb := new(bytes.Buffer)
+	if err := MothballWithOptions(NewFsCategory(fs, "seeded"), mb, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	mbr, err := zip.NewReader(bytes.NewReader(mb.Bytes()), int64(mb.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zfs := zipfs.New(mbr)
+
+	for v := 0; v < 2; v++ {
+		if _, err := zfs.Stat(fmt.Sprintf("variants/%d/1/puzzle.json", v)); err != nil {
+			t.Error("Missing variant", v, err)
+		}
+		if buf, err := afero.ReadFile(zfs, fmt.Sprintf("variants/%d/answers.txt", v)); err != nil {
+			t.Error(err)
+		} else if string(buf) != fmt.Sprintf("1 answer-%d\n", v) {
+			t.Errorf("Wrong answers for variant %d: %#v", v, string(buf))
+		}
+	}
+	if buf, err := afero.ReadFile(zfs, "variants.txt"); err != nil {
+		t.Error(err)
+	} else if string(buf) != "2\nalpha 1\n" {
+		t.Errorf("Wrong variants.txt: %#v", string(buf))
+	}
+
+	// Static puzzles are the same for everyone, so they shouldn't be duplicated
+	static := NewFsCategory(fs, "static")
+	opts.Variants = []Category{NewFsCategorySeed(fs, "static", "0")}
+	mb.Reset()
+	if err := MothballWithOptions(static, mb, opts); err != nil {
+		t.Fatal(err)
+	}
+	mbr, err = zip.NewReader(bytes.NewReader(mb.Bytes()), int64(mb.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range mbr.File {
+		if strings.HasPrefix(f.Name, "variants/0/") && (f.Name != "variants/0/answers.txt") {
+			t.Error("Static puzzle stored as a variant:", f.Name)
+		}
+	}
+}
+
+func TestVariantIndex(t *testing.T) {
+	if VariantIndex("alpha", 0) != 0 {
+		t.Error("Zero variants should always give variant 0")
+	}
+	for _, teamID := range []string{"alpha", "beta", "gamma"} {
+		v := VariantIndex(teamID, 3)
+		if (v < 0) || (v >= 3) {
+			t.Error("Variant out of range:", v)
+		}
+		if v != VariantIndex(teamID, 3) {
+			t.Error("Variant assignment isn't stable")
+		}
+	}
+}
+
+func TestMothballReproducible(t *testing.T) {
+	fs := NewRecursiveBasePathFs(afero.NewOsFs(), "testdata")
+	build := func(source string) []byte {
+		opts := MothballOptions{
+			Variants: []Category{
+				NewFsCategorySeed(fs, "seeded", "0"),
+				NewFsCategorySeed(fs, "seeded", "1"),
+			},
+			Source: source,
+		}
+		mb := new(bytes.Buffer)
+		if err := MothballWithOptions(NewFsCategory(fs, "seeded"), mb, opts); err != nil {
+			t.Fatal(err)
+		}
+		return mb.Bytes()
+	}
+
+	a := build("abc123")
+	if b := build("abc123"); !bytes.Equal(a, b) {
+		t.Error("Two builds of the same input differ")
+	}
+
+	mbr, err := zip.NewReader(bytes.NewReader(a), int64(len(a)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range mbr.File {
+		if !f.Modified.Equal(MothballEpoch) {
+			t.Error("Wrong modification time:", f.Name, f.Modified)
+		}
+		names = append(names, f.Name)
+	}
+	if names[len(names)-1] != ManifestFilename {
+		t.Error("Manifest isn't last:", names)
+	}
+	if !sort.StringsAreSorted(names[:len(names)-1]) {
+		t.Error("Entries aren't sorted:", names)
+	}
+
+	mr, err := NewMothballReader(bytes.NewReader(a), int64(len(a)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := mr.Manifest()
+	if manifest.Source != "abc123" {
+		t.Error("Wrong source:", manifest.Source)
+	}
+	if len(manifest.Files) != len(names)-1 {
+		t.Error("Manifest doesn't list every file:", manifest.Files)
+	}
+	for _, f := range mbr.File[:len(names)-1] {
+		buf, err := afero.ReadFile(mr, f.Name)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if sum := sha256.Sum256(buf); manifest.Files[f.Name] != hex.EncodeToString(sum[:]) {
+			t.Error("Wrong hash for", f.Name)
+		}
+	}
+
+	// The version only depends on contents
+	b := build("def456")
+	mr2, err := NewMothballReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (mr.Version() == "") || (mr.Version() != mr2.Version()) {
+		t.Error("Version depends on source commit:", mr.Version(), mr2.Version())
+	}
+}
+
+func TestMothballMetadata(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "cat/category.yaml", []byte("title: The Cat\ndescription: Meow\nauthors: [Tom]\nunlock: all\nweight: -3\nicon: cat.svg\ntags: [felines, easy]\n"), 0644)
+	afero.WriteFile(fs, "cat/1/puzzle.md", []byte("---\nanswers: [a]\n---\nBody\n"), 0644)
+
+	read := func(opts MothballOptions) *MothballReader {
+		mb := new(bytes.Buffer)
+		if err := MothballWithOptions(NewFsCategory(fs, "cat"), mb, opts); err != nil {
+			t.Fatal(err)
+		}
+		mr, err := NewMothballReader(bytes.NewReader(mb.Bytes()), int64(mb.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mr
+	}
+
+	built := time.Date(2020, 2, 2, 0, 0, 0, 0, time.UTC)
+	mr := read(MothballOptions{Modified: built})
+	metadata := mr.Metadata()
+	if metadata.Format != 2 {
+		t.Error("Wrong format for a mothball without slugs:", metadata.Format)
+	}
+	if (metadata.Category.Title != "The Cat") || (metadata.Category.Description != "Meow") || (metadata.Category.Unlock != UnlockAll) {
+		t.Error("Wrong category:", metadata.Category)
+	}
+	if (len(metadata.Category.Authors) != 1) || (metadata.Category.Authors[0] != "Tom") {
+		t.Error("Wrong authors:", metadata.Category.Authors)
+	}
+	if (metadata.Category.Weight != -3) || (metadata.Category.Icon != "cat.svg") {
+		t.Error("Wrong display settings:", metadata.Category)
+	}
+	if (len(metadata.Category.Tags) != 2) || (metadata.Category.Tags[1] != "easy") {
+		t.Error("Wrong tags:", metadata.Category.Tags)
+	}
+	if (metadata.Built == nil) || !metadata.Built.Equal(built) {
+		t.Error("Wrong build time:", metadata.Built)
+	}
+	if metadata.Transpiler == "" {
+		t.Error("No transpiler version")
+	}
+
+	// Build time doesn't change the content version
+	if other := read(MothballOptions{}); other.Version() != mr.Version() {
+		t.Error("Build time changed the version")
+	} else if other.Metadata().Built != nil {
+		t.Error("Build time recorded without being given")
+	}
+
+	afero.WriteFile(fs, "cat/category.yaml", []byte("unlock: whenever\n"), 0644)
+	if _, err := ReadCategoryConfig(NewRecursiveBasePathFs(fs, "cat")); err == nil {
+		t.Error("Unknown unlock policy accepted")
+	}
+
+	// Mothballs from the future can't be read
+	mb := new(bytes.Buffer)
+	zw := zip.NewWriter(mb)
+	w, _ := zw.Create(MetadataFilename)
+	fmt.Fprintf(w, `{"Format": %d}`, MothballFormat+1)
+	zw.Close()
+	if _, err := NewMothballReader(bytes.NewReader(mb.Bytes()), int64(mb.Len())); err == nil {
+		t.Error("Newer mothball format accepted")
+	}
+}
+
+func TestMothballTitles(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "cat/1/puzzle.md", []byte("---\ntitle: One\nanswers: [a]\n---\nBody\n"), 0644)
+	afero.WriteFile(fs, "cat/2/puzzle.md", []byte("Title: Two\nAnswer: a\n\nBody\n"), 0644)
+	afero.WriteFile(fs, "cat/3/puzzle.md", []byte("---\nanswers: [a]\nextra:\n  title: Three\n---\nBody\n"), 0644)
+	afero.WriteFile(fs, "cat/4/puzzle.md", []byte("---\nanswers: [a]\n---\nBody\n"), 0644)
+
+	mb := new(bytes.Buffer)
+	if err := Mothball(NewFsCategory(fs, "cat"), mb); err != nil {
+		t.Fatal(err)
+	}
+	mr, err := NewMothballReader(bytes.NewReader(mb.Bytes()), int64(mb.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	titles := mr.Titles()
+	if len(titles) != 3 {
+		t.Error("Wrong titles:", titles)
+	}
+	for id, title := range map[string]string{"1": "One", "2": "Two", "3": "Three"} {
+		if titles[id] != title {
+			t.Errorf("Puzzle %s: wanted title %q, got %q", id, title, titles[id])
+		}
+	}
+
+	if puzzle, err := mr.Puzzle(-1, "1"); err != nil {
+		t.Error(err)
+	} else if puzzle.Title != "One" {
+		t.Error("Wrong title in puzzle.json:", puzzle.Title)
+	}
+}
+
+func TestMothballSlugs(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "cat/1/puzzle.md", []byte("---\nanswers: [one]\n---\nOne\n"), 0644)
+	afero.WriteFile(fs, "cat/bonus/puzzle.md", []byte("---\npoints: 5\ntitle: Bonus\nanswers: [bonus]\n---\nBonus\n"), 0644)
+	afero.WriteFile(fs, "cat/also/puzzle.md", []byte("Points: 5\nAnswer: also\n\nAlso\n"), 0644)
+	afero.WriteFile(fs, "cat/lib/helper.py", []byte("pass\n"), 0644)
+
+	mb := new(bytes.Buffer)
+	if err := Mothball(NewFsCategory(fs, "cat"), mb); err != nil {
+		t.Fatal(err)
+	}
+	mr, err := NewMothballReader(bytes.NewReader(mb.Bytes()), int64(mb.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mr.Metadata().Format != 3 {
+		t.Error("Wrong format for a mothball with slugs:", mr.Metadata().Format)
+	}
+
+	list, err := mr.PuzzleList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, entry := range list {
+		ids = append(ids, entry.ID())
+	}
+	if strings.Join(ids, " ") != "1 also bonus" {
+		t.Error("Wrong puzzle list:", ids)
+	}
+
+	// Older servers only see numbered puzzles
+	if inv, err := mr.Inventory(); err != nil {
+		t.Error(err)
+	} else if (len(inv) != 1) || (inv[0] != 1) {
+		t.Error("Wrong inventory:", inv)
+	}
+
+	if slugs := mr.Slugs(); (len(slugs) != 2) || (slugs["bonus"] != 5) {
+		t.Error("Wrong slugs:", slugs)
+	}
+	if titles := mr.Titles(); titles["bonus"] != "Bonus" {
+		t.Error("Wrong titles:", titles)
+	}
+
+	if ok, err := mr.CheckAnswer("", "bonus", "bonus"); err != nil {
+		t.Error(err)
+	} else if !ok {
+		t.Error("Correct answer not accepted")
+	}
+	if ok, _ := mr.CheckAnswer("", "also", "bonus"); ok {
+		t.Error("Answer to another puzzle accepted")
+	}
+	if _, err := mr.Puzzle(-1, "lib"); err == nil {
+		t.Error("Directory without a puzzle is in the mothball")
+	}
+}
@@ -0,0 +1,277 @@
+package transpile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Kinds of Change
+const (
+	PuzzleAdded       = "added"
+	PuzzleRemoved     = "removed"
+	BodyChanged       = "body"
+	AnswersChanged    = "answers"
+	MetadataChanged   = "metadata"
+	AttachmentChanged = "attachment"
+	VariantsChanged   = "variants"
+)
+
+// Change is a difference between two mothballs, found by DiffMothballs.
+type Change struct {
+	// Puzzle is the puzzle's directory in the mothball, like "10", "variants/2/10", or "bonus",
+	// the nonce of a token,
+	// or "variants.txt" for changes to variant assignments
+	Puzzle string
+
+	// What is the kind of change, like PuzzleAdded or AnswersChanged
+	What string
+
+	// Detail describes the change
+	Detail string `json:",omitempty"`
+}
+
+func (c Change) String() string {
+	if c.Detail == "" {
+		return fmt.Sprintf("%s: %s", c.Puzzle, c.What)
+	}
+	return fmt.Sprintf("%s: %s: %s", c.Puzzle, c.What, c.Detail)
+}
+
+// DiffMothballs returns every difference between the puzzles in two mothballs:
+// puzzles added and removed,
+// and changes to bodies, answers, other puzzle fields, and attachments.
+//
+// Per-team variants are compared too,
+// and so are the tokens in token mothballs.
+func DiffMothballs(before, after *MothballReader) ([]Change, error) {
+	oldList, err := before.PuzzleList()
+	if err != nil {
+		return nil, fmt.Errorf("old: %v", err)
+	}
+	newList, err := after.PuzzleList()
+	if err != nil {
+		return nil, fmt.Errorf("new: %v", err)
+	}
+	inOld := make(map[string]bool)
+	inNew := make(map[string]bool)
+	var all []PuzzleEntry
+	for _, entry := range oldList {
+		inOld[entry.ID()] = true
+		all = append(all, entry)
+	}
+	for _, entry := range newList {
+		inNew[entry.ID()] = true
+		if !inOld[entry.ID()] {
+			all = append(all, entry)
+		}
+	}
+	sortPuzzleList(all)
+
+	var changes []Change
+	if before.variants != after.variants {
+		changes = append(changes, Change{"variants.txt", VariantsChanged, fmt.Sprintf("%d variants, was %d", after.variants, before.variants)})
+	} else if !sameTeams(before.teams, after.teams) {
+		changes = append(changes, Change{"variants.txt", VariantsChanged, "team assignments changed"})
+	}
+
+	d := mothballDiff{before: before, after: after}
+	for _, entry := range all {
+		id := entry.ID()
+		if err := d.puzzle("", id, inOld[id], inNew[id]); err != nil {
+			return nil, err
+		}
+	}
+	nvariants := before.variants
+	if after.variants > nvariants {
+		nvariants = after.variants
+	}
+	for v := 0; v < nvariants; v++ {
+		prefix := fmt.Sprintf("variants/%d/", v)
+		for _, entry := range all {
+			id := entry.ID()
+			oldHas := inOld[id] && (before.variantPrefix(v, id) != "")
+			newHas := inNew[id] && (after.variantPrefix(v, id) != "")
+			if err := d.puzzle(prefix, id, oldHas, newHas); err != nil {
+				return nil, err
+			}
+		}
+	}
+	d.tokens()
+	return append(changes, d.changes...), nil
+}
+
+// tokens compares the tokens in two token mothballs.
+// Tokens which change point value are reported as metadata changes.
+func (d *mothballDiff) tokens() {
+	var nonces []string
+	for nonce := range d.before.tokens {
+		nonces = append(nonces, nonce)
+	}
+	for nonce := range d.after.tokens {
+		if _, ok := d.before.tokens[nonce]; !ok {
+			nonces = append(nonces, nonce)
+		}
+	}
+	sort.Strings(nonces)
+	for _, nonce := range nonces {
+		oldPoints, oldHas := d.before.tokens[nonce]
+		newPoints, newHas := d.after.tokens[nonce]
+		switch {
+		case !oldHas:
+			d.add(nonce, PuzzleAdded, "")
+		case !newHas:
+			d.add(nonce, PuzzleRemoved, "")
+		case oldPoints != newPoints:
+			d.add(nonce, MetadataChanged, "%d points, was %d", newPoints, oldPoints)
+		}
+	}
+}
+
+func sameTeams(a, b map[string]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for teamID, v := range a {
+		if bv, ok := b[teamID]; !ok || (bv != v) {
+			return false
+		}
+	}
+	return true
+}
+
+// mothballDiff collects changes between two mothballs.
+type mothballDiff struct {
+	before, after *MothballReader
+	changes       []Change
+}
+
+func (d *mothballDiff) add(dir string, what string, format string, a ...any) {
+	d.changes = append(d.changes, Change{dir, what, fmt.Sprintf(format, a...)})
+}
+
+// puzzle compares the puzzle with the given ID under prefix.
+func (d *mothballDiff) puzzle(prefix string, id string, oldHas bool, newHas bool) error {
+	dir := prefix + id
+	switch {
+	case !oldHas && !newHas:
+		return nil
+	case !oldHas:
+		d.add(dir, PuzzleAdded, "")
+		return nil
+	case !newHas:
+		d.add(dir, PuzzleRemoved, "")
+		return nil
+	}
+
+	op, err := d.before.puzzleAt(prefix, id)
+	if err != nil {
+		return fmt.Errorf("old: %v", err)
+	}
+	np, err := d.after.puzzleAt(prefix, id)
+	if err != nil {
+		return fmt.Errorf("new: %v", err)
+	}
+
+	if op.Body != np.Body {
+		d.add(dir, BodyChanged, "")
+	}
+
+	oldAnswers, err := d.before.answersAt(prefix, id)
+	if err != nil {
+		return fmt.Errorf("old: %v", err)
+	}
+	newAnswers, err := d.after.answersAt(prefix, id)
+	if err != nil {
+		return fmt.Errorf("new: %v", err)
+	}
+	if added, removed := difference(oldAnswers, newAnswers); (len(added) > 0) || (len(removed) > 0) {
+		var detail []string
+		for _, answer := range added {
+			detail = append(detail, fmt.Sprintf("added %q", answer))
+		}
+		for _, answer := range removed {
+			detail = append(detail, fmt.Sprintf("removed %q", answer))
+		}
+		d.add(dir, AnswersChanged, "%s", strings.Join(detail, ", "))
+	}
+
+	// Everything else in puzzle.json.
+	// Answer hashes follow the answers, and attachments are compared below.
+	oldFiles := append(op.Attachments, op.Scripts...)
+	newFiles := append(np.Attachments, np.Scripts...)
+	for _, p := range []*Puzzle{&op, &np} {
+		p.Body = ""
+		p.AnswerHashes = nil
+		p.Attachments = nil
+		p.Scripts = nil
+	}
+	oj, _ := json.Marshal(op)
+	nj, _ := json.Marshal(np)
+	if string(oj) != string(nj) {
+		d.add(dir, MetadataChanged, "")
+	}
+
+	added, removed := difference(oldFiles, newFiles)
+	for _, name := range added {
+		d.add(dir, AttachmentChanged, "added %s", name)
+	}
+	for _, name := range removed {
+		d.add(dir, AttachmentChanged, "removed %s", name)
+	}
+	for _, name := range newFiles {
+		filename := fmt.Sprintf("%s/%s", dir, name)
+		oldHash := d.before.fileHash(filename)
+		if oldHash == "" {
+			continue
+		}
+		if newHash := d.after.fileHash(filename); oldHash != newHash {
+			d.add(dir, AttachmentChanged, "changed %s", name)
+		}
+	}
+	return nil
+}
+
+// difference returns the strings in b but not a, and those in a but not b.
+func difference(a, b []string) (added []string, removed []string) {
+	inA := make(map[string]bool)
+	inB := make(map[string]bool)
+	for _, s := range a {
+		inA[s] = true
+	}
+	for _, s := range b {
+		inB[s] = true
+		if !inA[s] {
+			added = append(added, s)
+		}
+	}
+	for _, s := range a {
+		if !inB[s] {
+			removed = append(removed, s)
+		}
+	}
+	return added, removed
+}
+
+// fileHash returns the SHA-256 of a file in the mothball,
+// from the manifest if there is one.
+// It returns the empty string if the file can't be read.
+func (mr *MothballReader) fileHash(filename string) string {
+	if hash, ok := mr.manifest.Files[filename]; ok {
+		return hash
+	}
+	f, err := mr.Fs.Open(filename)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,86 @@
+package transpile
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func testMothballReader(t *testing.T, fs afero.Fs) *MothballReader {
+	mb := new(bytes.Buffer)
+	if err := Mothball(NewFsCategory(fs, "cat"), mb); err != nil {
+		t.Fatal(err)
+	}
+	mr, err := NewMothballReader(bytes.NewReader(mb.Bytes()), int64(mb.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mr
+}
+
+func TestDiffMothballs(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "cat/1/puzzle.md", testMothYaml, 0644)
+	afero.WriteFile(fs, "cat/1/moo.txt", []byte("Moo."), 0644)
+	afero.WriteFile(fs, "cat/2/puzzle.md", testMothMarkdown, 0644)
+	afero.WriteFile(fs, "cat/3/puzzle.md", testMothMarkdown, 0644)
+	before := testMothballReader(t, fs)
+
+	if changes, err := DiffMothballs(before, before); err != nil {
+		t.Error(err)
+	} else if len(changes) > 0 {
+		t.Error("Mothball differs from itself:", changes)
+	}
+
+	afero.WriteFile(fs, "cat/1/moo.txt", []byte("Moooo."), 0644)
+	afero.WriteFile(fs, "cat/2/puzzle.md", []byte("---\nanswers: [answer, other]\nauthors: [Fred]\n---\nNew body\n"), 0644)
+	fs.RemoveAll("cat/3")
+	afero.WriteFile(fs, "cat/4/puzzle.md", testMothMarkdown, 0644)
+	after := testMothballReader(t, fs)
+
+	changes, err := DiffMothballs(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{"1", AttachmentChanged, "changed moo.txt"},
+		{"2", BodyChanged, ""},
+		{"2", AnswersChanged, `added "other"`},
+		{"3", PuzzleRemoved, ""},
+		{"4", PuzzleAdded, ""},
+	}
+	if len(changes) != len(expected) {
+		t.Fatal("Wrong changes:", changes)
+	}
+	for i, change := range changes {
+		if change != expected[i] {
+			t.Errorf("Change %d: wanted %v, got %v", i, expected[i], change)
+		}
+	}
+}
+
+func TestMothballReaderAnswers(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "cat/1/puzzle.md", []byte("---\nanswers: [a, b c]\n---\nBody\n"), 0644)
+	mr := testMothballReader(t, fs)
+
+	answers, err := mr.Answers(-1, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(answers) != 2) || (answers[1] != "b c") {
+		t.Error("Wrong answers:", answers)
+	}
+
+	puzzle, err := mr.Puzzle(-1, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(puzzle.Answers) > 0 {
+		t.Error("Mothball puzzle has answers")
+	}
+	if len(puzzle.AnswerHashes) != 2 {
+		t.Error("Wrong answer hashes:", puzzle.AnswerHashes)
+	}
+}
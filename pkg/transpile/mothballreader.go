@@ -0,0 +1,366 @@
+package transpile
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/zipfs"
+)
+
+// MothballReader reads a mothball written by Mothball or MothballWithOptions.
+//
+// This is how production servers serve puzzles and check answers.
+type MothballReader struct {
+	afero.Fs
+
+	// Per-team variants of generated puzzles, from variants.txt
+	variants int
+	teams    map[string]int
+
+	// Hashes of every file, from manifest.json
+	manifest Manifest
+
+	// Format version and category description, from mothball.json
+	metadata MothballMetadata
+
+	// Every puzzle, from puzzles.json
+	puzzleList []PuzzleEntry
+
+	// Point values of tokens by nonce, from tokens.txt
+	tokens map[string]int
+}
+
+// NewMothballReader returns a MothballReader for the mothball in r.
+func NewMothballReader(r io.ReaderAt, size int64) (*MothballReader, error) {
+	zrc, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	mr := &MothballReader{
+		Fs: zipfs.New(zrc),
+	}
+	if err := mr.readVariants(); err != nil {
+		return nil, err
+	}
+	if err := mr.readManifest(); err != nil {
+		return nil, err
+	}
+	if err := mr.readMetadata(); err != nil {
+		return nil, err
+	}
+	if err := mr.readPuzzleList(); err != nil {
+		return nil, err
+	}
+	if err := mr.readTokens(); err != nil {
+		return nil, err
+	}
+	return mr, nil
+}
+
+// readPuzzleList loads puzzles.json, if there is one.
+func (mr *MothballReader) readPuzzleList() error {
+	f, err := mr.Fs.Open(PuzzleListFilename)
+	if err != nil {
+		// Older mothballs only have puzzles.txt
+		return nil
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&mr.puzzleList); err != nil {
+		return fmt.Errorf("%s: %v", PuzzleListFilename, err)
+	}
+	for _, entry := range mr.puzzleList {
+		if (entry.Slug != "") && !ValidSlug(entry.Slug) {
+			return fmt.Errorf("%s: invalid slug: %q", PuzzleListFilename, entry.Slug)
+		}
+	}
+	sortPuzzleList(mr.puzzleList)
+	return nil
+}
+
+// PuzzleList returns every puzzle in the mothball, ordered by point value, then by slug.
+// Mothballs without a puzzle list have one made from puzzles.txt.
+func (mr *MothballReader) PuzzleList() ([]PuzzleEntry, error) {
+	if mr.puzzleList != nil {
+		return mr.puzzleList, nil
+	}
+	inv, err := mr.Inventory()
+	list := make([]PuzzleEntry, len(inv))
+	for i, points := range inv {
+		list[i] = PuzzleEntry{Points: points}
+	}
+	return list, err
+}
+
+// Titles maps puzzle IDs to the titles of puzzles which have one.
+// Mothballs without a puzzle list return nil.
+func (mr *MothballReader) Titles() map[string]string {
+	if mr.puzzleList == nil {
+		return nil
+	}
+	titles := make(map[string]string)
+	for _, entry := range mr.puzzleList {
+		if entry.Title != "" {
+			titles[entry.ID()] = entry.Title
+		}
+	}
+	return titles
+}
+
+// Slugs maps the slug of every slug puzzle to its point value.
+func (mr *MothballReader) Slugs() map[string]int {
+	slugs := make(map[string]int)
+	for _, entry := range mr.puzzleList {
+		if entry.Slug != "" {
+			slugs[entry.Slug] = entry.Points
+		}
+	}
+	return slugs
+}
+
+// readMetadata loads mothball.json, if there is one.
+//
+// Mothballs in a format newer than MothballFormat can't be read.
+func (mr *MothballReader) readMetadata() error {
+	mr.metadata.Format = 1
+	f, err := mr.Fs.Open(MetadataFilename)
+	if err != nil {
+		// Format 1 mothballs have no metadata
+		return nil
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&mr.metadata); err != nil {
+		return fmt.Errorf("%s: %v", MetadataFilename, err)
+	}
+	if mr.metadata.Format > MothballFormat {
+		return fmt.Errorf("mothball format %d is newer than this program supports (%d)", mr.metadata.Format, MothballFormat)
+	}
+	return nil
+}
+
+// Metadata returns the mothball's metadata.
+// Mothballs built without any have format 1, and nothing else set.
+func (mr *MothballReader) Metadata() MothballMetadata {
+	return mr.metadata
+}
+
+// readManifest loads manifest.json, if there is one.
+func (mr *MothballReader) readManifest() error {
+	f, err := mr.Fs.Open(ManifestFilename)
+	if err != nil {
+		// Mothballs built before manifests have none
+		return nil
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&mr.manifest); err != nil {
+		return fmt.Errorf("%s: %v", ManifestFilename, err)
+	}
+	return nil
+}
+
+// Manifest returns the mothball's manifest.
+// Mothballs built without one return the zero value.
+func (mr *MothballReader) Manifest() Manifest {
+	return mr.manifest
+}
+
+// Version returns a hash of the mothball's contents, from its manifest,
+// or the empty string if it has no manifest.
+func (mr *MothballReader) Version() string {
+	if len(mr.manifest.Files) == 0 {
+		return ""
+	}
+	return mr.manifest.Version()
+}
+
+// readVariants loads variants.txt, if there is one.
+func (mr *MothballReader) readVariants() error {
+	f, err := mr.Fs.Open("variants.txt")
+	if err != nil {
+		// No variants: everybody gets the same puzzles
+		return nil
+	}
+	defer f.Close()
+
+	mr.teams = make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	if scanner.Scan() {
+		if mr.variants, err = strconv.Atoi(scanner.Text()); err != nil {
+			return fmt.Errorf("variants.txt: %v", err)
+		}
+	}
+	for scanner.Scan() {
+		var teamID string
+		var variant int
+		if _, err := fmt.Sscanf(scanner.Text(), "%s %d", &teamID, &variant); err != nil {
+			return fmt.Errorf("variants.txt: %v", err)
+		}
+		mr.teams[teamID] = variant
+	}
+	return scanner.Err()
+}
+
+// Variants returns how many per-team variants the mothball has.
+func (mr *MothballReader) Variants() int {
+	return mr.variants
+}
+
+// Variant returns the variant assigned to teamID,
+// or -1 if the mothball has no variants.
+func (mr *MothballReader) Variant(teamID string) int {
+	if mr.variants == 0 {
+		return -1
+	}
+	if v, ok := mr.teams[teamID]; ok {
+		return v
+	}
+	return VariantIndex(teamID, mr.variants)
+}
+
+// prefix returns the path prefix for teamID's variant of the puzzle with the given ID.
+// If there's no variant of that puzzle, it returns the empty string.
+func (mr *MothballReader) prefix(teamID string, id string) string {
+	return mr.variantPrefix(mr.Variant(teamID), id)
+}
+
+// variantPrefix returns the path prefix for variant v of the puzzle with the given ID.
+// If there's no such variant, it returns the empty string.
+func (mr *MothballReader) variantPrefix(v int, id string) string {
+	if v < 0 {
+		return ""
+	}
+	prefix := fmt.Sprintf("variants/%d/", v)
+	if _, err := mr.Fs.Stat(prefix + id + "/puzzle.json"); err != nil {
+		return ""
+	}
+	return prefix
+}
+
+// PuzzleVariants returns the variants which have their own copy of the puzzle with the given ID.
+func (mr *MothballReader) PuzzleVariants(id string) []int {
+	var ret []int
+	for v := 0; v < mr.variants; v++ {
+		if mr.variantPrefix(v, id) != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// Inventory returns the point values of every puzzle in the mothball
+// named by its point value.
+// Use PuzzleList to get slug puzzles too.
+//
+// If puzzles.txt has lines which aren't point values,
+// an error is returned along with every point value that could be read.
+func (mr *MothballReader) Inventory() ([]int, error) {
+	pf, err := mr.Fs.Open("puzzles.txt")
+	if err != nil {
+		return nil, err
+	}
+	defer pf.Close()
+
+	// Bad lines are skipped, so one typo doesn't take out the whole category
+	inv := make([]int, 0, 20)
+	var badLine error
+	scanner := bufio.NewScanner(pf)
+	for scanner.Scan() {
+		points, err := strconv.Atoi(scanner.Text())
+		if err != nil {
+			badLine = fmt.Errorf("puzzles.txt: %v", err)
+			continue
+		}
+		inv = append(inv, points)
+	}
+	sort.Ints(inv)
+	if err := scanner.Err(); err != nil {
+		return inv, err
+	}
+	return inv, badLine
+}
+
+// OpenPuzzleFile opens teamID's copy of a file belonging to the puzzle with the given ID.
+func (mr *MothballReader) OpenPuzzleFile(teamID string, id string, filename string) (afero.File, error) {
+	if err := checkID(id); err != nil {
+		return nil, err
+	}
+	return mr.Fs.Open(mr.prefix(teamID, id) + id + "/" + filename)
+}
+
+// checkID returns an error if id can't be a puzzle ID.
+func checkID(id string) error {
+	if IsSlug(id) && !ValidSlug(id) {
+		return fmt.Errorf("invalid puzzle ID: %q", id)
+	}
+	return nil
+}
+
+// Puzzle reads variant v of the puzzle with the given ID.
+// The base puzzle is read if v is -1, or if v has no copy of its own.
+//
+// Puzzles in mothballs have had their answers removed:
+// use Answers for those.
+func (mr *MothballReader) Puzzle(v int, id string) (Puzzle, error) {
+	if err := checkID(id); err != nil {
+		return Puzzle{}, err
+	}
+	return mr.puzzleAt(mr.variantPrefix(v, id), id)
+}
+
+func (mr *MothballReader) puzzleAt(prefix string, id string) (Puzzle, error) {
+	var puzzle Puzzle
+	filename := prefix + id + "/puzzle.json"
+	f, err := mr.Fs.Open(filename)
+	if err != nil {
+		return puzzle, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&puzzle); err != nil {
+		return puzzle, fmt.Errorf("%s: %v", filename, err)
+	}
+	return puzzle, nil
+}
+
+// Answers returns the answers accepted for variant v of the puzzle with the given ID.
+// The base puzzle's answers are returned if v is -1, or if v has no copy of its own.
+func (mr *MothballReader) Answers(v int, id string) ([]string, error) {
+	return mr.answersAt(mr.variantPrefix(v, id), id)
+}
+
+func (mr *MothballReader) answersAt(prefix string, id string) ([]string, error) {
+	af, err := mr.Fs.Open(prefix + "answers.txt")
+	if err != nil {
+		return nil, fmt.Errorf("no answers.txt file")
+	}
+	defer af.Close()
+
+	var answers []string
+	needle := id + " "
+	scanner := bufio.NewScanner(af)
+	for scanner.Scan() {
+		if answer, found := strings.CutPrefix(scanner.Text(), needle); found {
+			answers = append(answers, answer)
+		}
+	}
+	return answers, scanner.Err()
+}
+
+// CheckAnswer returns whether answer is correct for the puzzle with the given ID.
+//
+// If teamID has its own variant of this puzzle,
+// only answers for that variant are accepted.
+func (mr *MothballReader) CheckAnswer(teamID string, id string, answer string) (bool, error) {
+	answers, err := mr.answersAt(mr.prefix(teamID, id), id)
+	for _, a := range answers {
+		if a == answer {
+			return true, nil
+		}
+	}
+	return false, err
+}
@@ -13,10 +13,8 @@ import (
 	"net/mail"
 	"os"
 	"os/exec"
-	"path"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/spf13/afero"
 	"gopkg.in/yaml.v2"
@@ -42,6 +40,12 @@ type Puzzle struct {
 	// Debug contains debugging information, omitted in mothballs
 	Debug PuzzleDebug
 
+	// Title is the puzzle's name, for display
+	Title string
+
+	// Points is how many points the puzzle is worth
+	Points int `json:",omitempty"`
+
 	// Authors names all authors of this puzzle
 	Authors []string
 
@@ -83,6 +87,24 @@ type Puzzle struct {
 	}
 }
 
+// RemoveSecrets clears answers and debugging information,
+// leaving only what a participant should see.
+func (puzzle *Puzzle) RemoveSecrets() {
+	puzzle.Answers = []string{}
+	puzzle.Debug.Errors = []string{}
+	puzzle.Debug.Hints = []string{}
+	puzzle.Debug.Log = []string{}
+	puzzle.Debug.Summary = ""
+}
+
+// defaultTitle fills in a missing Title from Extra,
+// which is where themes looked for titles before puzzles had one.
+func (puzzle *Puzzle) defaultTitle() {
+	if title, ok := puzzle.Extra["title"].(string); ok && (puzzle.Title == "") {
+		puzzle.Title = title
+	}
+}
+
 func (puzzle *Puzzle) computeAnswerHashes() {
 	if len(puzzle.Answers) == 0 {
 		return
@@ -97,6 +119,8 @@ func (puzzle *Puzzle) computeAnswerHashes() {
 
 // StaticPuzzle contains everything a static puzzle might tell us.
 type StaticPuzzle struct {
+	Title         string
+	Points        int
 	Authors       []string
 	Attachments   []StaticAttachment
 	Scripts       []StaticAttachment
@@ -159,6 +183,18 @@ type PuzzleProvider interface {
 
 // NewFsPuzzle returns a new FsPuzzle.
 func NewFsPuzzle(fs afero.Fs) PuzzleProvider {
+	return NewFsPuzzleSeed(fs, "")
+}
+
+// NewFsPuzzleSeed returns a new FsPuzzle.
+// If the puzzle is generated by mkpuzzle,
+// it is run with SEED set to seed.
+// An empty seed leaves SEED alone.
+func NewFsPuzzleSeed(fs afero.Fs, seed string) PuzzleProvider {
+	return newFsPuzzle(fs, seed, DefaultCommandConfig)
+}
+
+func newFsPuzzle(fs afero.Fs, seed string, config CommandConfig) PuzzleProvider {
 	var command string
 
 	bfs := NewRecursiveBasePathFs(fs, "")
@@ -176,7 +212,8 @@ func NewFsPuzzle(fs afero.Fs) PuzzleProvider {
 		return FsCommandPuzzle{
 			fs:      fs,
 			command: command,
-			timeout: 2 * time.Second,
+			seed:    seed,
+			config:  config,
 		}
 	}
 
@@ -188,7 +225,16 @@ func NewFsPuzzle(fs afero.Fs) PuzzleProvider {
 
 // NewFsPuzzlePoints returns a new FsPuzzle for points.
 func NewFsPuzzlePoints(fs afero.Fs, points int) PuzzleProvider {
-	return NewFsPuzzle(NewRecursiveBasePathFs(fs, strconv.Itoa(points)))
+	return NewFsPuzzlePointsSeed(fs, points, "")
+}
+
+// NewFsPuzzlePointsSeed returns a new FsPuzzle for points, generated with seed.
+func NewFsPuzzlePointsSeed(fs afero.Fs, points int, seed string) PuzzleProvider {
+	return NewFsPuzzleSeed(NewRecursiveBasePathFs(fs, strconv.Itoa(points)), seed)
+}
+
+func newFsPuzzlePoints(fs afero.Fs, points int, seed string, config CommandConfig) PuzzleProvider {
+	return newFsPuzzle(NewRecursiveBasePathFs(fs, strconv.Itoa(points)), seed, config)
 }
 
 // FsPuzzle is a single puzzle's directory.
@@ -209,8 +255,11 @@ func (fp FsPuzzle) Puzzle() (Puzzle, error) {
 	// Convert to an exportable Puzzle
 	puzzle.Debug = static.Debug
 	puzzle.Answers = static.Answers
+	puzzle.Title = static.Title
+	puzzle.Points = static.Points
 	puzzle.Authors = static.Authors
 	puzzle.Extra = static.Extra
+	puzzle.defaultTitle()
 	puzzle.Objective = static.Objective
 	puzzle.KSAs = static.KSAs
 	puzzle.Success = static.Success
@@ -232,7 +281,7 @@ func (fp FsPuzzle) Puzzle() (Puzzle, error) {
 // Open returns a newly-opened file.
 func (fp FsPuzzle) Open(name string) (ReadSeekCloser, error) {
 	empty := nopCloser{new(bytes.Reader)}
-	static, _, err := fp.staticPuzzle()
+	static, err := fp.staticHeader()
 	if err != nil {
 		return empty, err
 	}
@@ -254,18 +303,60 @@ func (fp FsPuzzle) Open(name string) (ReadSeekCloser, error) {
 	return fp.fs.Open(fsPath)
 }
 
+// staticPuzzle parses puzzle.md, rendering its body to HTML.
 func (fp FsPuzzle) staticPuzzle() (StaticPuzzle, []byte, error) {
-	r, err := fp.fs.Open("puzzle.md")
+	return fp.parse(true)
+}
+
+// staticHeader parses only the header of puzzle.md.
+//
+// This is a lot faster than staticPuzzle,
+// for when only the answers or attachments are needed.
+func (fp FsPuzzle) staticHeader() (StaticPuzzle, error) {
+	static, _, err := fp.parse(false)
+	return static, err
+}
+
+func (fp FsPuzzle) parse(render bool) (StaticPuzzle, []byte, error) {
+	src, err := fp.readSource()
+	if err != nil {
+		return StaticPuzzle{}, nil, err
+	}
+
+	static, err := src.static()
+	if (err != nil) || !render {
+		return static, nil, err
+	}
+
+	html := new(bytes.Buffer)
+	err = Markdown(bytes.NewReader(src.body), html)
+	return static, html.Bytes(), err
+}
+
+// puzzleSource is a puzzle.md file, split into its header and body.
+type puzzleSource struct {
+	filename   string
+	yaml       bool
+	header     []byte
+	headerLine int // line number of the first header line
+	body       []byte
+	bodyLine   int // line number of the first body line
+}
+
+// readSource reads puzzle.md (or puzzle.moth), and splits it into header and body.
+func (fp FsPuzzle) readSource() (puzzleSource, error) {
+	src := puzzleSource{filename: "puzzle.md", headerLine: 1}
+	r, err := fp.fs.Open(src.filename)
 	if err != nil {
 		var err2 error
-		if r, err2 = fp.fs.Open("puzzle.moth"); err2 != nil {
-			return StaticPuzzle{}, nil, err
+		src.filename = "puzzle.moth"
+		if r, err2 = fp.fs.Open(src.filename); err2 != nil {
+			return src, err
 		}
 	}
 	defer r.Close()
 
 	headerBuf := new(bytes.Buffer)
-	headerParser := rfc822HeaderParser
 	headerEnd := ""
 
 	scanner := bufio.NewScanner(r)
@@ -275,7 +366,8 @@ func (fp FsPuzzle) staticPuzzle() (StaticPuzzle, []byte, error) {
 		lineNo++
 		if lineNo == 1 {
 			if line == "---" {
-				headerParser = yamlHeaderParser
+				src.yaml = true
+				src.headerLine = 2
 				headerEnd = "---"
 				continue
 			}
@@ -287,23 +379,26 @@ func (fp FsPuzzle) staticPuzzle() (StaticPuzzle, []byte, error) {
 		headerBuf.WriteString(line)
 		headerBuf.WriteRune('\n')
 	}
+	src.header = headerBuf.Bytes()
+	src.bodyLine = lineNo + 1
 
 	bodyBuf := new(bytes.Buffer)
 	for scanner.Scan() {
 		line := scanner.Text()
-		lineNo++
 		bodyBuf.WriteString(line)
 		bodyBuf.WriteRune('\n')
 	}
+	src.body = bodyBuf.Bytes()
 
-	static, err := headerParser(headerBuf)
-	if err != nil {
-		return static, nil, err
-	}
+	return src, scanner.Err()
+}
 
-	html := new(bytes.Buffer)
-	err = Markdown(bodyBuf, html)
-	return static, html.Bytes(), err
+// static parses the header.
+func (src puzzleSource) static() (StaticPuzzle, error) {
+	if src.yaml {
+		return yamlHeaderParser(bytes.NewReader(src.header))
+	}
+	return rfc822HeaderParser(bytes.NewReader(src.header))
 }
 
 func legacyAttachmentParser(val []string) []StaticAttachment {
@@ -340,6 +435,12 @@ func rfc822HeaderParser(r io.Reader) (StaticPuzzle, error) {
 	for key, val := range m.Header {
 		key = strings.ToLower(key)
 		switch key {
+		case "title":
+			p.Title = val[0]
+		case "points":
+			if p.Points, err = strconv.Atoi(val[0]); err != nil {
+				return p, fmt.Errorf("points: %v", err)
+			}
 		case "author":
 			p.Authors = val
 		case "pattern":
@@ -374,7 +475,7 @@ func rfc822HeaderParser(r io.Reader) (StaticPuzzle, error) {
 
 // Answer checks whether the given answer is correct.
 func (fp FsPuzzle) Answer(answer string) bool {
-	p, _, err := fp.staticPuzzle()
+	p, err := fp.staticHeader()
 	if err != nil {
 		return false
 	}
@@ -390,16 +491,21 @@ func (fp FsPuzzle) Answer(answer string) bool {
 type FsCommandPuzzle struct {
 	fs      afero.Fs
 	command string
-	timeout time.Duration
+	seed    string
+	config  CommandConfig
+}
+
+func (fp FsCommandPuzzle) cmd(ctx context.Context, command string, args ...string) *exec.Cmd {
+	cmdargs := append([]string{command}, args...)
+	cmd := fp.config.Command(ctx, fp.command, seedEnv(fp.seed), cmdargs...)
+	return cmd
 }
 
 func (fp FsCommandPuzzle) run(command string, args ...string) ([]byte, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), fp.timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), fp.config.Timeout)
 	defer cancel()
 
-	cmdargs := append([]string{command}, args...)
-	cmd := exec.CommandContext(ctx, "./"+path.Base(fp.command), cmdargs...)
-	cmd.Dir = path.Dir(fp.command)
+	cmd := fp.cmd(ctx, command, args...)
 	out, err := cmd.Output()
 	if err, ok := err.(*exec.ExitError); ok {
 		stderr := strings.TrimSpace(string(err.Stderr))
@@ -424,11 +530,20 @@ func (fp FsCommandPuzzle) Puzzle() (Puzzle, error) {
 		return Puzzle{}, err
 	}
 
+	puzzle.defaultTitle()
 	puzzle.computeAnswerHashes()
 
 	return puzzle, nil
 }
 
+// seedEnv returns environment variables for a generator run with seed.
+func seedEnv(seed string) []string {
+	if seed == "" {
+		return nil
+	}
+	return []string{"SEED=" + seed}
+}
+
 type nopCloser struct {
 	io.ReadSeeker
 }
@@ -438,15 +553,16 @@ func (c nopCloser) Close() error {
 }
 
 // Open returns a newly-opened file.
-// BUG(neale): FsCommandPuzzle.Open() reads everything into memory, and will suck for large files.
+// Output is spooled to a temporary file, so large attachments don't fill up memory.
 func (fp FsCommandPuzzle) Open(filename string) (ReadSeekCloser, error) {
-	stdout, err := fp.run("file", filename)
-	buf := nopCloser{bytes.NewReader(stdout)}
+	ctx, cancel := context.WithTimeout(context.Background(), fp.config.FileTimeout)
+	defer cancel()
+
+	f, err := SpoolCommand(fp.cmd(ctx, "file", filename), fp.config.MaxFileSize)
 	if err != nil {
-		return buf, err
+		return nopCloser{new(bytes.Reader)}, err
 	}
-
-	return buf, nil
+	return f, nil
 }
 
 // Answer checks whether the given answer is correct.
@@ -0,0 +1,95 @@
+package transpile
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// SandboxConfig describes how to isolate generator programs from the server running them.
+// The zero value does no sandboxing.
+//
+// Puzzle trees are written by contributors,
+// so their mkpuzzle and mkcategory programs shouldn't be trusted
+// with the server's network access or files.
+type SandboxConfig struct {
+	// Isolate runs generators in new user, mount, PID, and network namespaces,
+	// so they can't reach the network, or see the server's processes.
+	// Generators run without any capabilities,
+	// so they can't undo the sandbox's mounts.
+	// This is only supported on Linux,
+	// and needs mount and setpriv from util-linux.
+	Isolate bool
+
+	// Hide lists directories which generators may not read.
+	// An empty filesystem is mounted over each one.
+	// This requires Isolate.
+	Hide []string
+
+	// Wrapper is a command which runs generators,
+	// with the generator and its arguments appended,
+	// such as bwrap or firejail.
+	Wrapper []string
+}
+
+// isZero returns true if sb does no sandboxing.
+func (sb SandboxConfig) isZero() bool {
+	return !sb.Isolate && (len(sb.Hide) == 0) && (len(sb.Wrapper) == 0)
+}
+
+// apply rewrites cmd to run inside the sandbox.
+//
+// If cmd can't be sandboxed, cmd.Err is set,
+// so the program never runs outside the sandbox.
+func (sb SandboxConfig) apply(cmd *exec.Cmd) {
+	if cmd.Err != nil {
+		return
+	}
+
+	if len(sb.Wrapper) > 0 {
+		wrapper, err := exec.LookPath(sb.Wrapper[0])
+		if err != nil {
+			cmd.Err = fmt.Errorf("sandbox wrapper: %v", err)
+			return
+		}
+		args := append([]string{}, sb.Wrapper...)
+		args = append(args, cmd.Path)
+		cmd.Args = append(args, cmd.Args[1:]...)
+		cmd.Path = wrapper
+	}
+
+	if (len(sb.Hide) > 0) && !sb.Isolate {
+		cmd.Err = fmt.Errorf("sandbox: hiding directories requires isolation")
+		return
+	}
+
+	if sb.Isolate {
+		setpriv, err := exec.LookPath("setpriv")
+		if err != nil {
+			cmd.Err = fmt.Errorf("sandbox: %v", err)
+			return
+		}
+
+		// A fresh /proc only shows processes in the sandbox,
+		// so hidden directories can't be reached through /proc/PID/root.
+		script := "set -e; mount --make-rprivate /; mount -t proc proc /proc; "
+		for _, dir := range sb.Hide {
+			script += fmt.Sprintf("mount -t tmpfs -o ro,size=0 none %s; ", shellQuote(dir))
+		}
+		// We're root in the user namespace, with every capability in it.
+		// Drop them all, for good, so the generator can't unmount anything.
+		script += `exec "$0" --bounding-set=-all --inh-caps=-all --no-new-privs "$@"`
+		args := []string{"/bin/sh", "-c", script, setpriv, "--", cmd.Path}
+		cmd.Args = append(args, cmd.Args[1:]...)
+		cmd.Path = "/bin/sh"
+
+		if err := isolate(cmd); err != nil {
+			cmd.Err = fmt.Errorf("sandbox: %v", err)
+		}
+	}
+}
+
+// shellQuote quotes s for /bin/sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,26 @@
+package transpile
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// isolate runs cmd in new user, mount, PID, and network namespaces.
+//
+// Our user becomes root inside the namespace,
+// which lets the sandbox mount over hidden directories
+// before it drops its capabilities.
+func isolate(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{
+		{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+	}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{
+		{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package transpile
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// isolate can't create namespaces on this platform.
+func isolate(cmd *exec.Cmd) error {
+	return fmt.Errorf("isolation is not supported on %s", runtime.GOOS)
+}
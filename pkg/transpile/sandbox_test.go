@@ -0,0 +1,102 @@
+package transpile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSandboxWrapper(t *testing.T) {
+	sb := SandboxConfig{Wrapper: []string{"env", "MOTH_WRAPPED=yes"}}
+	cmd := exec.CommandContext(context.Background(), "/bin/sh", "-c", "echo $MOTH_WRAPPED")
+	sb.apply(cmd)
+	if out, err := cmd.Output(); err != nil {
+		t.Error(err)
+	} else if strings.TrimSpace(string(out)) != "yes" {
+		t.Error("Command didn't run in wrapper:", string(out))
+	}
+
+	sb = SandboxConfig{Wrapper: []string{"/nonexistent/moth-sandbox"}}
+	cmd = exec.Command("/bin/true")
+	sb.apply(cmd)
+	if err := cmd.Run(); err == nil {
+		t.Error("Command ran without its missing wrapper")
+	}
+}
+
+func TestSandboxHideRequiresIsolate(t *testing.T) {
+	sb := SandboxConfig{Hide: []string{"/tmp"}}
+	cmd := exec.Command("/bin/true")
+	sb.apply(cmd)
+	if err := cmd.Run(); err == nil {
+		t.Error("Directories hidden without isolation")
+	}
+}
+
+func TestSandboxIsolate(t *testing.T) {
+	probe := exec.Command("/bin/true")
+	SandboxConfig{Isolate: true}.apply(probe)
+	if err := probe.Run(); err != nil {
+		t.Skip("Isolation not available here:", err)
+	}
+
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("sekrit"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	public := filepath.Join(t.TempDir(), "public")
+	if err := os.WriteFile(public, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sb := SandboxConfig{Isolate: true, Hide: []string{dir}}
+	cmd := exec.Command("/bin/cat", public)
+	sb.apply(cmd)
+	if out, err := cmd.Output(); err != nil {
+		t.Error(err)
+	} else if string(out) != "hello" {
+		t.Error("Wrong contents for unhidden file:", string(out))
+	}
+
+	cmd = exec.Command("/bin/cat", secret)
+	sb.apply(cmd)
+	if out, err := cmd.Output(); err == nil {
+		t.Error("Hidden file was readable:", string(out))
+	}
+
+	// Root in the sandbox can't undo its mounts
+	cmd = exec.Command("/bin/sh", "-c", `umount "$1"; umount -l "$1"; cat "$1/secret"`, "sh", dir)
+	sb.apply(cmd)
+	if out, _ := cmd.Output(); strings.Contains(string(out), "sekrit") {
+		t.Error("Hidden file was readable after umount")
+	}
+
+	// The server's processes aren't in the sandbox's /proc
+	cmd = exec.Command("/bin/cat", fmt.Sprintf("/proc/%d/root%s", os.Getpid(), secret))
+	sb.apply(cmd)
+	if out, err := cmd.Output(); err == nil {
+		t.Error("Hidden file was readable through /proc:", string(out))
+	}
+
+	cmd = exec.Command("/bin/grep", "^CapEff:", "/proc/self/status")
+	sb.apply(cmd)
+	if out, err := cmd.Output(); err != nil {
+		t.Error(err)
+	} else if fields := strings.Fields(string(out)); (len(fields) != 2) || (strings.Trim(fields[1], "0") != "") {
+		t.Error("Generator has capabilities:", string(out))
+	}
+
+	cmd = exec.Command("/bin/cat", "/proc/net/dev")
+	sb.apply(cmd)
+	if out, err := cmd.Output(); err != nil {
+		t.Error(err)
+	} else if strings.Contains(string(out), "eth0") {
+		t.Error("Network interfaces visible in sandbox")
+	}
+}
@@ -0,0 +1,102 @@
+package transpile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// spoolFile is a temporary file which is removed when it's closed.
+type spoolFile struct {
+	*os.File
+}
+
+// Close closes and removes the temporary file.
+func (f spoolFile) Close() error {
+	err := f.File.Close()
+	if rerr := os.Remove(f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// limitWriter writes to w until limit bytes have been written,
+// then refuses to write any more.
+type limitWriter struct {
+	w        io.Writer
+	limit    int64
+	written  int64
+	exceeded bool
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	if (lw.limit > 0) && (lw.written+int64(len(p)) > lw.limit) {
+		lw.exceeded = true
+		return 0, fmt.Errorf("output exceeds %d bytes", lw.limit)
+	}
+	n, err := lw.w.Write(p)
+	lw.written += int64(n)
+	return n, err
+}
+
+// Spool copies r to a temporary file.
+//
+// This keeps large files out of memory.
+// The returned file is removed when it's closed.
+// If r has more than maxSize bytes, it fails.
+// A negative or zero maxSize means no limit.
+func Spool(r io.Reader, maxSize int64) (ReadSeekCloser, error) {
+	f, err := os.CreateTemp("", "moth-spool-")
+	if err != nil {
+		return nil, err
+	}
+	spool := spoolFile{f}
+
+	if _, err := io.Copy(&limitWriter{w: f, limit: maxSize}, r); err != nil {
+		spool.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		spool.Close()
+		return nil, err
+	}
+	return spool, nil
+}
+
+// SpoolCommand runs cmd, spooling its standard output to a temporary file.
+//
+// This keeps large attachments out of memory.
+// The returned file is removed when it's closed.
+// If cmd writes more than maxSize bytes, it fails.
+// A negative or zero maxSize means no limit.
+func SpoolCommand(cmd *exec.Cmd, maxSize int64) (ReadSeekCloser, error) {
+	f, err := os.CreateTemp("", "moth-spool-")
+	if err != nil {
+		return nil, err
+	}
+	spool := spoolFile{f}
+
+	stdout := &limitWriter{w: f, limit: maxSize}
+	stderr := new(bytes.Buffer)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		spool.Close()
+		if stdout.exceeded {
+			return nil, fmt.Errorf("%s: output exceeds %d bytes", cmd.Path, maxSize)
+		}
+		if exerr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("%s (%s)", strings.TrimSpace(stderr.String()), exerr.String())
+		}
+		return nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		spool.Close()
+		return nil, err
+	}
+	return spool, nil
+}
@@ -0,0 +1,72 @@
+package transpile
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	f, err := Spool(strings.NewReader("moo"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := f.(spoolFile).Name()
+	if buf, err := ioutil.ReadAll(f); err != nil {
+		t.Error(err)
+	} else if string(buf) != "moo" {
+		t.Errorf("Wrong contents: %#v", string(buf))
+	}
+	f.Close()
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Error("Spool file not removed on close:", name)
+	}
+
+	if _, err := Spool(strings.NewReader("moooo"), 3); err == nil {
+		t.Error("Oversized input should have failed")
+	}
+	if f, err := Spool(strings.NewReader("moooo"), -1); err != nil {
+		t.Error("No limit still limited:", err)
+	} else {
+		f.Close()
+	}
+}
+
+func TestSpoolCommand(t *testing.T) {
+	f, err := SpoolCommand(exec.Command("sh", "-c", "echo moo"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := f.(spoolFile).Name()
+	if buf, err := ioutil.ReadAll(f); err != nil {
+		t.Error(err)
+	} else if string(buf) != "moo\n" {
+		t.Errorf("Wrong output: %#v", string(buf))
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Error("Spooled output can't seek:", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Error("Spool file not removed on close:", name)
+	}
+
+	if _, err := SpoolCommand(exec.Command("sh", "-c", "head -c 1000 /dev/zero"), 100); err == nil {
+		t.Error("Oversized output should have failed")
+	}
+	if f, err := SpoolCommand(exec.Command("sh", "-c", "head -c 1000 /dev/zero"), 0); err != nil {
+		t.Error("Zero limit should mean no limit:", err)
+	} else {
+		f.Close()
+	}
+
+	if _, err := SpoolCommand(exec.Command("sh", "-c", "echo oh no >&2; exit 1"), 100); err == nil {
+		t.Error("Failing command didn't return an error")
+	} else if err.Error() != "oh no (exit status 1)" {
+		t.Errorf("Wrong error: %#v", err.Error())
+	}
+}
@@ -0,0 +1,255 @@
+package transpile
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TokensFilename is the name of the token list inside a token mothball.
+//
+// Each line has a token's point value and nonce.
+const TokensFilename = "tokens.txt"
+
+// DefaultTokenSize is how many random bytes go into a token's nonce,
+// unless NewToken is told otherwise.
+const DefaultTokenSize = 16
+
+// MinTokenSize is the smallest nonce, in bytes, that's safe to hand out.
+//
+// Nothing limits how fast a team can try tokens,
+// so a team could guess every nonce any smaller than this during an event.
+const MinTokenSize = 8
+
+// Token is worth points in a category, to each team that redeems it.
+//
+// Tokens are written "category:points:nonce",
+// and can be handed out anywhere: on slips of paper, in other games, projected onto screens...
+type Token struct {
+	Category string
+	Points   int
+
+	// Nonce makes the token hard to guess.
+	// Nonces are valid slugs.
+	Nonce string
+}
+
+func (t Token) String() string {
+	return fmt.Sprintf("%s:%d:%s", t.Category, t.Points, t.Nonce)
+}
+
+// ParseToken parses a token written "category:points:nonce".
+func ParseToken(s string) (Token, error) {
+	fields := strings.Split(strings.TrimSpace(s), ":")
+	if len(fields) != 3 {
+		return Token{}, fmt.Errorf("malformed token: %q", s)
+	}
+	points, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return Token{}, fmt.Errorf("malformed token: %q: %v", s, err)
+	}
+	t := Token{
+		Category: fields[0],
+		Points:   points,
+		Nonce:    fields[2],
+	}
+	switch {
+	case t.Category == "":
+		return t, fmt.Errorf("malformed token: %q: no category", s)
+	case t.Points <= 0:
+		return t, fmt.Errorf("malformed token: %q: points must be positive", s)
+	case !ValidSlug(t.Nonce):
+		return t, fmt.Errorf("malformed token: %q: invalid nonce", s)
+	}
+	return t, nil
+}
+
+// ReadTokens reads tokens, one per line.
+// Blank lines are skipped.
+func ReadTokens(r io.Reader) ([]Token, error) {
+	var tokens []Token
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		t, err := ParseToken(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, scanner.Err()
+}
+
+// NewToken returns a token with a nonce made from size bytes of cryptographic randomness.
+func NewToken(category string, points int, size int) (Token, error) {
+	return newToken(rand.Reader, category, points, size)
+}
+
+func newToken(r io.Reader, category string, points int, size int) (Token, error) {
+	if size <= 0 {
+		return Token{}, fmt.Errorf("token size must be positive")
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return Token{}, err
+	}
+	return Token{
+		Category: category,
+		Points:   points,
+		Nonce:    bubbleBabble(buf),
+	}, nil
+}
+
+// bubbleBabble encodes b with the Bubble Babble encoding,
+// which alternates consonants and vowels so it's easy to read out loud.
+//
+// The output only has lowercase letters and dashes, and starts with "x",
+// so it's always a valid slug.
+func bubbleBabble(b []byte) string {
+	const vowels = "aeiouy"
+	const consonants = "bcdfghklmnprstvzx"
+
+	var sb strings.Builder
+	seed := 1
+	rounds := len(b)/2 + 1
+	sb.WriteByte('x')
+	for i := 0; i < rounds; i++ {
+		if (i+1 < rounds) || (len(b)%2 != 0) {
+			b0 := int(b[2*i])
+			sb.WriteByte(vowels[(((b0>>6)&3)+seed)%6])
+			sb.WriteByte(consonants[(b0>>2)&15])
+			sb.WriteByte(vowels[((b0&3)+seed/6)%6])
+			if i+1 < rounds {
+				b1 := int(b[2*i+1])
+				sb.WriteByte(consonants[(b1>>4)&15])
+				sb.WriteByte('-')
+				sb.WriteByte(consonants[b1&15])
+				seed = (seed*5 + b0*7 + b1) % 36
+			}
+		} else {
+			sb.WriteByte(vowels[seed%6])
+			sb.WriteByte(consonants[16])
+			sb.WriteByte(vowels[seed/6])
+		}
+	}
+	sb.WriteByte('x')
+	return sb.String()
+}
+
+// TokenMothball packages tokens up for a production server run.
+//
+// Token mothballs have no puzzles:
+// mothball.json says they're for tokens,
+// and tokens.txt lists every token's point value and nonce.
+// Every token must be in the same category,
+// which should also be the mothball's name.
+//
+// Only opts.Source and opts.Modified are used.
+func TokenMothball(tokens []Token, w io.Writer, opts MothballOptions) error {
+	mw := &mothballWriter{
+		zf:       zip.NewWriter(w),
+		modified: opts.Modified,
+		manifest: Manifest{
+			Source: opts.Source,
+			Files:  make(map[string]string),
+		},
+	}
+	if mw.modified.IsZero() {
+		mw.modified = MothballEpoch
+	}
+
+	sorted := append([]Token{}, tokens...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Points != sorted[j].Points {
+			return sorted[i].Points < sorted[j].Points
+		}
+		return sorted[i].Nonce < sorted[j].Nonce
+	})
+	seen := make(map[string]bool)
+	for _, t := range sorted {
+		if t.Category != sorted[0].Category {
+			return fmt.Errorf("tokens for more than one category: %s and %s", sorted[0].Category, t.Category)
+		}
+		if seen[t.Nonce] {
+			return fmt.Errorf("nonce used more than once: %s", t.Nonce)
+		}
+		seen[t.Nonce] = true
+	}
+
+	metadata := MothballMetadata{
+		Format:     MothballFormat,
+		Tokens:     true,
+		Transpiler: TranspilerVersion(),
+	}
+	if !opts.Modified.IsZero() {
+		built := opts.Modified.UTC()
+		metadata.Built = &built
+	}
+	mdf, err := mw.create(MetadataFilename)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(mdf).Encode(metadata); err != nil {
+		return err
+	}
+
+	// An empty puzzle list, so the category has no puzzles
+	if _, err := mw.create("puzzles.txt"); err != nil {
+		return err
+	}
+
+	tf, err := mw.create(TokensFilename)
+	if err != nil {
+		return err
+	}
+	for _, t := range sorted {
+		fmt.Fprintln(tf, t.Points, t.Nonce)
+	}
+
+	return mw.close()
+}
+
+// readTokens loads tokens.txt, if the mothball is for tokens.
+func (mr *MothballReader) readTokens() error {
+	if !mr.metadata.Tokens {
+		return nil
+	}
+	f, err := mr.Fs.Open(TokensFilename)
+	if err != nil {
+		return fmt.Errorf("token mothball has no %s", TokensFilename)
+	}
+	defer f.Close()
+
+	mr.tokens = make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var points int
+		var nonce string
+		if _, err := fmt.Sscanf(scanner.Text(), "%d %s", &points, &nonce); err != nil {
+			return fmt.Errorf("%s: %v", TokensFilename, err)
+		}
+		mr.tokens[nonce] = points
+	}
+	return scanner.Err()
+}
+
+// Tokens maps the nonce of every token in a token mothball to its point value.
+// Other mothballs return nil.
+func (mr *MothballReader) Tokens() map[string]int {
+	return mr.tokens
+}
+
+// CheckToken returns whether the mothball has a token worth points with the given nonce.
+func (mr *MothballReader) CheckToken(points int, nonce string) bool {
+	p, ok := mr.tokens[nonce]
+	return ok && (p == points)
+}
@@ -0,0 +1,145 @@
+package transpile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBubbleBabble(t *testing.T) {
+	for input, expected := range map[string]string{
+		"":           "xexax",
+		"1234567890": "xesef-disof-gytuf-katof-movif-baxux",
+		"Pineapple":  "xigak-nyryk-humil-bosek-sonax",
+	} {
+		if got := bubbleBabble([]byte(input)); got != expected {
+			t.Errorf("bubbleBabble(%q): wanted %q, got %q", input, expected, got)
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	tok, err := ParseToken(" scavenger:5:xylep-radar-nanox\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (tok.Category != "scavenger") || (tok.Points != 5) || (tok.Nonce != "xylep-radar-nanox") {
+		t.Error("Token parsed wrong:", tok)
+	}
+	if tok.String() != "scavenger:5:xylep-radar-nanox" {
+		t.Error("String conversion wonky:", tok.String())
+	}
+
+	for _, s := range []string{"", "scavenger:5", ":5:xylep", "scavenger:0:xylep", "scavenger:five:xylep", "scavenger:5:../xylep", "a:5:b:c"} {
+		if _, err := ParseToken(s); err == nil {
+			t.Error("Bad token parsed:", s)
+		}
+	}
+
+	tok, err = newToken(strings.NewReader("1234567890"), "scavenger", 3, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.String() != "scavenger:3:xesef-disof-gytuf-katof-movif-baxux" {
+		t.Error("Wrong token:", tok)
+	}
+	if _, err := newToken(strings.NewReader("12"), "scavenger", 3, 10); err == nil {
+		t.Error("Short read made a token")
+	}
+
+	a, _ := NewToken("scavenger", 1, DefaultTokenSize)
+	b, _ := NewToken("scavenger", 1, DefaultTokenSize)
+	if a.Nonce == b.Nonce {
+		t.Error("Random tokens are the same:", a, b)
+	}
+	if _, err := ParseToken(a.String()); err != nil {
+		t.Error("Can't parse a new token:", err)
+	}
+}
+
+func TestReadTokens(t *testing.T) {
+	tokens, err := ReadTokens(strings.NewReader("cat:1:xa\n\ncat:2:xb\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(tokens) != 2) || (tokens[1].Nonce != "xb") {
+		t.Error("Wrong tokens:", tokens)
+	}
+	if _, err := ReadTokens(strings.NewReader("cat:1:xa\nnot a token\n")); err == nil {
+		t.Error("Bad token list read")
+	} else if !strings.Contains(err.Error(), "line 2") {
+		t.Error("Error doesn't give the line:", err)
+	}
+}
+
+func TestTokenMothball(t *testing.T) {
+	read := func(tokens []Token) *MothballReader {
+		mb := new(bytes.Buffer)
+		if err := TokenMothball(tokens, mb, MothballOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		mr, err := NewMothballReader(bytes.NewReader(mb.Bytes()), int64(mb.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mr
+	}
+
+	tokens := []Token{
+		{"scavenger", 5, "xylep-radar-nanox"},
+		{"scavenger", 1, "xenod-relix"},
+		{"scavenger", 5, "xoter-darox"},
+	}
+	mr := read(tokens)
+	if metadata := mr.Metadata(); !metadata.Tokens || (metadata.Format != MothballFormat) {
+		t.Error("Wrong metadata:", metadata)
+	}
+	if inv, err := mr.Inventory(); err != nil {
+		t.Error(err)
+	} else if len(inv) != 0 {
+		t.Error("Token mothball has puzzles:", inv)
+	}
+	if len(mr.Tokens()) != 3 {
+		t.Error("Wrong tokens:", mr.Tokens())
+	}
+	if !mr.CheckToken(5, "xoter-darox") {
+		t.Error("Token not accepted")
+	}
+	if mr.CheckToken(1, "xoter-darox") {
+		t.Error("Token accepted for the wrong points")
+	}
+	if mr.CheckToken(5, "xenod-relix") {
+		t.Error("Token accepted for another token's points")
+	}
+
+	// Same tokens in a different order make the same mothball
+	if other := read([]Token{tokens[2], tokens[0], tokens[1]}); other.Version() != mr.Version() {
+		t.Error("Token order changed the version")
+	}
+
+	after := read([]Token{tokens[0], {"scavenger", 2, "xenod-relix"}, {"scavenger", 1, "xamab-bobex"}})
+	changes, err := DiffMothballs(mr, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{"xamab-bobex", PuzzleAdded, ""},
+		{"xenod-relix", MetadataChanged, "2 points, was 1"},
+		{"xoter-darox", PuzzleRemoved, ""},
+	}
+	if len(changes) != len(expected) {
+		t.Fatal("Wrong changes:", changes)
+	}
+	for i, change := range changes {
+		if change != expected[i] {
+			t.Errorf("Change %d: wanted %v, got %v", i, expected[i], change)
+		}
+	}
+
+	if err := TokenMothball([]Token{{"a", 1, "xa"}, {"b", 1, "xb"}}, new(bytes.Buffer), MothballOptions{}); err == nil {
+		t.Error("Tokens for two categories in one mothball")
+	}
+	if err := TokenMothball([]Token{{"a", 1, "xa"}, {"a", 2, "xa"}}, new(bytes.Buffer), MothballOptions{}); err == nil {
+		t.Error("Nonce used twice in one mothball")
+	}
+}
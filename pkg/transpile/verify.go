@@ -0,0 +1,123 @@
+package transpile
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DefaultDecoys are answers nobody should have declared,
+// which Verify makes sure are rejected.
+var DefaultDecoys = []string{"", " ", "moth-verify-decoy"}
+
+// Verify builds a mothball of c in memory,
+// then checks every puzzle's declared answers
+// using the same code production servers use to check answers.
+//
+// Every declared answer must be accepted by the puzzle's own checker and by the mothball.
+// Decoys, and every declared answer with "-decoy" tacked on,
+// must be rejected, unless they were also declared.
+// If opts has variants, each variant is checked against its own answers.
+func Verify(c Category, opts MothballOptions, decoys []string) ([]Problem, error) {
+	mb := new(bytes.Buffer)
+	if err := MothballWithOptions(c, mb, opts); err != nil {
+		return nil, err
+	}
+	mr, err := NewMothballReader(bytes.NewReader(mb.Bytes()), int64(mb.Len()))
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := mr.PuzzleList()
+	if err != nil {
+		return nil, err
+	}
+
+	l := new(linter)
+	if len(opts.Variants) == 0 {
+		for _, entry := range list {
+			l.verifyPuzzle(mr, c, "", "", entry.ID(), decoys)
+		}
+		return l.sorted(), nil
+	}
+
+	for v, variant := range opts.Variants {
+		teamID, ok := variantTeam(mr, opts, v)
+		if !ok {
+			l.add("variants.txt", 0, "no team is assigned variant %d", v)
+			continue
+		}
+		for _, entry := range list {
+			l.verifyPuzzle(mr, variant, teamID, fmt.Sprintf("variant %d: ", v), entry.ID(), decoys)
+		}
+	}
+	return l.sorted(), nil
+}
+
+// variantTeam returns a team ID which the mothball assigns to variant v.
+func variantTeam(mr *MothballReader, opts MothballOptions, v int) (string, bool) {
+	for teamID, tv := range opts.Teams {
+		if tv == v {
+			return teamID, true
+		}
+	}
+	// Teams not listed are assigned by hash: find one that lands on v
+	for i := 0; i < 1000*len(opts.Variants); i++ {
+		teamID := fmt.Sprintf("verify-%d", i)
+		if _, listed := opts.Teams[teamID]; !listed && (mr.Variant(teamID) == v) {
+			return teamID, true
+		}
+	}
+	return "", false
+}
+
+func (l *linter) verifyPuzzle(mr *MothballReader, c Category, teamID string, prefix string, id string, decoys []string) {
+	file := id
+	puzzle, err := CategoryPuzzle(c, id)
+	if err != nil {
+		l.add(file, 0, "%s%v", prefix, err)
+		return
+	}
+
+	declared := make(map[string]bool)
+	for _, answer := range puzzle.Answers {
+		declared[answer] = true
+		if !checkAnswer(c, id, answer) {
+			l.add(file, 0, "%sdeclared answer %q rejected by puzzle's checker", prefix, answer)
+		}
+		if ok, err := mr.CheckAnswer(teamID, id, answer); err != nil {
+			l.add(file, 0, "%s%v", prefix, err)
+		} else if !ok {
+			l.add(file, 0, "%sdeclared answer %q rejected by mothball", prefix, answer)
+		}
+	}
+	if len(puzzle.Answers) == 0 {
+		l.add(file, 0, "%sno answers declared", prefix)
+	}
+
+	candidates := append([]string{}, decoys...)
+	for _, answer := range puzzle.Answers {
+		candidates = append(candidates, answer+"-decoy")
+	}
+	for _, decoy := range candidates {
+		if declared[decoy] {
+			continue
+		}
+		if ok, err := mr.CheckAnswer(teamID, id, decoy); err != nil {
+			l.add(file, 0, "%s%v", prefix, err)
+		} else if ok {
+			l.add(file, 0, "%sdecoy answer %q accepted by mothball", prefix, decoy)
+		}
+	}
+}
+
+// checkAnswer asks the puzzle in c with the given ID whether answer is correct.
+//
+// FsCategory.Answer only looks at the declared answers,
+// so for those, the puzzle itself is asked,
+// which runs mkpuzzle if there is one.
+func checkAnswer(c Category, id string, answer string) bool {
+	if fc, ok := c.(FsCategory); ok {
+		return newFsPuzzle(NewRecursiveBasePathFs(fc.fs, id), fc.seed, fc.config).Answer(answer)
+	}
+	return CategoryAnswer(c, id, answer)
+}
@@ -0,0 +1,87 @@
+package transpile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestVerify(t *testing.T) {
+	fs := NewRecursiveBasePathFs(afero.NewOsFs(), "testdata")
+
+	// static/3/mkpuzzle declares "answer", but only accepts "moo"
+	if problems, err := Verify(NewFsCategory(fs, "static"), MothballOptions{}, DefaultDecoys); err != nil {
+		t.Error(err)
+	} else if (len(problems) != 1) || (problems[0].File != "3") {
+		t.Error("Wrong problems verifying static category:", problems)
+	}
+
+	opts := MothballOptions{
+		Variants: []Category{
+			NewFsCategorySeed(fs, "seeded", "alpha"),
+			NewFsCategorySeed(fs, "seeded", "beta"),
+		},
+	}
+	if problems, err := Verify(NewFsCategory(fs, "seeded"), opts, DefaultDecoys); err != nil {
+		t.Error(err)
+	} else if len(problems) > 0 {
+		t.Error("Problems verifying seeded variants:", problems)
+	}
+
+	problems, err := Verify(NewFsCategory(fs, "unverifiable"), MothballOptions{}, DefaultDecoys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(problems) != 1) || !strings.Contains(problems[0].Message, "rejected by puzzle's checker") {
+		t.Error("Wrong problems for unverifiable category:", problems)
+	}
+}
+
+func TestVerifyDecoys(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "cat/1/puzzle.md", []byte("---\nanswers: [moo, moo-decoy]\n---\n"), 0644)
+	afero.WriteFile(fs, "cat/2/puzzle.md", []byte("---\nanswers: [baa]\n---\n"), 0644)
+
+	problems, err := Verify(NewFsCategory(fs, "cat"), MothballOptions{}, []string{"baa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Error("Declared answers should never count as decoys:", problems)
+	}
+
+	afero.WriteFile(fs, "cat/3/puzzle.md", []byte("---\nanswers: []\n---\n"), 0644)
+	problems, err = Verify(NewFsCategory(fs, "cat"), MothballOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(problems) != 1) || (problems[0].File != "3") {
+		t.Error("Puzzle without answers not reported:", problems)
+	}
+}
+
+func TestMothballReader(t *testing.T) {
+	fs := NewRecursiveBasePathFs(afero.NewOsFs(), "testdata")
+	mb := new(strings.Builder)
+	if err := Mothball(NewFsCategory(fs, "static"), mb); err != nil {
+		t.Fatal(err)
+	}
+	mr, err := NewMothballReader(strings.NewReader(mb.String()), int64(mb.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv, err := mr.Inventory(); err != nil {
+		t.Error(err)
+	} else if len(inv) != 3 {
+		t.Error("Wrong inventory:", inv)
+	}
+	if mr.Variants() != 0 {
+		t.Error("Variants in a mothball without any")
+	}
+	if f, err := mr.OpenPuzzleFile("", "1", "puzzle.json"); err != nil {
+		t.Error(err)
+	} else {
+		f.Close()
+	}
+}
@@ -135,8 +135,18 @@ class App {
             let pdiv = element.appendChild(document.createElement("div"))
             pdiv.classList.add("category")
             
+            let info = this.state.CategoryInfo(cat)
             let h = pdiv.appendChild(document.createElement("h2"))
-            h.textContent = cat
+            if (info.Icon) {
+                let img = h.appendChild(document.createElement("img"))
+                img.classList.add("icon")
+                img.src = info.Icon
+                img.alt = ""
+            }
+            h.appendChild(document.createTextNode(info.Title || cat))
+            if (info.Title) {
+                h.title = cat
+            }
             
             // Extras if we're running a devel server
             if (this.state.DevelopmentMode()) {
@@ -147,13 +157,26 @@ class App {
                 a.title = "Download a compiled puzzle for this category"
             }
             
+            if (info.Description) {
+                let p = pdiv.appendChild(document.createElement("p"))
+                p.classList.add("description")
+                p.textContent = info.Description
+            }
+            if (info.Tags?.length) {
+                let tags = pdiv.appendChild(document.createElement("ul"))
+                tags.classList.add("tags")
+                for (let tag of info.Tags) {
+                    tags.appendChild(document.createElement("li")).textContent = tag
+                }
+            }
+
             // List out puzzles in this category
             let l = pdiv.appendChild(document.createElement("ul"))
             for (let puzzle of this.state.Puzzles(cat)) {
                 let i = l.appendChild(document.createElement("li"))
 
                 let url = new URL("puzzle.html", common.BaseURL)
-                url.hash = `${puzzle.Category}:${puzzle.Points}`
+                url.hash = `${puzzle.Category}:${puzzle.ID}`
                 let a = i.appendChild(document.createElement("a"))
                 a.textContent = puzzle.Points
                 a.href = url
@@ -163,7 +186,12 @@ class App {
                     a.classList.toggle("solved", this.state.IsSolved(puzzle))
                 }
                 if (this.config.PuzzleList?.Titles) {
-                    this.loadTitle(puzzle, i)
+                    if (this.state.TitlesByCategory) {
+                        this.showTitle(puzzle.Title, i)
+                    } else {
+                        // Older servers don't send titles
+                        this.loadTitle(puzzle, i)
+                    }
                 }
             }
 
@@ -178,16 +206,24 @@ class App {
     /**
      * Asynchronously loads a puzzle, in order to populate the title.
      * 
+     * This is only needed for servers which don't send titles in the state.
      * Calling this for every open puzzle will generate a lot of load on the server.
-     * If we decide we want this for a multi-participant server,
-     * we should implement some sort of cache.
      * 
      * @param {Puzzle} puzzle 
      * @param {Element} element 
      */
     async loadTitle(puzzle, element) {
         await puzzle.Populate()
-        let title = puzzle.Extra.title
+        this.showTitle(puzzle.Title || puzzle.Extra.title, element)
+    }
+
+    /**
+     * Adds a puzzle's title to its entry in the puzzles list.
+     * 
+     * @param {string} title 
+     * @param {Element} element 
+     */
+    showTitle(title, element) {
         if (!title) {
             return
         }
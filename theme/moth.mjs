@@ -93,7 +93,7 @@ class Hash {
  * A point award.
  */
 class Award {
-    constructor(when, teamid, category, points) {
+    constructor(when, teamid, category, points, slug="", extra={}) {
         /** Unix epoch timestamp for this award 
          * @type {number}
         */
@@ -110,13 +110,21 @@ class Award {
          * @type {number}
          */
         this.Points = points
+        /** Slug of the puzzle, if it isn't named by its point value
+         * @type {string}
+         */
+        this.Slug = slug
+        /** Extra fields, like why the award was made
+         * @type {Object.<string,string>}
+         */
+        this.Extra = extra
     }
 }
 
 /**
  * A puzzle.
  * 
- * A new Puzzle only knows its category and point value.
+ * A new Puzzle only knows its category, point value, and slug, if it has one.
  * If you want to populate it with meta-information, you must call Populate().
  * 
  * Parameters created by Populate are described in the server source code:
@@ -128,9 +136,10 @@ class Puzzle {
      * @param {Server} server 
      * @param {string} category 
      * @param {number} points 
+     * @param {string} slug Name of the puzzle, if it isn't named by its point value
      */
-    constructor (server, category, points) {
-        if (points < 1) {
+    constructor (server, category, points, slug="") {
+        if (!slug && (points < 1)) {
             throw(`Invalid points value: ${points}`)
         }
         
@@ -145,6 +154,12 @@ class Puzzle {
         /** Point value of this puzzle */
         this.Points = Number(points)
 
+        /** Slug of this puzzle, or the empty string if it's named by its point value */
+        this.Slug = String(slug)
+
+        /** ID of this puzzle, used in URLs: its slug, or its point value */
+        this.ID = this.Slug || String(this.Points)
+
         /** Error returned trying to retrieve this puzzle */
         this.Error = {
             /** Status code provided by server */
@@ -198,7 +213,7 @@ class Puzzle {
      * @returns {Promise.<Response>}
      */
     Get(filename) {
-        return this.server.GetContent(this.Category, this.Points, filename)
+        return this.server.GetContent(this.Category, this.ID, filename)
     }
 
     /**
@@ -240,7 +255,7 @@ class Puzzle {
      * @returns {Promise.<string>} Success message
      */
     SubmitAnswer(proposed) {
-        return this.server.SubmitAnswer(this.Category, this.Points, proposed)
+        return this.server.SubmitAnswer(this.Category, this.ID, proposed)
     }
 }
 
@@ -386,15 +401,34 @@ class State {
          */
         this.PointsByCategory = obj.Puzzles
 
+        /** Map from category name to its description, for categories which have one
+         * @type {Object.<string,Object>}
+         */
+        this.CategoryInfos = obj.Categories ?? {}
+
+        /** Map from category name to open slug puzzles' point values, by slug
+         * @type {Object.<string,Object.<string,number>>}
+         */
+        this.SlugsByCategory = obj.Slugs ?? {}
+
+        /** Map from category name to puzzle titles, by puzzle ID.
+         * Servers which don't send titles leave this undefined.
+         * @type {Object.<string,Object.<string,string>>}
+         */
+        this.TitlesByCategory = obj.Titles
+
         /** Log of points awarded
          * @type {Award[]}
          */
-        this.PointsLog = obj.PointsLog.map(entry => new Award(entry[0], entry[1], entry[2], entry[3]))
+        this.PointsLog = obj.PointsLog.map(entry => new Award(...entry))
     }
 
     /**
      * Returns a sorted list of open category names
      * 
+     * Categories are sorted by weight, lightest first,
+     * then by name.
+     * 
      * @returns {string[]} List of categories
      */
     Categories() {
@@ -402,10 +436,30 @@ class State {
         for (let category in this.PointsByCategory) {
             ret.push(category)
         }
-        ret.sort()
+        ret.sort((a, b) => {
+            let aw = this.CategoryInfo(a).Weight ?? 0
+            let bw = this.CategoryInfo(b).Weight ?? 0
+            if (aw != bw) {
+                return aw - bw
+            }
+            return a < b ? -1 : a > b ? 1 : 0
+        })
         return ret
     }
 
+    /**
+     * Returns a category's description.
+     * 
+     * This has Title, Description, Authors, Weight, Icon, and Tags,
+     * any of which might be missing.
+     * 
+     * @param {string} category
+     * @returns {Object}
+     */
+    CategoryInfo(category) {
+        return this.CategoryInfos[category] ?? {}
+    }
+
     /**
      * Check whether a category contains unsolved puzzles.
      * 
@@ -431,7 +485,7 @@ class State {
     /**
      * Return all open puzzles.
      * 
-     * The returned list will be sorted by (category, points).
+     * The returned list will be sorted by (category, points, slug).
      * If not categories are given, all puzzles will be returned.
      * 
      * @param {string} categories Limit results to these categories
@@ -443,12 +497,20 @@ class State {
         }
         let ret = []
         for (let category of categories) {
+            let puzzles = []
             for (let points of this.PointsByCategory[category]) {
                 if (0 == points) {
                     // This means all potential puzzles in the category are open
                     continue
                 }
-                let p = new Puzzle(this.server, category, points)
+                puzzles.push(new Puzzle(this.server, category, points))
+            }
+            for (let [slug, points] of Object.entries(this.SlugsByCategory[category] ?? {})) {
+                puzzles.push(new Puzzle(this.server, category, points, slug))
+            }
+            puzzles.sort((a, b) => (a.Points - b.Points) || (a.Slug < b.Slug ? -1 : a.Slug > b.Slug ? 1 : 0))
+            for (let p of puzzles) {
+                p.Title = this.TitlesByCategory?.[category]?.[p.ID]
                 ret.push(p)
             }
         }
@@ -467,6 +529,7 @@ class State {
             if (
                 (award.Category == puzzle.Category)
                 && (award.Points == puzzle.Points)
+                && (award.Slug == puzzle.Slug)
                 && (award.TeamID == teamID)
             ) {
                 return true
@@ -637,16 +700,35 @@ class Server {
      * proposed answer being rejected.
      *
      * @param {string} category Category of puzzle
-     * @param {number} points Point value of puzzle
+     * @param {number|string} id Point value or slug of puzzle
      * @param {string} proposed Answer to submit
      * @returns {Promise.<string>} Success message
      */
-    async SubmitAnswer(category, points, proposed) {
-        let data = await this.call("/answer", {
+    async SubmitAnswer(category, id, proposed) {
+        let args = {
             cat: category, 
-            points, 
             answer: proposed,
-        })
+        }
+        if (isNaN(id)) {
+            args.slug = id
+        } else {
+            args.points = id
+        }
+        let data = await this.call("/answer", args)
+        return data.description || data.short
+    }
+
+    /**
+     * Redeem a token for points.
+     *
+     * The returned promise will fail if anything goes wrong, including the
+     * token being unknown.
+     *
+     * @param {string} token Token to redeem, like "category:5:xylep-radar-nanox"
+     * @returns {Promise.<string>} Success message
+     */
+    async RedeemToken(token) {
+        let data = await this.call("/token", {token})
         return data.description || data.short
     }
 
@@ -654,26 +736,27 @@ class Server {
      * Fetch a file associated with a puzzle.
      * 
      * @param {string} category Category of puzzle
-     * @param {number} points Point value of puzzle
+     * @param {number|string} id Point value or slug of puzzle
      * @param {string} filename
      * @returns {Promise.<Response>}
      */
-    GetContent(category, points, filename) {
-        return this.fetch(`/content/${category}/${points}/${filename}`)
+    GetContent(category, id, filename) {
+        return this.fetch(`/content/${category}/${id}/${filename}`)
     }
 
     /**
      * Return a Puzzle object.
      * 
-     * New Puzzle objects only know their category and point value.
+     * New Puzzle objects only know their category, point value, and slug.
      * See docstrings on the Puzzle object for more information.
      * 
      * @param {string} category 
      * @param {number} points 
+     * @param {string} slug Name of the puzzle, if it isn't named by its point value
      * @returns {Puzzle}
      */
-    GetPuzzle(category, points) {
-        return new Puzzle(this, category, points)
+    GetPuzzle(category, points, slug="") {
+        return new Puzzle(this, category, points, slug)
     }
 }
 
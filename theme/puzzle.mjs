@@ -131,11 +131,11 @@ function writeObject(e, obj) {
  * Load the given puzzle.
  * 
  * @param {string} category 
- * @param {number} points 
+ * @param {string} id Point value or slug of the puzzle
  */
-async function loadPuzzle(category, points) {  
-    console.groupCollapsed("Loading puzzle:", category, points)
-    let contentBase = new URL(`content/${category}/${points}/`, common.BaseURL)
+async function loadPuzzle(category, id) {  
+    console.groupCollapsed("Loading puzzle:", category, id)
+    let contentBase = new URL(`content/${category}/${id}/`, common.BaseURL)
     
     // Tell user we're loading
     puzzleElement().appendChild(document.createElement("progress"))
@@ -145,7 +145,7 @@ async function loadPuzzle(category, points) {
         }
     }    
 
-    let puzzle = server.GetPuzzle(category, points)
+    let puzzle = isNaN(id) ? server.GetPuzzle(category, 0, id) : server.GetPuzzle(category, Number(id))
 
     console.time("Populate")
     try {
@@ -166,7 +166,10 @@ async function loadPuzzle(category, points) {
     baseElement.href = contentBase
 
     console.info("Tweaking HTML...")
-    let title = `${category} ${points}`
+    let title = `${category} ${puzzle.Points}`
+    if (puzzle.Title) {
+        title += `: ${puzzle.Title}`
+    }
     document.querySelector("title").textContent = title
     document.querySelector("#title").textContent = title
     document.querySelector("#authors").textContent = puzzle.Authors.join(", ")
@@ -199,7 +202,7 @@ async function loadPuzzle(category, points) {
         let codeBlocks = document.querySelectorAll("code[class^=language-]")
         for (let i = 0; i < codeBlocks.length; i++) {
             let codeBlock = codeBlocks[i]
-            let id = category + "#" + points + "#" + i
+            let id = category + "#" + puzzle.ID + "#" + i
             new workspace.Workspace(codeBlock, id, attachmentUrls)
         }
     })
@@ -221,6 +224,28 @@ async function loadPuzzle(category, points) {
     return puzzle
 }
 
+/**
+ * Reload the page whenever the server says this puzzle's category has changed.
+ *
+ * Only development servers send change events,
+ * so puzzle authors see their edits (or their mistakes) as soon as they save.
+ *
+ * @param {string} category
+ */
+async function watchForChanges(category) {
+    let state = await server.GetState()
+    if (!state.DevelopmentMode()) {
+        return
+    }
+    let source = new EventSource(new URL("changes", common.BaseURL))
+    source.addEventListener("change", event => {
+        if (event.data == category) {
+            console.info("Category changed on server, reloading")
+            location.reload()
+        }
+    })
+}
+
 const confettiPromise = import("https://cdn.jsdelivr.net/npm/canvas-confetti@1.9.2/+esm")
 async function CorrectAnswer() { 
     setInterval(window.close, 3 * common.Second)
@@ -257,15 +282,16 @@ async function init() {
     }
 
     let hashpart = location.hash.split("#")[1] || ""
-    let catpoints = hashpart.split(":")
-    let category = catpoints[0]
-    let points = Number(catpoints[1])
-    if (!category && !points) {
+    let catid = hashpart.split(":")
+    let category = catid[0]
+    let id = catid[1]
+    if (!category || !id) {
         error(`Doesn't look like a puzzle reference: ${hashpart}`)
         return
     }
 
-    window.app.puzzle = await loadPuzzle(category, points)
+    watchForChanges(category)
+    window.app.puzzle = await loadPuzzle(category, id)
 }
 
 common.WhenDOMLoaded(init)
@@ -15,22 +15,22 @@ async function formSubmitHandler(event) {
     event.preventDefault()
 
     let formData = new FormData(event.target)
-    let token = formData.get("token")
+    let token = formData.get("token").trim()
     let vals = token.split(":")
     let category = vals[0]
     let points = Number(vals[1])
-    let proposed = vals[2]
-    if (!category || !points || !proposed) {
+    let nonce = vals[2]
+    if (!category || !points || !nonce) {
         console.info("Not a token:", vals)
         common.Toast("This is not a properly-formed token")
         return
     }
     try {
-        let message = await server.SubmitAnswer(category, points, proposed)
+        let message = await server.RedeemToken(token)
         common.Toast(message)
     }
     catch (error) {
-        if (error.message == "incorrect answer") {
+        if ((error.message == "unknown token") || (error.message == "incorrect answer")) {
             common.Toast("Unknown token")
         } else {
             console.error(error)